@@ -19,7 +19,7 @@ func main() {
 	http.Handle("/myconn", h)
 	go http.ListenAndServe("localhost:8080", nil)
 
-	for c, ok := <-h.Conns; ok; c, ok = <-h.Conns {
+	for c := range h.Conns {
 		// New client c connected
 
 		// Send two messages, first the contents of the file, then a string
@@ -35,8 +35,12 @@ func main() {
 		}()
 
 		// Print all messages that arrives
-		for r, ok := <-c.In; ok; r, ok = <-c.In {
-			io.Copy(os.Stdout, r)
+		for msg, err := range c.Messages() {
+			if err != nil {
+				log.Println(err)
+				break
+			}
+			io.Copy(os.Stdout, msg)
 		}
 	}
 }
@@ -0,0 +1,20 @@
+package websocket
+
+// Abort the outgoing message being sent, if some of its frames are already
+// sent but not the last. A message can't be cut short within the protocol,
+// so the connection is closed with status 1011, after the frames already
+// queued. The message is reported as failed with errMessageAborted, once
+// a read from its reader in progress returns. Returns false, and does
+// nothing, if no message is partly sent.
+func (c *Conn) AbortMessage() (aborted bool) {
+	c.mu.Lock()
+	if c.partial != nil {
+		c.aborted = c.partial
+		aborted = true
+	}
+	c.mu.Unlock()
+	if aborted {
+		c.sendClose(newErrConnection(statusInternalError, "Outgoing message aborted"))
+	}
+	return
+}
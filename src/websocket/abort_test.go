@@ -0,0 +1,37 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestAbortMessage(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	if c.AbortMessage() {
+		t.Error("Aborted without a message being sent")
+	}
+	r, w := io.Pipe()
+	sent := make(chan error, 1)
+	c.Out <- &Message{Type: BinaryMessage, Reader: r, Sent: func(err error) { sent <- err }}
+	go w.Write(bytes.Repeat([]byte{'x'}, maxFramePayload))
+	// The first frame, not the last
+	header := make([]byte, 4)
+	io.ReadFull(client, header)
+	if !bytes.Equal(header, []byte{0x02, 0x7e, 0x00, maxFramePayload}) {
+		t.Fatalf("Unexpected frame header %X", header)
+	}
+	io.ReadFull(client, make([]byte, maxFramePayload))
+	for !c.AbortMessage() {
+		// Until the send loop has marked the message as partly sent
+	}
+	if payload := readShortFrame(t, client); payload != "\x03\xf3Outgoing message aborted" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	w.Write([]byte("more")) // Never sent
+	w.Close()
+	if err := <-sent; err != errMessageAborted {
+		t.Errorf("Expected %v, got %v", errMessageAborted, err)
+	}
+}
@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameArena(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetFrameArena(2)
+	if n := c.FrameArena(); n != 2 {
+		t.Errorf("Expected 2 slots, got %v", n)
+	}
+	payload := strings.Repeat("z", 3*maxFramePayload+1)
+	// More frames than slots, the others are allocated on the heap
+	c.Out <- &Message{Type: TextMessage, Reader: strings.NewReader(payload)}
+	var got bytes.Buffer
+	frames := 0
+	for fin := false; !fin; frames++ {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(client, header); err != nil {
+			t.Fatal(err)
+		}
+		fin = header[0]&0x80 != 0
+		length := int64(header[1])
+		if length == 0x7e {
+			ext := make([]byte, 2)
+			io.ReadFull(client, ext)
+			length = int64(binary.BigEndian.Uint16(ext))
+		}
+		io.CopyN(&got, client, length)
+	}
+	if got.String() != payload || frames != 4 {
+		t.Errorf("Unexpected payload of %v bytes in %v frames", got.Len(), frames)
+	}
+	c.mu.Lock()
+	a := c.arena
+	c.mu.Unlock()
+	for i := 0; len(a.free) != 2; i++ {
+		if i == 100 {
+			t.Fatalf("%v slots not given back", 2-len(a.free))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
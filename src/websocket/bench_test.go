@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// A server connection and a client connection on either end of a pipe
+func newConnPair() (server, client *Conn) {
+	s, cl := net.Pipe()
+	server = newConn(s, bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s)), true, nil)
+	client = newConn(cl, bufio.NewReadWriter(bufio.NewReader(cl), bufio.NewWriter(cl)), false, nil)
+	server.start()
+	client.start()
+	return
+}
+
+// Echo the messages of c until it is closed
+func echo(c *Conn) {
+	for r := range c.In {
+		m := r.(*Message)
+		c.Out <- &Message{Type: m.Type, Reader: m}
+	}
+}
+
+// Check that receiving a small text message, read into the caller's
+// buffer and recycled, doesn't allocate
+func TestReceiveAllocs(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	frame := []byte{0x81, 0x85, 0, 0, 0, 0, 'H', 'e', 'l', 'l', 'o'}
+	go func() {
+		for {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 16)
+	receive := func() {
+		m := (<-c.In).(*Message)
+		if n, _ := io.ReadFull(m, buf[:5]); n != 5 {
+			t.Fatalf("Short message of %v bytes", n)
+		}
+		m.Recycle()
+	}
+	receive() // Allocates the stream
+	if allocs := testing.AllocsPerRun(100, receive); allocs != 0 {
+		t.Errorf("Receiving a message allocated %v times", allocs)
+	}
+}
+
+func BenchmarkEcho(b *testing.B) {
+	server, client := newConnPair()
+	defer client.Close()
+	go echo(server)
+	payload := []byte("Hello, echo")
+	buf := make([]byte, len(payload))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.SendText(string(payload)); err != nil {
+			b.Fatal(err)
+		}
+		m := (<-client.In).(*Message)
+		io.ReadFull(m, buf)
+		m.Recycle()
+	}
+}
+
+func BenchmarkLargeMessage(b *testing.B) {
+	server, client := newConnPair()
+	defer client.Close()
+	payload := bytes.Repeat([]byte{0x2a}, 1<<20)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		go client.WriteSized(BinaryMessage, bytes.NewReader(payload), int64(len(payload)))
+		m := (<-server.In).(*Message)
+		if n, _ := io.Copy(ioutil.Discard, m); n != int64(len(payload)) {
+			b.Fatalf("Short message of %v bytes", n)
+		}
+	}
+}
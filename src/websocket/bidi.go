@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Encodes values to and decodes them from message payloads, such as
+// JSON or protocol buffers
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	MessageType() int // Of the messages carrying the encoded values
+}
+
+// Encodes values as JSON, in text messages
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) MessageType() int                           { return TextMessage }
+
+// A bidirectional stream of typed values on top of a connection, one value
+// per message, in the style of gRPC streaming, such as
+// grpc.BidiStreamingServer. Server code written against gRPC streams can
+// be moved over with few changes, to be reachable from browsers. Out is
+// the type of the values sent, and In of those received.
+type BidiStream[Out, In any] struct {
+	c        *Conn
+	codec    Codec
+	metadata http.Header
+	ctx      context.Context
+	rmu      sync.Mutex // Held during Recv
+	wmu      sync.Mutex // Held during Send
+
+	mu                         sync.Mutex // Protects the deadlines
+	recvDeadline, sendDeadline time.Time
+}
+
+// Stream typed values on c, encoded with codec, JSONCodec if nil. The
+// metadata is returned by Metadata, such as the header of the handshake
+// request. Takes over all messages on the connection.
+func NewBidiStream[Out, In any](c *Conn, codec Codec, metadata http.Header) (s *BidiStream[Out, In]) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.done
+		cancel()
+	}()
+	s = &BidiStream[Out, In]{c: c, codec: codec, metadata: metadata, ctx: ctx}
+	return
+}
+
+// The metadata the stream was created with, like the incoming metadata of
+// a gRPC stream
+func (s *BidiStream[Out, In]) Metadata() http.Header {
+	return s.metadata
+}
+
+// A context which is cancelled once the connection is closed
+func (s *BidiStream[Out, In]) Context() context.Context {
+	return s.ctx
+}
+
+// Send m as one message. Blocks until there is room in the send queue, or
+// the send deadline passes, in which case ErrTimeout is returned.
+func (s *BidiStream[Out, In]) Send(m Out) (err error) {
+	data, err := s.codec.Marshal(m)
+	if err != nil {
+		return
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.c.State() != OPEN {
+		err = errConnClosed
+		return
+	}
+	s.mu.Lock()
+	timer := deadlineTimer(s.sendDeadline)
+	s.mu.Unlock()
+	defer timer.Stop()
+	select {
+	case s.c.Out <- &Message{Type: s.codec.MessageType(), Reader: bytes.NewReader(data)}:
+	case <-s.c.done:
+		err = errConnClosed
+	case <-timer.C:
+		err = os.ErrDeadlineExceeded
+	}
+	return
+}
+
+// Wait for the next value and decode it. Returns io.EOF once the
+// connection is closed cleanly, the error which ended it otherwise, and
+// ErrTimeout if the receive deadline passes first. A value which fails to
+// decode is returned as an error, and the stream may still be used.
+func (s *BidiStream[Out, In]) Recv() (m In, err error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	s.mu.Lock()
+	timer := deadlineTimer(s.recvDeadline)
+	s.mu.Unlock()
+	defer timer.Stop()
+	var r io.Reader
+	select {
+	case msg, ok := <-s.c.In:
+		if !ok {
+			if err = s.c.Err(); err == nil {
+				err = io.EOF
+			}
+			return
+		}
+		r = msg
+	case <-timer.C:
+		err = os.ErrDeadlineExceeded
+		return
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	err = s.codec.Unmarshal(data, &m)
+	return
+}
+
+// Close the connection, which ends the stream in both directions
+func (s *BidiStream[Out, In]) Close() error {
+	return s.c.Close()
+}
+
+// Set the deadline of both Send and Recv, zero for none
+func (s *BidiStream[Out, In]) SetDeadline(t time.Time) {
+	s.mu.Lock()
+	s.recvDeadline, s.sendDeadline = t, t
+	s.mu.Unlock()
+}
+
+// Only applies to Recv calls made after the deadline is set
+func (s *BidiStream[Out, In]) SetRecvDeadline(t time.Time) {
+	s.mu.Lock()
+	s.recvDeadline = t
+	s.mu.Unlock()
+}
+
+// Only applies to Send calls made after the deadline is set
+func (s *BidiStream[Out, In]) SetSendDeadline(t time.Time) {
+	s.mu.Lock()
+	s.sendDeadline = t
+	s.mu.Unlock()
+}
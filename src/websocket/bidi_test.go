@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type streamValue struct {
+	N int `json:"n"`
+}
+
+func TestBidiStream(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	s := NewBidiStream[streamValue, streamValue](c, nil, http.Header{"X-Trace": {"abc"}})
+	if s.Metadata().Get("X-Trace") != "abc" {
+		t.Error("Metadata not kept")
+	}
+	go client.Write(append([]byte{0x81, 0x87, 0, 0, 0, 0}, `{"n":1}`...))
+	if m, err := s.Recv(); err != nil || m.N != 1 {
+		t.Errorf("Unexpected value %v (%v)", m, err)
+	}
+	go s.Send(streamValue{2})
+	if payload := readShortFrame(t, client); payload != `{"n":2}` {
+		t.Errorf("Unexpected payload %q", payload)
+	}
+	go client.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8})
+	readShortFrame(t, client) // The close reply
+	if _, err := s.Recv(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	<-s.Context().Done()
+	if err := s.Send(streamValue{3}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected a closed error, got %v", err)
+	}
+}
+
+func TestBidiStreamDeadline(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	s := NewBidiStream[streamValue, streamValue](c, nil, nil)
+	s.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := s.Recv(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
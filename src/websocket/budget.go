@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bytes"
+	"sync"
+)
+
+// How a Budget is enforced, any combination of these
+const (
+	RejectUpgrades = 1 << iota // Refuse new connections with 503
+	PauseReads                 // Stop reading from all connections
+	EvictHeaviest              // Close the connection buffering the most
+)
+
+var errOverBudget = classify(ErrQueueFull, "Memory budget exceeded")
+
+// A memory budget shared by all connections of a Handler. The buffered
+// bytes of a connection are its read and write buffers, and the outgoing
+// frames held in memory until they are written. While the connections
+// buffer more than Limit bytes in total, the budget is enforced according
+// to Policy. Reads are only paused while outgoing frames are waiting, as
+// that is what they release.
+type Budget struct {
+	Limit  int64
+	Policy int
+
+	mu     sync.Mutex
+	cond   *sync.Cond // Signaled when used drops
+	used   int64
+	frames int64 // Bytes of outgoing frames, part of used
+	conns  map[*Conn]*connUsage
+}
+
+// The bytes buffered by a connection
+type connUsage struct {
+	buffers, frames int64
+}
+
+func NewBudget(limit int64, policy int) (b *Budget) {
+	b = &Budget{
+		Limit:  limit,
+		Policy: policy,
+		conns:  make(map[*Conn]*connUsage),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return
+}
+
+// Total bytes buffered by the connections
+func (b *Budget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// True if new connections must be refused
+func (b *Budget) rejecting() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Policy&RejectUpgrades != 0 && b.used > b.Limit
+}
+
+// Start accounting for c, with its read and write buffers
+func (b *Budget) attach(c *Conn) {
+	c.budget = b
+	size := int64(c.rw.Reader.Size() + c.rw.Writer.Size())
+	b.mu.Lock()
+	b.conns[c] = &connUsage{buffers: size}
+	b.used += size
+	b.mu.Unlock()
+}
+
+// Stop accounting for a closed connection
+func (b *Budget) detach(c *Conn) {
+	b.mu.Lock()
+	if u, ok := b.conns[c]; ok {
+		b.used -= u.buffers + u.frames
+		b.frames -= u.frames
+		delete(b.conns, c)
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// Account for n more bytes of outgoing frames of c, n is negative when they
+// are written. Evicts the heaviest connection if that is the policy.
+func (b *Budget) add(c *Conn, n int64) {
+	var evict *Conn
+	b.mu.Lock()
+	u, ok := b.conns[c]
+	if !ok {
+		b.mu.Unlock()
+		return // Detached already
+	}
+	u.frames += n
+	b.frames += n
+	b.used += n
+	if n < 0 {
+		b.cond.Broadcast()
+	}
+	if b.used > b.Limit && b.Policy&EvictHeaviest != 0 {
+		var heaviest int64
+		for conn, u := range b.conns {
+			if u.buffers+u.frames > heaviest {
+				evict, heaviest = conn, u.buffers+u.frames
+			}
+		}
+	}
+	b.mu.Unlock()
+	if evict != nil {
+		evict.fail(errOverBudget)
+	}
+}
+
+// Block reading from c while over the budget, if that is the policy
+func (b *Budget) waitToRead(c *Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.Policy&PauseReads != 0 && b.used > b.Limit && b.frames > 0 {
+		if _, ok := b.conns[c]; !ok {
+			return
+		}
+		b.cond.Wait()
+	}
+}
+
+// Bytes of the payload of f held in memory
+func (f *frame) memory() int64 {
+	switch f.payload.(type) {
+	case *bytes.Buffer, *bytes.Reader:
+		return f.Len()
+	}
+	return 0
+}
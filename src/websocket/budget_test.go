@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Bytes accounted for a connection without outgoing frames
+const connBufferSize = 2 * 4096
+
+// Start a server side connection over a synchronous pipe, within budget b
+func newBudgetPipeConn(b *Budget) (c *Conn, client net.Conn) {
+	server, client := net.Pipe()
+	c = newConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), true, nil)
+	b.attach(c)
+	c.start()
+	return
+}
+
+func TestBudgetRejectUpgrades(t *testing.T) {
+	h := NewHandler()
+	h.Budget = NewBudget(1, RejectUpgrades)
+	client, resp := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("First connection refused: %v", resp.Status)
+	}
+	c := <-h.Conns
+	if used := h.Budget.Used(); used != connBufferSize {
+		t.Errorf("Expected %v bytes used, got %v", connBufferSize, used)
+	}
+	_, resp = handshake(t, h, newHandshakeRequest())
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 over the budget, got %v", resp.Status)
+	}
+	client.Close()
+	c.Wait()
+	if used := h.Budget.Used(); used != 0 {
+		t.Errorf("Expected nothing used after close, got %v", used)
+	}
+}
+
+func TestBudgetEvictHeaviest(t *testing.T) {
+	b := NewBudget(2*connBufferSize+1000, EvictHeaviest)
+	heavy, heavyClient := newBudgetPipeConn(b)
+	defer heavyClient.Close()
+	light, lightClient := newBudgetPipeConn(b)
+	defer lightClient.Close()
+	// Nothing is read by the client, so the frames stay in memory
+	for i := 0; i < 10; i++ {
+		if heavy.Send(&Message{Type: BinaryMessage, Reader: bytes.NewReader(make([]byte, 500))}) != nil {
+			break
+		}
+	}
+	if heavy.Wait() != errOverBudget {
+		t.Errorf("Expected the heavy connection evicted, got %v", heavy.Err())
+	}
+	if light.State() != OPEN {
+		t.Error("Light connection evicted")
+	}
+}
+
+func TestBudgetPauseReads(t *testing.T) {
+	b := NewBudget(2*connBufferSize+1000, PauseReads)
+	heavy, heavyClient := newBudgetPipeConn(b)
+	defer heavyClient.Close()
+	// Nothing is read by the client, so the frames stay in memory
+	for b.Used() <= b.Limit-connBufferSize {
+		heavy.Send(&Message{Type: BinaryMessage, Reader: bytes.NewReader(make([]byte, 500))})
+	}
+	c, client := newBudgetPipeConn(b)
+	defer client.Close()
+	go client.Write([]byte{0x81, 0x80, 0x00, 0x00, 0x00, 0x00})
+	select {
+	case <-c.In:
+		t.Fatal("Read while over the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	heavyClient.Close()
+	select {
+	case <-c.In:
+	case <-time.After(time.Second):
+		t.Fatal("Reading not resumed below the budget")
+	}
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// The outgoing messages and payload bytes queued but not yet flushed to
+// the network, for flow control such as to stop producing while a client
+// is too far behind. Messages count from when they are queued on Out until
+// their last frame is flushed, or they fail. Bytes are those of the frames
+// waiting to be written or flushed, and of the messages taken from Out
+// whose length is known up front, such as from SendText, SendBinary and
+// WriteSized or with a bytes.Reader, bytes.Buffer or strings.Reader. Both
+// are zero once the connection is closed.
+func (c *Conn) Buffered() (messages int, bytes int64) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	messages = len(c.out) + int(atomic.LoadInt64(&c.bufferedMessages))
+	bytes = atomic.LoadInt64(&c.bufferedBytes)
+	if bytes < 0 {
+		bytes = 0 // A queued message was read or grew before it was taken
+	}
+	return
+}
+
+// Count an outgoing message taken from Out as buffered
+func (c *Conn) bufferMessage(r io.Reader) {
+	atomic.AddInt64(&c.bufferedMessages, 1)
+	atomic.AddInt64(&c.bufferedBytes, knownLength(r))
+}
+
+// Stop counting an outgoing message which failed before it was sent
+func (c *Conn) unbufferMessage() {
+	atomic.AddInt64(&c.bufferedMessages, -1)
+}
+
+// The payload length of an outgoing message if known before it is read,
+// otherwise zero
+func knownLength(r io.Reader) int64 {
+	if req, ok := r.(*writeRequest); ok {
+		if req.payload != nil {
+			return int64(len(req.payload))
+		}
+		if req.size > 0 {
+			return req.size
+		}
+	}
+	if m := outgoingMessage(r); m != nil {
+		r = m.Reader
+	}
+	if l, ok := r.(interface{ Len() int }); ok {
+		return int64(l.Len())
+	}
+	return 0
+}
+
+// The payload bytes of a frame, zero for flush requests
+func (f *frame) buffered() int64 {
+	if f.flushed != nil {
+		return 0
+	}
+	return f.Len()
+}
@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wait for Buffered to report messages and bytes
+func expectBuffered(t *testing.T, c *Conn, messages int, bytes int64) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		m, b := c.Buffered()
+		if m == messages && b == bytes {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %v messages and %v bytes buffered, got %v and %v", messages, bytes, m, b)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	expectBuffered(t, c, 0, 0)
+	for i := 0; i < 3; i++ {
+		c.Out <- &Message{Type: TextMessage, Reader: strings.NewReader("hello")}
+	}
+	// The client isn't reading, so the first flush is stuck
+	expectBuffered(t, c, 3, 15)
+	io.ReadFull(client, make([]byte, 3*7))
+	expectBuffered(t, c, 0, 0)
+	client.Close()
+	for range c.In {
+	}
+	if m, b := c.Buffered(); m != 0 || b != 0 {
+		t.Errorf("Expected nothing buffered once closed, got %v and %v", m, b)
+	}
+}
@@ -0,0 +1,300 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errBadScheme                = classify(ErrHandshake, "Bad scheme, expected ws or wss")
+	errMalformedServerHandshake = classify(ErrHandshake, "Malformed handshake response from server")
+	errReservedHeader           = classify(ErrHandshake, "Reserved handshake header can not be set")
+	errHandshakeRefused         = classify(ErrHandshake, "Handshake refused by server")
+)
+
+// Maximum number of bytes kept from the body of a refused handshake
+const maxHandshakeBodyLength = 0x1000
+
+// A websocket client dialer
+type Dialer struct {
+	// If Jar is not nil, its cookies for the URL are sent with the handshake
+	// request, and cookies set by the handshake response are stored in it.
+	Jar http.CookieJar
+
+	// Additional headers sent with the handshake request, such as
+	// Authorization or User-Agent. A Host header overrides the URL host.
+	// Upgrade, Connection and Sec-WebSocket-* headers are set by the package,
+	// and Dial returns an error if they are present.
+	Header http.Header
+
+	// Subprotocols offered in the Sec-WebSocket-Protocol header, most
+	// preferred first. The server may select one of them.
+	Subprotocols []string
+
+	// Channel capacities of the connection, DefaultBuffers if nil
+	Buffers *Buffers
+
+	// If positive, the size limit of incoming messages, see
+	// Conn.SetReadLimit
+	ReadLimit int64
+
+	// If positive, single frame messages up to this size are delivered
+	// directly, see Conn.SetDirectDelivery
+	DirectDelivery int64
+
+	// How incoming messages are delivered, see Conn.SetDelivery
+	Delivery int
+
+	// If positive, the number of incoming messages in flight before reading
+	// stops, see Conn.SetInFlightLimit
+	InFlightLimit int
+
+	// If positive, the frames of outgoing messages are allocated from this
+	// many slots, see Conn.SetFrameArena
+	FrameArena int
+
+	// If not nil, pings are sent on this schedule, such as to keep the
+	// connection from being dropped as idle by a NAT or load balancer
+	Pings *PingSchedule
+
+	// Used for wss URLs, the ServerName defaults to the URL host. Set
+	// Certificates to authenticate the client with a certificate.
+	TLSConfig *tls.Config
+
+	// How strictly the server is held to the protocol, Strict by default
+	Compliance Compliance
+
+	// Source of the Sec-WebSocket-Key and the masking keys, crypto/rand if
+	// nil. Set it for deterministic tests, or to use a hardware generator.
+	// If reading from it fails, such as once a finite reader runs out, the
+	// connection is closed, and Err returns the error.
+	Rand io.Reader
+}
+
+// The dialer used by Dial
+var DefaultDialer = &Dialer{}
+
+// Open a websocket connection to urlStr using the DefaultDialer.
+func Dial(urlStr, origin string) (c *Conn, resp *http.Response, err error) {
+	return DefaultDialer.Dial(urlStr, origin)
+}
+
+// Open a websocket connection to urlStr using the DefaultDialer, within ctx
+func DialContext(ctx context.Context, urlStr, origin string) (c *Conn, resp *http.Response, err error) {
+	return DefaultDialer.DialContext(ctx, urlStr, origin)
+}
+
+// Open a websocket connection to urlStr, which must have the ws or wss scheme.
+// The origin is sent in the Origin header, unless it is empty.
+// Resp is the handshake response, if one was received. If the handshake is
+// refused, the error is errHandshakeRefused and the beginning of the response
+// body can still be read from resp.Body. Errors of the handshake match
+// ErrHandshake.
+func (d *Dialer) Dial(urlStr, origin string) (c *Conn, resp *http.Response, err error) {
+	return d.DialContext(context.Background(), urlStr, origin)
+}
+
+// Open a websocket connection like Dial, within ctx. It bounds every phase
+// of the handshake: resolving the host, connecting, the TLS handshake,
+// writing the request and reading the response. If ctx is done before the
+// handshake completes, whichever phase is in progress is interrupted, and
+// the error is that of ctx, such as context.DeadlineExceeded. Once the
+// connection is returned, ctx no longer affects it.
+func (d *Dialer) DialContext(ctx context.Context, urlStr, origin string) (c *Conn, resp *http.Response, err error) {
+	var u *url.URL
+	if u, err = url.Parse(urlStr); err != nil {
+		return
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = new(net.Dialer).DialContext(ctx, "tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = (&tls.Dialer{Config: d.tlsConfig(u)}).DialContext(ctx, "tcp", hostPort(u, "443"))
+	default:
+		err = errBadScheme
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return
+	}
+	// Interrupt the request or the response in progress once ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	c, resp, err = d.handshake(conn, u, origin)
+	if !stop() {
+		c, err = nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		err = wrapError(ErrHandshake, err)
+		return
+	}
+	c.start()
+	if d.Pings != nil {
+		c.SchedulePings(*d.Pings)
+	}
+	return
+}
+
+// The TLS configuration for u, with the ServerName set
+func (d *Dialer) tlsConfig(u *url.URL) (config *tls.Config) {
+	if d.TLSConfig == nil {
+		config = &tls.Config{}
+	} else {
+		config = d.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	return
+}
+
+// Perform the client side of the opening handshake on conn.
+func (d *Dialer) handshake(conn net.Conn, u *url.URL, origin string) (c *Conn, resp *http.Response, err error) {
+	secWSKey, err := newSecWebSocketKey(d.random())
+	if err != nil {
+		return
+	}
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, vs := range d.Header {
+		switch k = http.CanonicalHeaderKey(k); {
+		case reservedHeader(k):
+			err = errReservedHeader
+			return
+		case k == "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+		default:
+			req.Header[k] = append(req.Header[k], vs...)
+		}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", secWSKey)
+	req.Header.Set("Sec-WebSocket-Version", strconv.Itoa(secWSVersion))
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if d.Jar != nil {
+		for _, cookie := range d.Jar.Cookies(cookieURL(u)) {
+			req.AddCookie(cookie)
+		}
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if err = req.Write(rw); err != nil {
+		return
+	}
+	if err = rw.Flush(); err != nil {
+		return
+	}
+	if resp, err = http.ReadResponse(rw.Reader, req); err != nil {
+		return
+	}
+	if d.Jar != nil {
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			d.Jar.SetCookies(cookieURL(u), cookies)
+		}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Keep the body readable after the connection is closed
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxHandshakeBodyLength))
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		err = errHandshakeRefused
+		return
+	}
+	if !headerContainsToken(resp.Header, "Upgrade", "websocket") ||
+		!connectionUpgrade(resp.Header, d.Compliance) ||
+		resp.Header.Get("Sec-WebSocket-Accept") != secWebSocketAccept(secWSKey) ||
+		!d.offeredSubprotocol(resp.Header.Get("Sec-WebSocket-Protocol")) {
+		err = errMalformedServerHandshake
+		return
+	}
+	c = newConn(conn, rw, false, d.Buffers)
+	c.SetReadLimit(d.ReadLimit)
+	c.SetDirectDelivery(d.DirectDelivery)
+	c.SetDelivery(d.Delivery)
+	c.SetInFlightLimit(d.InFlightLimit)
+	c.SetFrameArena(d.FrameArena)
+	c.compliance = d.Compliance
+	if d.Rand != nil {
+		c.random = &lockedReader{r: d.Rand}
+	}
+	c.setNegotiated(resp.Header, secWSVersion)
+	return
+}
+
+// True if the subprotocol selected by the server was offered, or if none was
+// selected
+func (d *Dialer) offeredSubprotocol(selected string) bool {
+	if selected == "" {
+		return true
+	}
+	for _, p := range d.Subprotocols {
+		if p == selected {
+			return true
+		}
+	}
+	return false
+}
+
+// True if the header is set by the package during the client handshake
+func reservedHeader(k string) bool {
+	return k == "Upgrade" || k == "Connection" || strings.HasPrefix(k, "Sec-Websocket-")
+}
+
+// Generate a random, base64 encoded Sec-WebSocket-Key
+func newSecWebSocketKey(random io.Reader) (key string, err error) {
+	b := make([]byte, secWSKeyLength)
+	if _, err = io.ReadFull(random, b); err != nil {
+		return
+	}
+	key = base64.StdEncoding.EncodeToString(b)
+	return
+}
+
+// The host and port of u, using defaultPort if u has no port
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// The http(s) equivalent of a ws(s) URL, which is what cookie jars expect
+func cookieURL(u *url.URL) *url.URL {
+	httpURL := *u
+	if u.Scheme == "wss" {
+		httpURL.Scheme = "https"
+	} else {
+		httpURL.Scheme = "http"
+	}
+	return &httpURL
+}
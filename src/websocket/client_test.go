@@ -0,0 +1,303 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start a server which echoes all messages back to the client
+func setupEchoServer(t *testing.T, wrap func(http.Handler) http.Handler) (server *httptest.Server) {
+	h := NewHandler()
+	server = httptest.NewServer(wrap(h))
+	go func() {
+		for c := range h.Conns {
+			go func(c *Conn) {
+				for r := range c.In {
+					buf, _ := ioutil.ReadAll(r)
+					c.Out <- &Message{Type: r.(*Message).Type, Reader: bytes.NewBuffer(buf)}
+				}
+			}(c)
+		}
+	}()
+	return
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestDialEcho(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Out <- bytes.NewBufferString("Hello")
+	msg, err := ioutil.ReadAll(<-c.In)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "Hello" {
+		t.Errorf("Echoed message mismatch: %q", msg)
+	}
+}
+
+func TestDialBadScheme(t *testing.T) {
+	if _, _, err := Dial("http://localhost/", ""); err != errBadScheme {
+		t.Errorf("Expected errBadScheme, got %v", err)
+	}
+}
+
+func TestDialCookieJar(t *testing.T) {
+	var sent *http.Cookie
+	server := setupEchoServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent, _ = r.Cookie("session")
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: "yes"})
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer server.Close()
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(server.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "secret"}})
+	d := &Dialer{Jar: jar}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if sent == nil || sent.Value != "secret" {
+		t.Errorf("Session cookie not sent with handshake: %v", sent)
+	}
+	found := false
+	for _, cookie := range jar.Cookies(u) {
+		if cookie.Name == "seen" && cookie.Value == "yes" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Cookie from handshake response not stored in jar")
+	}
+}
+
+func TestDialHeader(t *testing.T) {
+	var auth, host string
+	server := setupEchoServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth, host = r.Header.Get("Authorization"), r.Host
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer server.Close()
+	d := &Dialer{Header: http.Header{
+		"Authorization": {"Bearer token"},
+		"Host":          {"example.com"},
+	}}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if auth != "Bearer token" {
+		t.Errorf("Authorization header not sent: %q", auth)
+	}
+	if host != "example.com" {
+		t.Errorf("Host header not overridden: %q", host)
+	}
+}
+
+func TestDialReservedHeader(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	for _, k := range []string{"Sec-WebSocket-Key", "sec-websocket-version", "Upgrade"} {
+		d := &Dialer{Header: http.Header{k: {"x"}}}
+		if _, _, err := d.Dial(wsURL(server), ""); err != errReservedHeader {
+			t.Errorf("Expected errReservedHeader for %v, got %v", k, err)
+		}
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "Slow down", http.StatusTooManyRequests)
+		})
+	})
+	defer server.Close()
+	_, resp, err := Dial(wsURL(server), "")
+	if err != errHandshakeRefused {
+		t.Fatalf("Expected errHandshakeRefused, got %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "10" {
+		t.Errorf("Unexpected response: %v %v", resp.StatusCode, resp.Header)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "Slow down\n" {
+		t.Errorf("Unexpected response body: %q", body)
+	}
+}
+
+func TestNegotiatedParameters(t *testing.T) {
+	h := NewHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Sec-WebSocket-Protocol", "chat")
+		w.Header().Set("Sec-WebSocket-Extensions", `x-foo; level="3"; fast, x-bar`)
+		h.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	d := &Dialer{Subprotocols: []string{"superchat", "chat"}}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-h.Conns
+	defer sc.Close()
+	expected := []Extension{
+		{Name: "x-foo", Params: map[string]string{"level": "3", "fast": ""}},
+		{Name: "x-bar", Params: map[string]string{}},
+	}
+	for _, conn := range []*Conn{c, sc} {
+		if conn.Subprotocol() != "chat" {
+			t.Errorf("Wrong subprotocol: %q", conn.Subprotocol())
+		}
+		if !reflect.DeepEqual(conn.Extensions(), expected) {
+			t.Errorf("Wrong extensions: %v", conn.Extensions())
+		}
+		if conn.Version() != 13 {
+			t.Errorf("Wrong version: %v", conn.Version())
+		}
+	}
+	if c.IsServer() || !sc.IsServer() {
+		t.Error("Wrong roles")
+	}
+}
+
+// Check that fully synchronous connections work on both sides.
+func TestUnbufferedConns(t *testing.T) {
+	h := NewHandler()
+	h.Buffers = &Buffers{}
+	server := httptest.NewServer(h)
+	defer server.Close()
+	go func() {
+		for c := range h.Conns {
+			for r := range c.In {
+				buf, _ := ioutil.ReadAll(r)
+				c.SendText(string(buf))
+			}
+		}
+	}()
+	d := &Dialer{Buffers: &Buffers{}}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if cap(c.in) != 0 || cap(c.out) != 0 || cap(c.send) != 0 {
+		t.Error("Connection is buffered")
+	}
+	for _, s := range []string{"Hello", "World"} {
+		if err = c.SendText(s); err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := ioutil.ReadAll(<-c.In)
+		if string(msg) != s {
+			t.Errorf("Echoed message mismatch: %q", msg)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	c, _, err := DialContext(ctx, wsURL(server), "")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SendText("Hello")
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "Hello" {
+		t.Errorf("Echoed message mismatch after the context is done: %q", msg)
+	}
+}
+
+func TestDialContextDeadline(t *testing.T) {
+	// Accepts connections, but never responds to the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	for _, scheme := range []string{"ws", "wss"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, _, err = DialContext(ctx, scheme+"://"+l.Addr().String()+"/", "")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%v: expected %v, got %v", scheme, context.DeadlineExceeded, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%v: deadline exceeded after %v", scheme, elapsed)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err = DialContext(ctx, "ws://"+l.Addr().String()+"/", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestDialerPings(t *testing.T) {
+	h := NewHandler()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	d := &Dialer{Pings: &PingSchedule{Interval: 5 * time.Millisecond, Payload: func() []byte { return []byte("hb") }}}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	pings := make(chan string, 1)
+	sc := <-h.Conns
+	sc.SetPingHandler(func(payload []byte) error {
+		select {
+		case pings <- string(payload):
+		default:
+		}
+		return nil
+	})
+	select {
+	case payload := <-pings:
+		if payload != "hb" {
+			t.Errorf("Unexpected ping payload %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("No ping received from the client")
+	}
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// The IP address of the client. Behind trusted proxies, see
+// Handler.TrustedProxies, this is the address they forwarded the request
+// for. Only known on the server side, the zero Addr on the client side.
+func (c *Conn) ClientIP() netip.Addr {
+	return c.clientIP
+}
+
+// The IP address of the client which sent r. The Forwarded header, or
+// X-Forwarded-For if there is none, is followed from the right while the
+// hop which added it is a trusted proxy.
+func (h *Handler) clientIP(r *http.Request) (ip netip.Addr) {
+	ip = parseHop(r.RemoteAddr)
+	if !h.trusted(ip) {
+		return
+	}
+	hops := forwardedFor(r.Header)
+	if hops == nil {
+		hops = headerTokens(r.Header, "X-Forwarded-For")
+	}
+	for i := len(hops) - 1; i >= 0 && h.trusted(ip); i-- {
+		hop := parseHop(hops[i])
+		if !hop.IsValid() {
+			break // Unknown or obfuscated, the proxy is the best we know
+		}
+		ip = hop
+	}
+	return
+}
+
+// True if ip is one of the trusted proxies
+func (h *Handler) trusted(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range h.TrustedProxies {
+		if p.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// The "for" parameters of the Forwarded header, in order, or nil if there
+// is none
+func forwardedFor(header http.Header) (hops []string) {
+	for _, element := range headerTokens(header, "Forwarded") {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, _ := strings.Cut(pair, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "for") {
+				hops = append(hops, strings.Trim(strings.TrimSpace(value), `"`))
+			}
+		}
+	}
+	return
+}
+
+// Parse an address of a hop, with or without a port, such as "192.0.2.1",
+// "192.0.2.1:4711", "[2001:db8::1]:4711" or "[2001:db8::1]". Returns the
+// zero Addr if it's not an IP address.
+func parseHop(s string) (ip netip.Addr) {
+	s = strings.TrimSpace(s)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	ip, _ = netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	return ip.Unmap()
+}
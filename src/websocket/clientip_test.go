@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	h := &Handler{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}}
+	tests := []struct {
+		remoteAddr, header, value, expected string
+	}{
+		{"192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "X-Forwarded-For", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "garbage", "10.0.0.1"},
+		{"10.0.0.1:1234", "Forwarded", `for=198.51.100.7;proto=https`, "198.51.100.7"},
+		{"10.0.0.1:1234", "Forwarded", `for="[2001:db8::1]:4711", for=198.51.100.7`, "198.51.100.7"},
+		{"[2001:db8::2]:1234", "Forwarded", `For="[2001:db9::1]:4711"`, "2001:db9::1"},
+		{"10.0.0.1:1234", "Forwarded", `for=unknown`, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		if ip := h.clientIP(r); ip.String() != test.expected {
+			t.Errorf("%v %v: %q, expected %v, got %v", test.remoteAddr, test.header, test.value, test.expected, ip)
+		}
+	}
+}
+
+func TestConnClientIP(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	defer client.Close()
+	if ip := (<-h.Conns).ClientIP(); ip != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("Expected the loopback address, got %v", ip)
+	}
+}
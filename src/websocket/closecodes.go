@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"errors"
+)
+
+// Status codes of close frames, see RFC 6455 section 7.4
+const (
+	CloseNormalClosure           = uint16(1000)
+	CloseGoingAway               = uint16(1001)
+	CloseProtocolError           = uint16(1002)
+	CloseUnsupportedData         = uint16(1003)
+	CloseNoStatusReceived        = uint16(1005) // Must not be sent in a close frame
+	CloseAbnormalClosure         = uint16(1006) // Must not be sent in a close frame
+	CloseInvalidFramePayloadData = uint16(1007)
+	ClosePolicyViolation         = uint16(1008)
+	CloseMessageTooBig           = uint16(1009)
+	CloseMandatoryExtension      = uint16(1010)
+	CloseInternalServerErr       = uint16(1011)
+	CloseServiceRestart          = uint16(1012)
+	CloseTryAgainLater           = uint16(1013)
+	CloseTLSHandshake            = uint16(1015) // Must not be sent in a close frame
+)
+
+// True if err ended a connection with a close code which is not one of
+// expected, such as
+//
+//	if websocket.IsUnexpectedCloseError(c.Err(), websocket.CloseGoingAway) {
+//		log.Println(c.Err())
+//	}
+//
+// A connection which the other end-point closed with a status other than
+// normal closure has a *CloseError with that status, and a lost one has
+// one with CloseAbnormalClosure.
+func IsUnexpectedCloseError(err error, expected ...uint16) bool {
+	code, ok := closeCode(err)
+	if !ok {
+		return false
+	}
+	for _, e := range expected {
+		if code == e {
+			return false
+		}
+	}
+	return true
+}
+
+// The close code of an error which ended a connection
+func closeCode(err error) (code uint16, ok bool) {
+	var ce *CloseError
+	var ec *errConnection
+	switch {
+	case errors.As(err, &ce):
+		return ce.Code, true
+	case errors.As(err, &ec):
+		return ec.code, true
+	}
+	return
+}
+
+// True if code may be sent in a close frame. Codes below 1000, 1004 to
+// 1006, 1015 and the rest of the range up to 2999 are reserved or must not
+// be sent, see RFC 6455 section 7.4.
+func sendableCloseCode(code uint16) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
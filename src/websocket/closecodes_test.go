@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsUnexpectedCloseError(t *testing.T) {
+	lost := &CloseError{Code: CloseAbnormalClosure, Text: "EOF"}
+	tests := []struct {
+		err      error
+		expected []uint16
+		result   bool
+	}{
+		{nil, nil, false},
+		{errors.New("Other error"), nil, false},
+		{lost, nil, true},
+		{lost, []uint16{CloseGoingAway, CloseAbnormalClosure}, false},
+		{fmt.Errorf("Wrapped: %w", lost), []uint16{CloseGoingAway}, true},
+		{newErrConnection(CloseMessageTooBig, "Message too big"), []uint16{CloseMessageTooBig}, false},
+		{newErrConnection(CloseProtocolError, ""), []uint16{CloseNormalClosure}, true},
+	}
+	for _, test := range tests {
+		if result := IsUnexpectedCloseError(test.err, test.expected...); result != test.result {
+			t.Errorf("Expected %v for %v expecting %v, got %v", test.result, test.err, test.expected, result)
+		}
+	}
+}
+
+func TestIsUnexpectedCloseErrorPeerStatus(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	client.Write([]byte{0x88, 0x86, 0x00, 0x00, 0x00, 0x00, 0x03, 0xf3, 'o', 'o', 'p', 's'})
+	for range c.In {
+	}
+	err := c.Err()
+	if !IsUnexpectedCloseError(err, CloseNormalClosure, CloseGoingAway) {
+		t.Errorf("Closing with %v not unexpected: %v", CloseInternalServerErr, err)
+	}
+	if IsUnexpectedCloseError(err, CloseInternalServerErr) {
+		t.Errorf("Closing with %v unexpected although expected", CloseInternalServerErr)
+	}
+	if ce, ok := err.(*CloseError); !ok || ce.Text != "oops" {
+		t.Errorf("Expected the reason oops, got %v", err)
+	}
+}
+
+func TestIsUnexpectedCloseErrorNormal(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	client.Write([]byte{0x88, 0x82, 0x00, 0x00, 0x00, 0x00, 0x03, 0xe8})
+	for range c.In {
+	}
+	if err := c.Err(); IsUnexpectedCloseError(err) {
+		t.Errorf("Normal closure unexpected: %v", err)
+	}
+}
+
+func TestInvalidIncomingCloseCode(t *testing.T) {
+	for _, code := range []uint16{999, 1004, CloseAbnormalClosure, 1016, 2999, 5000} {
+		c, client := newPipeConn()
+		go client.Write([]byte{0x88, 0x82, 0x00, 0x00, 0x00, 0x00, byte(code >> 8), byte(code)})
+		if payload := readShortFrame(t, client); !strings.HasPrefix(payload, "\x03\xea") {
+			t.Errorf("%v: expected a protocol error, got close frame %q", code, payload)
+		}
+		for range c.In {
+		}
+		if err := c.Err(); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%v: expected a protocol error, got %v", code, err)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,210 @@
+// Command wsbench opens concurrent connections to a websocket echo server,
+// sends messages on them at a given rate and size, and reports the
+// throughput, the round trip latencies, and the errors and close codes
+// seen. Without a URL it runs an echo server of its own on the loopback,
+// so that both the client and the server side of the package are measured.
+//
+//	wsbench -n 100 -rate 10 -size 256 -duration 10s ws://localhost:8080/echo
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"websocket"
+)
+
+var (
+	conns    = flag.Int("n", 10, "number of concurrent `connections`")
+	rate     = flag.Float64("rate", 10, "messages per second on each connection, 0 for as fast as possible")
+	size     = flag.Int("size", 64, "message size in `bytes`, at least 8")
+	duration = flag.Duration("duration", 10*time.Second, "how long to send messages")
+	drain    = flag.Duration("drain", 2*time.Second, "how long to wait for the last echoes before closing")
+)
+
+// What the connections have seen, guarded by mu
+type results struct {
+	mu        sync.Mutex
+	opened    int
+	sent      int
+	received  int
+	bytes     int64 // Received
+	latencies []time.Duration
+	errors    map[string]int
+	closes    map[uint16]int
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: wsbench [flags] [url]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || *conns < 1 || *size < 8 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	url := flag.Arg(0)
+	if url == "" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		s := &websocket.Server{Path: "/", Handle: echo}
+		go s.Serve(l)
+		defer s.Close()
+		url = "ws://" + l.Addr().String() + "/"
+	}
+	r := &results{errors: make(map[string]int), closes: make(map[uint16]int)}
+	start := time.Now()
+	deadline := start.Add(*duration)
+	var wg sync.WaitGroup
+	for i := 0; i < *conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.run(url, deadline)
+		}()
+	}
+	wg.Wait()
+	r.report(time.Since(start))
+}
+
+// Send every message back to its sender
+func echo(c *websocket.Conn) {
+	for m, err := range c.Messages() {
+		if err != nil {
+			return
+		}
+		payload, _ := ioutil.ReadAll(&m)
+		if m.Type == websocket.BinaryMessage {
+			c.SendBinary(payload)
+		} else {
+			c.SendText(string(payload))
+		}
+	}
+}
+
+// Drive one connection until the deadline, and wait for it to close
+func (r *results) run(url string, deadline time.Time) {
+	c, _, err := websocket.Dial(url, "")
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	r.mu.Lock()
+	r.opened++
+	r.mu.Unlock()
+	var pending int64 // Messages sent but not yet echoed
+	go func() {
+		r.send(c, deadline, &pending)
+		for end := time.Now().Add(*drain); atomic.LoadInt64(&pending) > 0 && time.Now().Before(end); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		c.Close()
+	}()
+	c.Handle(&websocket.Events{
+		OnMessage: func(c *websocket.Conn, msgType int, m io.Reader) {
+			payload, _ := ioutil.ReadAll(m)
+			atomic.AddInt64(&pending, -1)
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.received++
+			r.bytes += int64(len(payload))
+			if len(payload) >= 8 {
+				sent := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+				r.latencies = append(r.latencies, time.Since(sent))
+			}
+		},
+		OnError: func(c *websocket.Conn, err error) {
+			r.fail(err)
+		},
+		OnClose: func(c *websocket.Conn, code uint16, reason string) {
+			r.mu.Lock()
+			r.closes[code]++
+			r.mu.Unlock()
+		},
+	})
+}
+
+// Send messages stamped with their send time until the deadline
+func (r *results) send(c *websocket.Conn, deadline time.Time, pending *int64) {
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for time.Now().Before(deadline) {
+		if tick != nil {
+			<-tick
+		}
+		payload := make([]byte, *size)
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+		atomic.AddInt64(pending, 1)
+		if err := c.SendBinary(payload); err != nil {
+			r.fail(err)
+			return
+		}
+		r.mu.Lock()
+		r.sent++
+		r.mu.Unlock()
+	}
+}
+
+func (r *results) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[err.Error()]++
+}
+
+func (r *results) report(elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seconds := elapsed.Seconds()
+	fmt.Printf("Connections: %v opened of %v\n", r.opened, *conns)
+	fmt.Printf("Messages:    %v sent, %v received in %v\n", r.sent, r.received, elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %.1f messages/s, %.1f KiB/s\n", float64(r.received)/seconds, float64(r.bytes)/1024/seconds)
+	if len(r.latencies) > 0 {
+		sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+		fmt.Printf("Latency:     min %v, p50 %v, p90 %v, p99 %v, max %v\n",
+			r.latencies[0], percentile(r.latencies, 50), percentile(r.latencies, 90),
+			percentile(r.latencies, 99), r.latencies[len(r.latencies)-1])
+	}
+	if len(r.errors) > 0 {
+		fmt.Println("Errors:")
+		errors := make([]string, 0, len(r.errors))
+		for err := range r.errors {
+			errors = append(errors, err)
+		}
+		sort.Strings(errors)
+		for _, err := range errors {
+			fmt.Printf("  %6v  %v\n", r.errors[err], err)
+		}
+	}
+	if len(r.closes) > 0 {
+		fmt.Println("Close codes:")
+		codes := make([]int, 0, len(r.closes))
+		for code := range r.closes {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Printf("  %6v  %v\n", r.closes[uint16(code)], code)
+		}
+	}
+}
+
+// The p-th percentile of sorted, which must not be empty
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)*p+99)/100-1]
+}
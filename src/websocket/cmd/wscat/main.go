@@ -0,0 +1,121 @@
+// Command wscat connects to a websocket server, sends every line read from
+// stdin as a message and prints the messages received, until either side
+// closes the connection.
+//
+//	wscat [flags] ws://localhost:8080/myconn
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"websocket"
+)
+
+// Repeatable -H flags
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("Malformed header %q, expected \"Name: value\"", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	return nil
+}
+
+var (
+	origin       = flag.String("origin", "", "`origin` sent in the Origin header")
+	subprotocols = flag.String("subprotocols", "", "comma separated `subprotocols` to offer")
+	binary       = flag.Bool("binary", false, "send lines as binary messages")
+	insecure     = flag.Bool("insecure", false, "don't verify the certificate of a wss server")
+	wait         = flag.Duration("wait", time.Second, "how long to wait for messages once stdin ends, before closing")
+	header       = headerFlags{}
+)
+
+func main() {
+	flag.Var(header, "H", "`header` sent with the handshake, such as \"Authorization: Bearer x\", repeatable")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: wscat [flags] url")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	d := &websocket.Dialer{Header: http.Header(header)}
+	if *subprotocols != "" {
+		d.Subprotocols = strings.Split(*subprotocols, ",")
+	}
+	if *insecure {
+		d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	c, resp, err := d.Dial(flag.Arg(0), *origin)
+	if err != nil {
+		if resp != nil {
+			fmt.Fprintln(os.Stderr, resp.Status)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if p := c.Subprotocol(); p != "" {
+		fmt.Fprintf(os.Stderr, "Connected, subprotocol %v\n", p)
+	} else {
+		fmt.Fprintln(os.Stderr, "Connected")
+	}
+	go send(c)
+	status := 0
+	c.Handle(&websocket.Events{
+		OnMessage: func(c *websocket.Conn, msgType int, r io.Reader) {
+			if msgType == websocket.BinaryMessage {
+				dumper := hex.Dumper(os.Stdout)
+				io.Copy(dumper, r)
+				dumper.Close()
+				return
+			}
+			io.Copy(os.Stdout, r)
+			fmt.Println()
+		},
+		OnError: func(c *websocket.Conn, err error) {
+			fmt.Fprintln(os.Stderr, err)
+		},
+		OnClose: func(c *websocket.Conn, code uint16, reason string) {
+			fmt.Fprintf(os.Stderr, "Closed (%v) %v\n", code, reason)
+			if code != 1000 && code != 1005 {
+				status = 1
+			}
+		},
+	})
+	os.Exit(status)
+}
+
+// Send the lines of stdin, and close the connection after waiting at the
+// end of it
+func send(c *websocket.Conn) {
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		var err error
+		if *binary {
+			err = c.SendBinary(append([]byte(nil), lines.Bytes()...))
+		} else {
+			err = c.SendText(lines.Text())
+		}
+		if err != nil {
+			return
+		}
+	}
+	time.Sleep(*wait)
+	c.Close()
+}
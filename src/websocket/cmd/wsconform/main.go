@@ -0,0 +1,281 @@
+// Command wsconform runs a battery of protocol edge cases against a
+// websocket server, such as unmasked frames, reserved bits, fragmented
+// control frames, invalid UTF-8 and oversized frames, and prints a pass or
+// fail report. Every case runs on a connection of its own. Without a URL
+// it tests an echo server of the package, on the loopback.
+//
+//	wsconform ws://localhost:8080/myconn
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"websocket"
+	"websocket/wsframe"
+)
+
+var (
+	origin   = flag.String("origin", "", "`origin` sent in the Origin header")
+	timeout  = flag.Duration("timeout", 2*time.Second, "how long to wait for the server to react to each case")
+	insecure = flag.Bool("insecure", false, "don't verify the certificate of a wss server")
+)
+
+// How the server reacted to the frames of a case
+type outcome struct {
+	pongs [][]byte
+	code  uint16 // Of the close frame received, 1006 if dropped without one
+	open  bool   // Still open at the timeout
+}
+
+func (o *outcome) String() string {
+	switch {
+	case o.open:
+		return "still open"
+	case o.code == 1006:
+		return "dropped without a close frame"
+	}
+	return fmt.Sprintf("closed with %v", o.code)
+}
+
+// A protocol edge case, the frames sent and a check of the outcome
+type testCase struct {
+	name   string
+	frames [][]byte
+	check  func(o *outcome) bool
+}
+
+// The server must fail the connection, with one of the codes if it sends a
+// close frame
+func fails(codes ...uint16) func(o *outcome) bool {
+	return func(o *outcome) bool {
+		if o.open {
+			return false
+		}
+		for _, code := range append(codes, 1006) {
+			if o.code == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var cases = []testCase{
+	{"ping is answered with a pong", [][]byte{frame(0x89, []byte("hello")), closeFrame(1000, "")},
+		func(o *outcome) bool {
+			return len(o.pongs) == 1 && string(o.pongs[0]) == "hello" && o.code == 1000
+		}},
+	{"close is answered with a close", [][]byte{closeFrame(1000, "bye")},
+		func(o *outcome) bool { return o.code == 1000 }},
+	{"unmasked frame", [][]byte{unmasked(0x81, []byte("hi"))}, fails(1002)},
+	{"reserved bit without extension", [][]byte{frame(0xc1, []byte("hi"))}, fails(1002)},
+	{"reserved data opcode", [][]byte{frame(0x83, nil)}, fails(1002)},
+	{"reserved control opcode", [][]byte{frame(0x8b, nil)}, fails(1002)},
+	{"fragmented control frame", [][]byte{frame(0x09, nil), frame(0x80, nil)}, fails(1002)},
+	{"control frame over 125 bytes", [][]byte{frame(0x89, make([]byte, 126))}, fails(1002)},
+	{"continuation without a message", [][]byte{frame(0x80, []byte("x"))}, fails(1002)},
+	{"new message inside a fragmented one", [][]byte{frame(0x01, []byte("a")), frame(0x81, []byte("b"))}, fails(1002)},
+	{"invalid UTF-8 in a text message", [][]byte{frame(0x81, []byte{0xce, 0xba, 0xff, 0xfe})}, fails(1007)},
+	{"invalid UTF-8 split over fragments", [][]byte{frame(0x01, []byte{0xce}), frame(0x80, []byte{0x41})}, fails(1007)},
+	{"invalid UTF-8 in a close reason", [][]byte{closeFrame(1000, "\xff")}, fails(1002, 1007)},
+	{"close frame with a 1 byte payload", [][]byte{frame(0x88, []byte{0x03})}, fails(1002)},
+	{"close code out of range", [][]byte{closeFrame(999, "")}, fails(1002)},
+	{"close code which must not be sent", [][]byte{closeFrame(1005, "")}, fails(1002)},
+	{"payload length with the most significant bit set", [][]byte{lengthOnly(0x82, 1<<63)}, fails(1002)},
+	{"oversized frame", [][]byte{lengthOnly(0x82, 1<<62)}, fails(1002, 1009)},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: wsconform [flags] [url]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	target := flag.Arg(0)
+	if target == "" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		s := &websocket.Server{Path: "/", Handle: echo, Handler: &websocket.Handler{ReadLimit: 1 << 20}}
+		go s.Serve(l)
+		target = "ws://" + l.Addr().String() + "/"
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	passed := 0
+	for _, tc := range cases {
+		o, err := run(u, tc.frames)
+		switch {
+		case err != nil:
+			fmt.Printf("ERROR  %v: %v\n", tc.name, err)
+		case tc.check(o):
+			fmt.Printf("PASS   %v: %v\n", tc.name, o)
+			passed++
+		default:
+			fmt.Printf("FAIL   %v: %v\n", tc.name, o)
+		}
+	}
+	fmt.Printf("%v of %v cases passed\n", passed, len(cases))
+	if passed < len(cases) {
+		os.Exit(1)
+	}
+}
+
+// Send every message back to its sender
+func echo(c *websocket.Conn) {
+	for m, err := range c.Messages() {
+		if err != nil {
+			return
+		}
+		payload, _ := ioutil.ReadAll(&m)
+		if m.Type == websocket.BinaryMessage {
+			c.SendBinary(payload)
+		} else {
+			c.SendText(string(payload))
+		}
+	}
+}
+
+// Connect to u, send the frames and watch the reaction of the server
+func run(u *url.URL, frames [][]byte) (o *outcome, err error) {
+	conn, r, err := connect(u)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(*timeout))
+	for _, f := range frames {
+		if _, err = conn.Write(f); err != nil {
+			break // The server may have failed the connection already
+		}
+	}
+	o = &outcome{}
+	for {
+		var h *wsframe.Header
+		if h, err = wsframe.ReadHeader(r); err != nil {
+			break
+		}
+		var payload []byte
+		if payload, err = ioutil.ReadAll(wsframe.PayloadReader(h, r)); err != nil {
+			break
+		}
+		switch h.OpCode {
+		case wsframe.OpPong:
+			o.pongs = append(o.pongs, payload)
+		case wsframe.OpClose:
+			o.code = 1005
+			if len(payload) >= 2 {
+				o.code = binary.BigEndian.Uint16(payload)
+			}
+			return o, nil
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		o.open = true
+	} else {
+		o.code = 1006
+	}
+	return o, nil
+}
+
+// Open a connection to u and complete the opening handshake
+func connect(u *url.URL) (conn net.Conn, r *bufio.Reader, err error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.DialTimeout("tcp", host, *timeout)
+	case "wss":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: *timeout}, "tcp", host,
+			&tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: *insecure})
+	default:
+		err = fmt.Errorf("Unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "GET %v HTTP/1.1\r\nHost: %v\r\n", u.RequestURI(), u.Host)
+	req.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	fmt.Fprintf(&req, "Sec-WebSocket-Key: %v\r\n", base64.StdEncoding.EncodeToString(key))
+	if *origin != "" {
+		fmt.Fprintf(&req, "Origin: %v\r\n", *origin)
+	}
+	req.WriteString("\r\n")
+	conn.SetDeadline(time.Now().Add(*timeout))
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		conn.Close()
+		return
+	}
+	r = bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err == nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		err = fmt.Errorf("Handshake refused: %v", resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return
+}
+
+// A masked client frame with the first byte b0, holding FIN, RSV and opcode
+func frame(b0 byte, payload []byte) []byte {
+	f := header(b0, 0x80, uint64(len(payload)))
+	key := []byte{0x37, 0xfa, 0x21, 0x3d}
+	masked := append([]byte(nil), payload...)
+	wsframe.Mask(key, 0, masked)
+	f = append(f, key...)
+	return append(f, masked...)
+}
+
+// A frame without a masking key, which clients must not send
+func unmasked(b0 byte, payload []byte) []byte {
+	return append(header(b0, 0, uint64(len(payload))), payload...)
+}
+
+func closeFrame(code uint16, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return frame(0x88, append(payload, reason...))
+}
+
+// The header of a masked frame announcing length, without the payload
+func lengthOnly(b0 byte, length uint64) []byte {
+	return append(header(b0, 0x80, length), 0x37, 0xfa, 0x21, 0x3d)
+}
+
+func header(b0, mask byte, length uint64) []byte {
+	switch {
+	case length < 126:
+		return []byte{b0, mask | byte(length)}
+	case length <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{b0, mask | 126}, uint16(length))
+	}
+	return binary.BigEndian.AppendUint64([]byte{b0, mask | 127}, length)
+}
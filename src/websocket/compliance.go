@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"net/http"
+)
+
+// How strictly the other end-point is held to RFC 6455
+type Compliance int
+
+const (
+	// Every MUST of RFC 6455 is enforced, a violation fails the handshake
+	// or the connection right away. The default.
+	Strict Compliance = iota
+
+	// Common deviations of real-world peers, such as buggy embedded
+	// clients, are tolerated and logged: frames not masked by a client or
+	// masked by a server, and handshakes whose Connection header lacks the
+	// Upgrade token.
+	Lenient
+)
+
+// True if the Connection header of a handshake has the Upgrade token, or
+// if its absence is tolerated
+func connectionUpgrade(header http.Header, compliance Compliance) bool {
+	if headerContainsToken(header, "Connection", "Upgrade") {
+		return true
+	}
+	if compliance == Lenient {
+		Log.Printf("Tolerated handshake with Connection header %q", header.Get("Connection"))
+		return true
+	}
+	return false
+}
+
+// Check that a frame is masked if from a client, and not if from a server.
+// If lenient, the first wrongly masked frame is logged instead.
+func (c *Conn) checkMasking(f *frame) (err error) {
+	if f.header.Masked == c.server || (c.server && c.unmaskedAllowed) {
+		return
+	}
+	if c.compliance == Lenient {
+		if !c.maskTolerated {
+			Log.Println("Tolerated wrongly masked frames, masked:", f.header.Masked)
+			c.maskTolerated = true
+		}
+		return
+	}
+	// Clients must mask their frames, servers must not
+	if c.server {
+		return errClientUnmasked
+	}
+	return errServerMasked
+}
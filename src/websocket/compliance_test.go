@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestLenientUnmaskedFrames(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.compliance = Lenient
+	go client.Write([]byte{0x81, 0x02, 'h', 'i', 0x81, 0x82, 0, 0, 0, 0, 'h', 'o'})
+	for _, expected := range []string{"hi", "ho"} {
+		if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != expected {
+			t.Errorf("Expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+func TestLenientConnectionHeader(t *testing.T) {
+	h := NewHandler()
+	req := newHandshakeRequest()
+	req.Header.Set("Connection", "keep-alive")
+	if _, resp := handshake(t, h, req); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 when strict, got %v", resp.Status)
+	}
+	h.Compliance = Lenient
+	client, resp := handshake(t, h, req)
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected 101 when lenient, got %v", resp.Status)
+	}
+	if c := <-h.Conns; c.compliance != Lenient {
+		t.Error("Connection not lenient")
+	}
+}
+
+func TestAllowUnmaskedFrames(t *testing.T) {
+	h := NewHandler()
+	h.AllowUnmaskedFrames = true
+	req := newHandshakeRequest()
+	req.Header.Del("Origin")
+	client, _ := handshake(t, h, req)
+	defer client.Close()
+	c := <-h.Conns
+	client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "hi" {
+		t.Errorf("Expected %q, got %q", "hi", msg)
+	}
+
+	// Browsers must still mask
+	client, _ = handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	c = <-h.Conns
+	client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	for range c.In {
+	}
+	if !errors.Is(c.Err(), errClientUnmasked) {
+		t.Errorf("Expected %v, got %v", errClientUnmasked, c.Err())
+	}
+}
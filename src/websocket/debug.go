@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// A connection as listed by DebugHandler
+type DebugConn struct {
+	ID             int64     `json:"id"` // Order of the upgrade by the handler
+	RemoteAddr     string    `json:"remote_addr"`
+	ClientIP       string    `json:"client_ip,omitempty"`
+	Subprotocol    string    `json:"subprotocol,omitempty"`
+	Uptime         float64   `json:"uptime_seconds"`
+	QueuedMessages int       `json:"queued_messages"` // See Conn.Buffered
+	QueuedBytes    int64     `json:"queued_bytes"`
+	LastActivity   time.Time `json:"last_activity"` // Last frame read or written
+}
+
+// An HTTP handler rendering the open connections of h as a JSON array,
+// ordered by ID, for operational inspection. Every request passes through
+// auth first, which should refuse whoever isn't allowed to see them, such
+// as by checking a token. If auth is nil, every request is refused with
+// 403.
+func (h *Handler) DebugHandler(auth func(http.Handler) http.Handler) http.Handler {
+	if auth == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+	return auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.debugConns())
+	}))
+}
+
+// The open connections of h, ordered by ID
+func (h *Handler) debugConns() (conns []DebugConn) {
+	s := &h.stats
+	s.mu.Lock()
+	open := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		open = append(open, c)
+	}
+	s.mu.Unlock()
+	conns = make([]DebugConn, 0, len(open))
+	now := time.Now()
+	for _, c := range open {
+		dc := DebugConn{
+			ID:           c.id,
+			RemoteAddr:   c.conn.RemoteAddr().String(),
+			Subprotocol:  c.subprotocol,
+			Uptime:       now.Sub(c.started).Seconds(),
+			LastActivity: time.Unix(0, atomic.LoadInt64(&c.lastActive)),
+		}
+		if c.clientIP.IsValid() {
+			dc.ClientIP = c.clientIP.String()
+		}
+		dc.QueuedMessages, dc.QueuedBytes = c.Buffered()
+		conns = append(conns, dc)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return
+}
+
+// Record activity on a connection of a handler
+func (c *Conn) active() {
+	if c.stats != nil {
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	}
+}
@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	h := NewHandler()
+	client, _ := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	<-h.Conns
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	debug := h.DebugHandler(auth)
+	w := httptest.NewRecorder()
+	debug.ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/debug", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	debug.ServeHTTP(w, req)
+	var conns []DebugConn
+	if err := json.Unmarshal(w.Body.Bytes(), &conns); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("Expected one connection, got %v", conns)
+	}
+	if dc := conns[0]; dc.ID != 1 || dc.RemoteAddr == "" || dc.LastActivity.IsZero() || dc.QueuedMessages != 0 {
+		t.Errorf("Unexpected connection %+v", dc)
+	}
+}
+
+func TestDebugHandlerWithoutAuth(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewHandler().DebugHandler(nil).ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without an auth wrapper, got %v", w.Code)
+	}
+}
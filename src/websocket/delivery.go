@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"bytes"
+)
+
+// How incoming messages are delivered on In
+const (
+	// Streamed as their frames arrive, through a buffer. The default.
+	DeliverStreamed = iota
+
+	// Reassembled in memory, and delivered once complete with their payload
+	// available from Message.Bytes. Unless a read limit is set, messages
+	// over 1 MiB close the connection with 1009 (message too big).
+	DeliverBuffered
+
+	// Handed off to the application as they arrive if of a single frame,
+	// reading the payload straight from the connection, which saves a
+	// copy. Nothing else is read from the connection until the message
+	// has been read to the end or released with Message.Release, or the
+	// abandon timeout has passed. Other messages are streamed.
+	DeliverHandoff
+)
+
+// Set how incoming messages are delivered, DeliverStreamed by default
+func (c *Conn) SetDelivery(mode int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivery = mode
+}
+
+// The mode set with SetDelivery
+func (c *Conn) Delivery() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delivery
+}
+
+// The size limit of incoming messages: the read limit, or the default
+// maximum if buffered without one
+func (c *Conn) messageLimit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readLimit == 0 && c.delivery == DeliverBuffered {
+		return defaultMaxMessageSize
+	}
+	return c.readLimit
+}
+
+// The unread payload of an incoming message delivered buffered, see
+// DeliverBuffered, or nil if it is streamed
+func (m *Message) Bytes() []byte {
+	f, inFlight := m.Reader.(*inFlightReader)
+	r := m.Reader
+	if inFlight {
+		r = f.Reader
+	}
+	b, ok := r.(*bytes.Buffer)
+	if !ok {
+		return nil
+	}
+	if inFlight {
+		f.done()
+	}
+	return b.Bytes()
+}
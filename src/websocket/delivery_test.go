@@ -0,0 +1,30 @@
+package websocket
+
+import (
+	"testing"
+)
+
+func TestDeliverBuffered(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetDelivery(DeliverBuffered)
+	go client.Write([]byte{0x01, 0x81, 0, 0, 0, 0, 'a', 0x80, 0x81, 0, 0, 0, 0, 'b'})
+	if m := (<-c.In).(*Message); string(m.Bytes()) != "ab" || m.Type != TextMessage {
+		t.Errorf("Expected text message %q, got %v %q", "ab", m.Type, m.Bytes())
+	}
+	// Over the default maximum of 1 MiB, only the header is sent
+	go client.Write([]byte{0x82, 0xff, 0, 0, 0, 0, 0, 0x10, 0, 0x01, 0, 0, 0, 0})
+	if payload := readShortFrame(t, client); payload != "\x03\xf1Message too big" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+}
+
+func TestDeliverStreamedBytes(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, 'a'})
+	m := (<-c.In).(*Message)
+	if m.Bytes() != nil {
+		t.Errorf("Expected no bytes of a streamed message, got %q", m.Bytes())
+	}
+}
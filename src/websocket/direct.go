@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"io"
+	"sync"
+)
+
+// Buffers of messages delivered directly, reused once read to the end
+var directBuffers sync.Pool // Of *[]byte
+
+// Deliver messages of a single frame with a payload of at most n bytes
+// directly, zero to stream every message through a buffer, the default. Such
+// a message is read whole into a pooled buffer before it is sent on In,
+// which saves the goroutine handoffs of streaming. The buffer is reused
+// once the message has been read to the end.
+func (c *Conn) SetDirectDelivery(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.directDelivery = n
+}
+
+// The size set with SetDirectDelivery
+func (c *Conn) DirectDelivery() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.directDelivery
+}
+
+// True if the message starting with data frame f is delivered directly
+func (c *Conn) deliversDirectly(f *frame) bool {
+	return f.header.Fin && f.Len() <= c.DirectDelivery()
+}
+
+// Read the payload of f into a pooled buffer, and deliver it on In
+func (c *Conn) deliverDirectly(f *frame) (err error) {
+	buf, _ := directBuffers.Get().(*[]byte)
+	if buf == nil || int64(cap(*buf)) < f.Len() {
+		size := c.DirectDelivery() // Fits any message delivered directly
+		if size < f.Len() {
+			size = f.Len()
+		}
+		b := make([]byte, size)
+		buf = &b
+	}
+	*buf = (*buf)[:f.Len()]
+	f.reader.Reset(f.header, f.payload)
+	if _, err = io.ReadFull(&f.reader, *buf); err != nil {
+		directBuffers.Put(buf)
+		return
+	}
+	select {
+	case c.in <- c.inFlightMessage(&Message{Type: int(f.Op()), Reader: &directMessage{buf: buf}}):
+	case <-c.inDone:
+		directBuffers.Put(buf) // Closing, nobody reads the message
+	}
+	return
+}
+
+// The payload of a message delivered directly
+type directMessage struct {
+	buf *[]byte // Nil once read to the end and put back in the pool
+	off int
+}
+
+func (m *directMessage) Read(p []byte) (n int, err error) {
+	if m.buf == nil {
+		return 0, io.EOF
+	}
+	n = copy(p, (*m.buf)[m.off:])
+	m.off += n
+	if m.off == len(*m.buf) {
+		directBuffers.Put(m.buf)
+		m.buf = nil
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+	return
+}
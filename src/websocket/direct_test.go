@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDirectDelivery(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetDirectDelivery(4)
+	go client.Write([]byte{
+		0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2, // Direct, masked
+		0x82, 0x80, 0, 0, 0, 0, // Direct, empty
+		0x81, 0x85, 0, 0, 0, 0, 'h', 'e', 'l', 'l', 'o', // Too big
+		0x01, 0x81, 0, 0, 0, 0, 'a', 0x80, 0x81, 0, 0, 0, 0, 'b', // Fragmented
+	})
+	tests := []struct {
+		payload string
+		direct  bool
+	}{
+		{"hi", true},
+		{"", true},
+		{"hello", false},
+		{"ab", false},
+	}
+	for _, test := range tests {
+		m := (<-c.In).(*Message)
+		if _, direct := m.Reader.(*directMessage); direct != test.direct {
+			t.Errorf("Expected %q to be delivered directly: %v", test.payload, test.direct)
+		}
+		if payload, _ := ioutil.ReadAll(m); string(payload) != test.payload {
+			t.Errorf("Expected %q, got %q", test.payload, payload)
+		}
+		if n, err := m.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Expected EOF after the end, got %v, %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package websocket
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+var errDraining = classify(ErrHandshake, "Handler is draining")
+
+// Stop accepting new connections, while the existing connections are left
+// untouched. New upgrades are refused with 503, or redirected to
+// DrainLocation if set, so that traffic can be moved off the handler before
+// its connections are closed.
+func (h *Handler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// True once Drain has been called
+func (h *Handler) Draining() bool {
+	return atomic.LoadInt32(&h.draining) != 0
+}
+
+// Respond to an upgrade refused because the handler is draining
+func (h *Handler) drained(w http.ResponseWriter, r *http.Request) {
+	if h.DrainLocation != "" {
+		h.handshakeError(r, errDraining, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, h.DrainLocation, http.StatusTemporaryRedirect)
+		return
+	}
+	h.refuse(w, r, errDraining, http.StatusServiceUnavailable)
+}
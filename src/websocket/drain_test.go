@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	h := NewHandler()
+	client, _ := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	c := <-h.Conns
+	h.Drain()
+	if !h.Draining() {
+		t.Error("Not draining after Drain")
+	}
+	_, resp := handshake(t, h, newHandshakeRequest())
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %v", resp.Status)
+	}
+	if c.State() != OPEN {
+		t.Error("Existing connection closed by Drain")
+	}
+	client.Write([]byte{0x81, 0x80, 0x00, 0x00, 0x00, 0x00})
+	if _, ok := <-c.In; !ok {
+		t.Error("Existing connection stopped receiving")
+	}
+}
+
+func TestDrainRedirect(t *testing.T) {
+	h := NewHandler()
+	h.DrainLocation = "ws://other.example/myconn"
+	h.Drain()
+	_, resp := handshake(t, h, newHandshakeRequest())
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("Expected 307 while draining, got %v", resp.Status)
+	}
+	if loc := resp.Header.Get("Location"); loc != h.DrainLocation {
+		t.Errorf("Expected redirect to %v, got %v", h.DrainLocation, loc)
+	}
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"errors"
+	"os"
+)
+
+// Classes of the errors returned by the package, to be tested for with
+// errors.Is rather than by comparing messages, such as
+//
+//	if errors.Is(err, websocket.ErrClosed) {
+//		...
+//	}
+var (
+	// The opening handshake failed or was refused, on either side
+	ErrHandshake = errors.New("Websocket handshake failed")
+
+	// A frame or message broke the protocol, see CloseError and the close
+	// codes 1002, 1007 and 1009
+	ErrProtocol = errors.New("Websocket protocol violation")
+
+	// A deadline or timeout passed. It is os.ErrDeadlineExceeded, which
+	// the net.Conn of AsNetConn returns as is.
+	ErrTimeout = os.ErrDeadlineExceeded
+
+	// The connection, or the stream on it, is closed
+	ErrClosed = errors.New("Websocket connection is closed")
+
+	// A send queue or buffer had no room
+	ErrQueueFull = errors.New("Queue is full")
+)
+
+// An error of one of the classes
+type classError struct {
+	msg   string
+	class error
+	err   error // The cause, if any
+}
+
+func classify(class error, msg string) error {
+	return &classError{msg: msg, class: class}
+}
+
+// Put err in class, unless it already is
+func wrapError(class, err error) error {
+	if err == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classError{err.Error(), class, err}
+}
+
+func (e *classError) Error() string {
+	return e.msg
+}
+
+func (e *classError) Is(target error) bool {
+	return target == e.class
+}
+
+func (e *classError) Unwrap() error {
+	return e.err
+}
+
+// The class of an error closing the connection with code, if any
+func closeClass(code uint16) error {
+	switch code {
+	case statusProtocolError, statusInvalidPayload, statusMessageTooBig:
+		return ErrProtocol
+	case statusAbnormalClosure:
+		return ErrClosed
+	}
+	return nil
+}
+
+func (e *errConnection) Is(target error) bool {
+	class := closeClass(e.code)
+	return class != nil && target == class
+}
+
+func (e *CloseError) Is(target error) bool {
+	class := closeClass(e.Code)
+	return class != nil && target == class
+}
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestErrorClasses(t *testing.T) {
+	tests := []struct {
+		err   error
+		class error
+	}{
+		{errHandshakeRefused, ErrHandshake},
+		{errOriginNotAllowed, ErrHandshake},
+		{ErrNotHijackable, ErrHandshake},
+		{newErrConnection(statusProtocolError, ""), ErrProtocol},
+		{newErrConnection(statusMessageTooBig, ""), ErrProtocol},
+		{&CloseError{Code: statusAbnormalClosure}, ErrClosed},
+		{errMessageExpired, ErrTimeout},
+		{errMessageExpired, os.ErrDeadlineExceeded},
+		{errConnClosed, ErrClosed},
+		{errSlowClient, ErrClosed},
+		{errMessageDropped, ErrQueueFull},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.class) {
+			t.Errorf("%q isn't %q", test.err, test.class)
+		}
+	}
+	if errors.Is(newErrConnection(statusNormalClosure, ""), ErrProtocol) {
+		t.Error("A normal closure isn't a protocol violation")
+	}
+}
+
+func TestDialErrorClass(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, _, err := Dial(wsURL(server), "")
+	if !errors.Is(err, ErrHandshake) || err != errHandshakeRefused {
+		t.Errorf("Expected the refused handshake, got %v", err)
+	}
+	// Not a websocket server at all
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Write([]byte("Hello\r\n\r\n"))
+		conn.Close()
+	}))
+	defer server.Close()
+	if _, _, err = Dial(wsURL(server), ""); !errors.Is(err, ErrHandshake) {
+		t.Errorf("Expected a handshake error, got %v", err)
+	}
+}
+
+func TestClosedErrorClass(t *testing.T) {
+	c, client := newPipeConn()
+	client.Close()
+	for range c.In {
+	}
+	if err := c.SendText("late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected a closed error, got %v", err)
+	}
+	if !errors.Is(c.Err(), ErrClosed) {
+		t.Errorf("Expected the lost connection to be closed, got %v", c.Err())
+	}
+}
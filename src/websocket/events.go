@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Callbacks for the events of a connection, an alternative to consuming the
+// channels of a Conn. Callbacks which are nil are skipped.
+type Events struct {
+	// Called once before any other callback
+	OnOpen func(c *Conn)
+
+	// Called for every incoming message, one at a time. Whatever is left
+	// unread of r when OnMessage returns is discarded.
+	OnMessage func(c *Conn, msgType int, r io.Reader)
+
+	// Called if the connection failed, before OnClose
+	OnError func(c *Conn, err error)
+
+	// Called last, with the status from the close frame of the other
+	// end-point, or CloseAbnormalClosure (1006) if there was none.
+	OnClose func(c *Conn, code uint16, reason string)
+}
+
+// Handle the connection by calling the callbacks in e, blocking until the
+// connection is closed. Takes over all messages on the connection.
+func (c *Conn) Handle(e *Events) {
+	if e.OnOpen != nil {
+		e.OnOpen(c)
+	}
+	for r := range c.In {
+		m := r.(*Message)
+		if e.OnMessage != nil {
+			e.OnMessage(c, m.Type, m)
+		}
+		io.Copy(ioutil.Discard, m)
+	}
+	if c.Err() != nil && e.OnError != nil {
+		e.OnError(c, c.Err())
+	}
+	if e.OnClose != nil {
+		status := c.closeStatus()
+		e.OnClose(c, status.code, status.reason)
+	}
+}
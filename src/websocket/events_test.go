@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerEvents(t *testing.T) {
+	var opened bool
+	closed := make(chan uint16, 1)
+	h := NewHandler()
+	h.Events = &Events{
+		OnOpen: func(c *Conn) {
+			opened = true
+		},
+		OnMessage: func(c *Conn, msgType int, r io.Reader) {
+			buf, _ := ioutil.ReadAll(r)
+			c.Out <- &Message{Type: msgType, Reader: bytes.NewBuffer(buf)}
+		},
+		OnClose: func(c *Conn, code uint16, reason string) {
+			closed <- code
+		},
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Out <- &Message{Type: BinaryMessage, Reader: bytes.NewBufferString("echo")}
+	m := (<-c.In).(*Message)
+	if msg, _ := ioutil.ReadAll(m); m.Type != BinaryMessage || string(msg) != "echo" {
+		t.Errorf("Unexpected echo: type %v, %q", m.Type, msg)
+	}
+	c.sendClose(newErrConnection(4001, "done"))
+	if code := <-closed; code != 4001 {
+		t.Errorf("Expected close code 4001, got %v", code)
+	}
+	if !opened {
+		t.Error("OnOpen not called")
+	}
+}
+
+func TestEventsError(t *testing.T) {
+	h := NewHandler()
+	errs := make(chan error, 1)
+	h.Events = &Events{
+		OnError: func(c *Conn, err error) {
+			errs <- err
+		},
+		OnClose: func(c *Conn, code uint16, reason string) {
+			if code != statusAbnormalClosure {
+				t.Errorf("Expected abnormal closure, got %v", code)
+			}
+			close(errs)
+		},
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.conn.Close()
+	if err = <-errs; err == nil {
+		t.Error("OnError not called")
+	}
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"time"
+)
+
+// When frames written to the connection are flushed to the network. The
+// zero value flushes every frame right away, for the lowest latency.
+// Otherwise frames are coalesced into fewer, larger writes, for higher
+// throughput: they are flushed once MaxBytes are buffered, or MaxDelay
+// after the first frame which wasn't flushed. Without MaxDelay, frames are
+// flushed as soon as no more frames are waiting to be written. Close frames
+// are always flushed right away.
+type FlushPolicy struct {
+	MaxBytes int
+	MaxDelay time.Duration
+}
+
+// Bytes of small messages coalesced into one write, to fit in a single
+// TCP segment on common networks
+const coalesceMaxBytes = 1400
+
+// A policy for streams of tiny messages, such as ticks or telemetry. The
+// messages queued within window are packed into a single write, still as
+// separate frames, saving syscalls and packets at the cost of up to window
+// latency. Larger messages are flushed right away.
+func CoalescingPolicy(window time.Duration) FlushPolicy {
+	return FlushPolicy{MaxBytes: coalesceMaxBytes, MaxDelay: window}
+}
+
+// True if the buffered bytes must be flushed, with queued frames waiting
+// to be written
+func (p FlushPolicy) due(buffered, queued int) bool {
+	switch {
+	case p.MaxBytes <= 0 && p.MaxDelay <= 0:
+		return true
+	case p.MaxBytes > 0 && buffered >= p.MaxBytes:
+		return true
+	case p.MaxDelay <= 0:
+		return queued == 0
+	}
+	return false
+}
+
+// The flush policy of the connection
+func (c *Conn) FlushPolicy() FlushPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushPolicy
+}
+
+// Change the flush policy, from the next frame written
+func (c *Conn) SetFlushPolicy(p FlushPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushPolicy = p
+}
+
+// Flush all frames queued so far to the network, whatever the flush policy.
+// This includes messages sent with SendText, SendBinary and WriteFrom, but
+// messages sent on Out may not have been split into frames yet.
+func (c *Conn) Flush() (err error) {
+	flushed := make(chan error, 1)
+	if err = c.queue(&frame{flushed: flushed}); err != nil {
+		return
+	}
+	select {
+	case err = <-flushed:
+	case <-c.done:
+		err = errConnClosed
+	}
+	return
+}
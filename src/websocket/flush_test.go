@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Check that the client receives exactly expected, and then nothing more
+// for a while.
+func expectFrames(t *testing.T, client net.Conn, expected []byte) {
+	buf := make([]byte, len(expected))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("Short read: %v", err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("Frames mismatch: %X", buf)
+	}
+	client.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, _ := client.Read(make([]byte, 1)); n != 0 {
+		t.Error("Recieved unexpected bytes")
+	}
+}
+
+func TestFlushDelayed(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetFlushPolicy(FlushPolicy{MaxDelay: time.Hour})
+	c.SendText("Hello")
+	expectFrames(t, client, nil)
+	flushed := make(chan error)
+	go func() {
+		flushed <- c.Flush()
+	}()
+	expectFrames(t, client, []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'})
+	if err := <-flushed; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlushMaxBytes(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetFlushPolicy(FlushPolicy{MaxBytes: 10, MaxDelay: time.Hour})
+	c.SendText("Hello")
+	expectFrames(t, client, nil)
+	c.SendText("World")
+	expectFrames(t, client, []byte{
+		0x81, 0x05, 'H', 'e', 'l', 'l', 'o',
+		0x81, 0x05, 'W', 'o', 'r', 'l', 'd',
+	})
+}
+
+func TestFlushMaxDelay(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetFlushPolicy(FlushPolicy{MaxBytes: 1000, MaxDelay: 10 * time.Millisecond})
+	c.SendText("Hello")
+	expectFrames(t, client, []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'})
+}
+
+func TestCoalescingPolicy(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetFlushPolicy(CoalescingPolicy(20 * time.Millisecond))
+	for i := 0; i < 10; i++ {
+		c.SendText("tick")
+	}
+	// One write on the pipe is one read
+	buf := make([]byte, 1000)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10*6 {
+		t.Errorf("Expected all messages in one write, got %v bytes", n)
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"bytes"
+)
+
+// A frame of an incoming message, delivered as soon as it arrives
+type Fragment struct {
+	Type    int    // Type of the message, TextMessage or BinaryMessage
+	Payload []byte // Owned by the handler
+	Last    bool   // The message ends with this fragment
+}
+
+// Called with every fragment of the incoming messages, in order. It is
+// called from the goroutine reading the connection, which waits for it to
+// return, so it must not block for long.
+type FragmentHandler func(c *Conn, f Fragment)
+
+// Deliver incoming messages fragment by fragment to handle as their frames
+// arrive, instead of as readers on In, for instance to show progress or to
+// parse incrementally. Messages delivered as fragments don't go through
+// the incoming interceptors. Takes effect from the next message, nil
+// delivers messages on In again.
+func (c *Conn) OnFragment(handle FragmentHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onFragment = handle
+}
+
+func (c *Conn) fragmentHandler() FragmentHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.onFragment
+}
+
+// An incoming message being delivered fragment by fragment
+type fragmentedMessage struct {
+	msgType int
+	handle  FragmentHandler
+}
+
+// Read a data frame of the message being fragmented and deliver it
+func (c *Conn) fragment(f *frame) (err error) {
+	var payload bytes.Buffer
+	if _, err = f.readPayloadTo(&payload); err != nil {
+		return
+	}
+	m := c.fragmenting
+	if f.header.Fin {
+		c.fragmenting = nil
+	}
+	m.handle(c, Fragment{Type: m.msgType, Payload: payload.Bytes(), Last: f.header.Fin})
+	return
+}
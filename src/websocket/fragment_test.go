@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestOnFragment(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	fragments := make(chan Fragment, 4)
+	c.OnFragment(func(c *Conn, f Fragment) {
+		fragments <- f
+	})
+	client.Write([]byte{0x02, 0x82, 0, 0, 0, 0, 'a', 'b'})
+	// Delivered before the message is complete
+	if f := <-fragments; f.Type != BinaryMessage || string(f.Payload) != "ab" || f.Last {
+		t.Errorf("Unexpected first fragment: %+v", f)
+	}
+	client.Write([]byte{0x89, 0x80, 0, 0, 0, 0}) // Interleaved ping
+	expectFrames(t, client, []byte{0x8a, 0x00})
+	client.Write([]byte{0x80, 0x81, 0, 0, 0, 0, 'c'})
+	if f := <-fragments; f.Type != BinaryMessage || string(f.Payload) != "c" || !f.Last {
+		t.Errorf("Unexpected last fragment: %+v", f)
+	}
+	c.OnFragment(nil)
+	client.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "hi" {
+		t.Errorf("Expected %q on In, got %q", "hi", msg)
+	}
+}
+
+func TestOnFragmentUnexpectedDataFrame(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.OnFragment(func(c *Conn, f Fragment) {})
+	go client.Write([]byte{
+		0x01, 0x81, 0, 0, 0, 0, 'a',
+		0x81, 0x81, 0, 0, 0, 0, 'b', // Not a continuation
+	})
+	for range c.In {
+	}
+	if e, ok := c.Err().(*errConnection); !ok || e.code != statusProtocolError {
+		t.Errorf("Expected a protocol error, got %v", c.Err())
+	}
+}
@@ -1,15 +1,20 @@
 package websocket
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"io"
+	"websocket/wsframe"
 )
 
 type frame struct {
 	header  *frameHeader
 	payload io.Reader
+	sent    io.Reader  // The outgoing message this is the last frame of
+	flushed chan error // If not nil, not a frame but a flush request
+	onFlush func()     // If not nil, called once the frame is flushed
+	slot    *arenaSlot // Arena slot the frame is in, if any
+	reader  wsframe.Payload
 }
 
 func newFrame(header *frameHeader, payload io.Reader) (f *frame) {
@@ -34,27 +39,29 @@ func nextFrame(r io.Reader) (f *frame, err error) {
 
 // Payload length for this frame
 func (f *frame) Len() int64 {
-	return f.header.payloadLength
+	return f.header.PayloadLength
 }
 
 // Payload length for this frame
 func (f *frame) Op() (opCode byte) {
-	opCode = f.header.opCode
+	opCode = f.header.OpCode
 	return
 }
 
+// Create a close frame with the status code and reason of e.
+// The status statusNoStatusRcvd gives a close frame without payload.
 // TODO: Reason must be valid UTF-8
-func newCloseFrame(e *errConnection) (f *frame, err error) {
-	reasonBytes := []byte(e.reason)
-	payloadLength := int64(2 + len(reasonBytes))
+func newCloseFrame(e *errConnection, maskingKey []byte) (f *frame, err error) {
+	buf := new(bytes.Buffer)
+	if e.code != statusNoStatusRcvd {
+		binary.Write(buf, binary.BigEndian, e.code)
+		buf.WriteString(e.reason)
+	}
 	var fh *frameHeader
-	fh, err = newFrameHeader(true, opCodeConnectionClose, payloadLength, nil)
+	fh, err = newFrameHeader(true, opCodeConnectionClose, int64(buf.Len()), maskingKey)
 	if err != nil {
 		return
 	}
-	buf := bytes.NewBuffer(make([]byte, 0, payloadLength))
-	binary.Write(buf, binary.BigEndian, e.code)
-	buf.Write(reasonBytes)
 	f = &frame{
 		header:  fh,
 		payload: buf,
@@ -63,35 +70,27 @@ func newCloseFrame(e *errConnection) (f *frame, err error) {
 }
 
 // Read the payload data from frame.payload into w.
-// Will mask if f.header.mask is set
-// Err will be io.
+// Will mask if f.header.Masked is set, which also unmasks incoming payloads.
+// Never reads past the payload, since f.payload is usually the connection.
+// Err will be io.ErrUnexpectedEOF if the payload ended prematurely.
 func (f *frame) readPayloadTo(w io.Writer) (n int64, err error) {
-	if f.Len() == 0 { // No payload
+	if f.Len() == 0 {
 		return
 	}
-	br := bufio.NewReader(f.payload)
-	bw := bufio.NewWriter(w)
-	var c byte
-	if f.header.mask {
-		for n = int64(0); n < f.header.payloadLength; n++ {
-			c, err = br.ReadByte()
-			if err != nil {
-				err = io.ErrUnexpectedEOF
-				return
-			}
-			err = bw.WriteByte(f.header.maskingKey[n%4] ^ c)
-			if err != nil {
-				err = io.ErrShortWrite
-				return
-			}
-		}
-	} else {
-		n, err = io.CopyN(bw, br, f.header.payloadLength)
-		if n == f.header.payloadLength && err == io.EOF {
-			// This is no error
-			err = nil
-		}
+	f.reader.Reset(f.header, f.payload)
+	if n, err = io.Copy(w, &f.reader); err == nil && n < f.Len() {
+		err = io.ErrUnexpectedEOF
 	}
-	err = bw.Flush()
 	return
 }
+
+// Read the header of the next incoming frame into the frame reused by the
+// router, see nextFrame. It is only valid until the next frame is read.
+func (c *Conn) readFrame() (f *frame, err error) {
+	fh, err := c.headers.Read(c.rw)
+	if err != nil {
+		return
+	}
+	c.inFrame = frame{header: fh, payload: c.rw}
+	return &c.inFrame, nil
+}
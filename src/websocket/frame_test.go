@@ -2,8 +2,10 @@ package websocket
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
+	"websocket/wsframe"
 )
 
 func TestControlEmpty(t *testing.T) {
@@ -95,3 +97,33 @@ func TestParseMaskedTextFrame(t *testing.T) {
 		t.Errorf("Message mismatch %v %v", w.Bytes(), hello.Bytes())
 	}
 }
+
+// A malformed header fails the connection with a protocol error
+func TestMalformedHeaderCloses(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	// The most significant bit of the 64 bit length is set
+	go client.Write([]byte{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if payload := readShortFrame(t, client); payload != "\x03\xeaMalformed frame header: Most significant bit of the payload length set" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	for range c.In {
+	}
+	if e, ok := c.Err().(*errConnection); !ok || e.code != statusProtocolError || !errors.Is(c.Err(), wsframe.ErrLengthMSB) {
+		t.Errorf("Expected a protocol error, got %v", c.Err())
+	}
+}
+
+func TestUnmaskedFrameCloses(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+	if payload := readShortFrame(t, client); payload != "\x03\xeaMalformed frame header: Frame from client not masked" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	for range c.In {
+	}
+	if !errors.Is(c.Err(), errClientUnmasked) {
+		t.Errorf("Expected %v, got %v", errClientUnmasked, c.Err())
+	}
+}
@@ -0,0 +1,20 @@
+package websocket
+
+// Start the closing handshake with code and reason, but keep delivering
+// incoming messages until the close frame of the other end-point arrives,
+// as RFC 6455 allows. Sending is refused from now on, and Close stops the
+// delivery, such as if the other end-point takes too long. The reason can
+// be at most 123 bytes, and code must be one which may be sent. If the
+// connection is already closing, nothing is done, like Close.
+func (c *Conn) CloseWrite(code uint16, reason string) (err error) {
+	if !sendableCloseCode(code) || len(reason) > 123 {
+		return errInvalidControlFrame
+	}
+	if c.startClose(newErrConnection(code, reason), true) {
+		return nil
+	}
+	if err = c.Err(); err == nil {
+		err = errConnClosed
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestCloseWrite(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	if err := c.CloseWrite(CloseNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if payload := readShortFrame(t, client); payload != "\x03\xe8bye" {
+		t.Fatalf("Unexpected close frame payload %q", payload)
+	}
+	if err := c.SendText("more"); err == nil {
+		t.Error("Sent after CloseWrite")
+	}
+	client.Write([]byte{0x81, 0x82, 0x00, 0x00, 0x00, 0x00, 'h', 'i'})
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "hi" {
+		t.Errorf("Expected hi after CloseWrite, got %q", msg)
+	}
+	client.Write([]byte{0x88, 0x82, 0x00, 0x00, 0x00, 0x00, 0x03, 0xe8})
+	if _, ok := <-c.In; ok {
+		t.Error("Message received after the close frame")
+	}
+	if err := c.Wait(); err != nil || !c.Cleanly() {
+		t.Errorf("Not closed cleanly: %v", err)
+	}
+}
+
+func TestCloseAfterCloseWrite(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.CloseWrite(CloseGoingAway, "")
+	if payload := readShortFrame(t, client); payload != "\x03\xe9" {
+		t.Fatalf("Unexpected close frame payload %q", payload)
+	}
+	if err := c.Close(); err != errConnClosed {
+		t.Errorf("Expected %v, got %v", errConnClosed, err)
+	}
+	client.Write([]byte{0x81, 0x82, 0x00, 0x00, 0x00, 0x00, 'h', 'i'})
+	client.Write([]byte{0x88, 0x80, 0x00, 0x00, 0x00, 0x00})
+	if _, ok := <-c.In; ok {
+		t.Error("Message delivered after Close")
+	}
+}
+
+func TestCloseWriteInvalid(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	for _, code := range []uint16{999, 1004, CloseNoStatusReceived, CloseAbnormalClosure, CloseTLSHandshake, 1016, 2999, 5000} {
+		if err := c.CloseWrite(code, ""); err != errInvalidControlFrame {
+			t.Errorf("%v: expected %v, got %v", code, errInvalidControlFrame, err)
+		}
+	}
+	if err := c.CloseWrite(CloseNormalClosure, string(make([]byte, 124))); err != errInvalidControlFrame {
+		t.Errorf("Long reason: expected %v, got %v", errInvalidControlFrame, err)
+	}
+	if c.State() != OPEN {
+		t.Error("Invalid CloseWrite closed the connection")
+	}
+}
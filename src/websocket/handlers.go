@@ -0,0 +1,43 @@
+package websocket
+
+// Handle incoming pings with h instead of answering them with a pong, nil
+// restoring the default. To still answer, h can call Pong with the
+// payload. Like the other control frame handlers, h is called on the
+// goroutine reading the connection, so it must not block for long. If it
+// returns an error, the connection is closed with 1011 (internal error).
+func (c *Conn) SetPingHandler(h func(payload []byte) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pingHandler = h
+}
+
+// Handle incoming pongs with h, nil for none, the default. The pongs
+// answering Ping are timed for Stats either way. If h returns an error,
+// the connection is closed with 1011 (internal error).
+func (c *Conn) SetPongHandler(h func(payload []byte) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pongHandler = h
+}
+
+// Call h with the status and reason of the close frame from the other
+// end-point, nil for none, the default. The closing handshake is completed
+// regardless, so h can only observe it, and an error it returns is logged.
+func (c *Conn) SetCloseHandler(h func(code uint16, reason string) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeHandler = h
+}
+
+// Call a ping or pong handler, if any. Returns the error which ends the
+// connection if the handler fails, and whether it was called.
+func (c *Conn) handleControl(h func(payload []byte) error, payload []byte) (handled bool, err error) {
+	if h == nil {
+		return
+	}
+	if err = h(payload); err != nil {
+		Log.Println(err)
+		err = c.failWith(newErrConnection(statusInternalError, "Control frame handler failed"))
+	}
+	return true, err
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPingHandler(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	pings := make(chan string, 1)
+	c.SetPingHandler(func(payload []byte) error {
+		pings <- string(payload)
+		return nil
+	})
+	client.Write([]byte{0x89, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	if payload := <-pings; payload != "hi" {
+		t.Errorf("Unexpected ping payload %q", payload)
+	}
+	expectFrames(t, client, nil) // No automatic pong
+}
+
+func TestPongHandler(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	pongs := make(chan string, 1)
+	c.SetPongHandler(func(payload []byte) error {
+		pongs <- string(payload)
+		return nil
+	})
+	client.Write([]byte{0x8a, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	if payload := <-pongs; payload != "hi" {
+		t.Errorf("Unexpected pong payload %q", payload)
+	}
+}
+
+func TestCloseHandler(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	var code uint16
+	var reason string
+	c.SetCloseHandler(func(c uint16, r string) error {
+		code, reason = c, r
+		return errors.New("Ignored")
+	})
+	client.Write([]byte{0x88, 0x84, 0, 0, 0, 0, 0x03, 0xe9, 'b', 'y'})
+	if payload := readShortFrame(t, client); payload != "\x03\xe9" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	for range c.In {
+	}
+	if code != CloseGoingAway || reason != "by" {
+		t.Errorf("Unexpected close %v %q", code, reason)
+	}
+}
+
+func TestControlHandlerError(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetPingHandler(func(payload []byte) error {
+		return errors.New("Failed")
+	})
+	client.Write([]byte{0x89, 0x80, 0, 0, 0, 0})
+	if payload := readShortFrame(t, client); payload != "\x03\xf3Control frame handler failed" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Aggregate counters of the connections upgraded by a Handler, such as for
+// a health endpoint to poll
+type HandlerStats struct {
+	Open        int              // Connections currently open
+	Upgrades    int64            // Handshakes completed
+	Rejected    int64            // Handshakes refused
+	MessagesIn  int64            // Data messages received
+	MessagesOut int64            // Data messages written
+	BytesIn     int64            // Payload bytes of the data frames received
+	BytesOut    int64            // Payload bytes of the data frames written
+	CloseCodes  map[uint16]int64 // Closed connections by the status the other end-point closed with, 1006 if none
+}
+
+// The counters of a Handler, shared by its connections
+type handlerStats struct {
+	upgrades, rejected      int64
+	messagesIn, messagesOut int64
+	bytesIn, bytesOut       int64
+
+	mu         sync.Mutex
+	conns      map[*Conn]bool // Open connections
+	closeCodes map[uint16]int64
+}
+
+// A snapshot of the counters of the connections of h
+func (h *Handler) Stats() (st HandlerStats) {
+	s := &h.stats
+	st.Upgrades = atomic.LoadInt64(&s.upgrades)
+	st.Rejected = atomic.LoadInt64(&s.rejected)
+	st.MessagesIn = atomic.LoadInt64(&s.messagesIn)
+	st.MessagesOut = atomic.LoadInt64(&s.messagesOut)
+	st.BytesIn = atomic.LoadInt64(&s.bytesIn)
+	st.BytesOut = atomic.LoadInt64(&s.bytesOut)
+	st.CloseCodes = make(map[uint16]int64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Open = len(s.conns)
+	for code, n := range s.closeCodes {
+		st.CloseCodes[code] = n
+	}
+	return
+}
+
+// Start counting for a newly upgraded connection
+func (s *handlerStats) attach(c *Conn) {
+	c.stats = s
+	c.id = atomic.AddInt64(&s.upgrades, 1)
+	c.started = time.Now()
+	c.lastActive = c.started.UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*Conn]bool)
+	}
+	s.conns[c] = true
+}
+
+// Stop counting for a closed connection, and record how it was closed
+func (s *handlerStats) detach(c *Conn) {
+	code := c.closeStatus().code
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.conns[c] {
+		return
+	}
+	delete(s.conns, c)
+	if s.closeCodes == nil {
+		s.closeCodes = make(map[uint16]int64)
+	}
+	s.closeCodes[code]++
+}
+
+// Count a data frame received, or written if out
+func (s *handlerStats) frame(f *frame, out bool) {
+	messages, bytes := &s.messagesIn, &s.bytesIn
+	if out {
+		messages, bytes = &s.messagesOut, &s.bytesOut
+	}
+	if f.Op() != opCodeContinuation {
+		atomic.AddInt64(messages, 1)
+	}
+	atomic.AddInt64(bytes, f.Len())
+}
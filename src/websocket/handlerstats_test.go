@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHandlerStats(t *testing.T) {
+	h := NewHandler()
+	req := newHandshakeRequest()
+	req.Header.Del("Sec-WebSocket-Key")
+	if _, resp := handshake(t, h, req); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected the handshake to be refused, got %v", resp.Status)
+	}
+	client, _ := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	c := <-h.Conns
+	if st := h.Stats(); st.Open != 1 || st.Upgrades != 1 || st.Rejected != 1 {
+		t.Errorf("Unexpected connection counts %+v", st)
+	}
+	client.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	ioutil.ReadAll(<-c.In)
+	c.Out <- &Message{Type: TextMessage, Reader: strings.NewReader("hello")}
+	io.ReadFull(client, make([]byte, 7))
+	// Going away
+	client.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe9})
+	io.ReadFull(client, make([]byte, 4))
+	<-c.done
+	st := h.Stats()
+	if st.Open != 0 || st.MessagesIn != 1 || st.BytesIn != 2 || st.MessagesOut != 1 || st.BytesOut != 5 {
+		t.Errorf("Unexpected counts %+v", st)
+	}
+	if len(st.CloseCodes) != 1 || st.CloseCodes[statusGoingAway] != 1 {
+		t.Errorf("Expected one connection closed with 1001, got %v", st.CloseCodes)
+	}
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+	"websocket/wsframe"
+)
+
+// The payload of a single frame message handed off to the application,
+// read straight from the connection, see DeliverHandoff. The router waits
+// until it is released.
+type handoffReader struct {
+	mu       sync.Mutex
+	r        io.Reader // The payload, limited and unmasked
+	left     int64     // Payload bytes not yet read
+	err      error     // Returned once released, io.EOF if read to the end
+	netErr   error     // From reading the connection, if it failed
+	released chan bool // Closed once the router may read on
+}
+
+// Hand the payload of a single frame message to the application, and wait
+// until it has been read, or taken back because it was abandoned or the
+// connection is closing
+func (c *Conn) handOff(f *frame) (err error) {
+	h := &handoffReader{
+		r:        wsframe.PayloadReader(f.header, f.payload),
+		left:     f.Len(),
+		released: make(chan bool),
+	}
+	select {
+	case c.in <- c.inFlightMessage(&Message{Type: int(f.Op()), Reader: h}):
+	case <-c.inDone:
+		return h.takeBack(io.ErrUnexpectedEOF) // Closing, nobody reads the message
+	}
+	var expired <-chan time.Time
+	if d := c.AbandonTimeout(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-h.released:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.netErr
+	case <-expired:
+		return h.takeBack(errMessageAbandoned)
+	case <-c.inDone:
+		return h.takeBack(io.ErrUnexpectedEOF)
+	}
+}
+
+func (h *handoffReader) Read(p []byte) (n int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return 0, h.err
+	}
+	n, err = h.r.Read(p)
+	h.left -= int64(n)
+	if err == io.EOF && h.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		h.release(err)
+	} else if err != nil {
+		h.netErr = err
+		h.release(err)
+	}
+	return
+}
+
+// Read the rest of the payload for the router, so that reading the message
+// fails with err from now on. Returns the error reading the connection.
+func (h *handoffReader) takeBack(err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		if n, e := io.Copy(ioutil.Discard, h.r); e != nil || n < h.left {
+			h.netErr = io.ErrUnexpectedEOF
+		}
+		h.release(err)
+	}
+	return h.netErr
+}
+
+// Called with mu held
+func (h *handoffReader) release(err error) {
+	h.err = err
+	close(h.released)
+}
+
+// Discard what is left of an incoming message. A message handed off with
+// DeliverHandoff must be read to the end or released, since the connection
+// isn't read while it is being read.
+func (m *Message) Release() (err error) {
+	_, err = io.Copy(ioutil.Discard, m)
+	return
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDeliverHandoff(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetDelivery(DeliverHandoff)
+	go client.Write([]byte{
+		0x81, 0x82, 0, 1, 0, 0, 'H', 'h', // Masked
+		0x82, 0x82, 0, 0, 0, 0, 'H', 'o',
+	})
+	m := (<-c.In).(*Message)
+	if _, ok := m.Reader.(*handoffReader); !ok {
+		t.Fatalf("Expected a message handed off, got %T", m.Reader)
+	}
+	select {
+	case <-c.In:
+		t.Fatal("Next message read before the first was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if data, err := ioutil.ReadAll(m); string(data) != "Hi" || err != nil {
+		t.Errorf("Unexpected payload %q (%v)", data, err)
+	}
+	m = (<-c.In).(*Message)
+	if err := m.Release(); err != nil {
+		t.Error(err)
+	}
+	if data, _ := ioutil.ReadAll(m); len(data) != 0 {
+		t.Errorf("Read %q after release", data)
+	}
+}
+
+func TestDeliverHandoffAbandoned(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetDelivery(DeliverHandoff)
+	c.SetAbandonTimeout(10 * time.Millisecond)
+	go client.Write([]byte{
+		0x81, 0x82, 0, 0, 0, 0, 'H', 'i',
+		0x81, 0x82, 0, 0, 0, 0, 'H', 'o',
+	})
+	abandoned := <-c.In
+	if data, _ := ioutil.ReadAll(<-c.In); string(data) != "Ho" {
+		t.Errorf("Unexpected payload %q", data)
+	}
+	if _, err := ioutil.ReadAll(abandoned); err != errMessageAbandoned {
+		t.Errorf("Expected %v, got %v", errMessageAbandoned, err)
+	}
+}
@@ -0,0 +1,236 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"websocket/wsframe"
+)
+
+// The protocol version of connections with the legacy hixie-76 handshake,
+// which has no version header
+const hixie76Version = 0
+
+// Length of the challenge body of a hixie-76 handshake request
+const hixie76ChallengeLength = 8
+
+var (
+	errMalformedHixie76Key = classify(ErrHandshake, "Malformed Sec-WebSocket-Key1 or Sec-WebSocket-Key2")
+	errHixie76Frame        = classify(ErrProtocol, "Unsupported hixie-76 frame")
+)
+
+// True if r is a legacy hixie-76 handshake request
+func isHixie76(r *http.Request) bool {
+	return r.Header.Get("Sec-WebSocket-Key1") != "" &&
+		r.Header.Get("Sec-WebSocket-Key2") != "" &&
+		r.Header.Get("Sec-WebSocket-Version") == ""
+}
+
+// Perform the server side of a hixie-76 handshake, like upgrade. The
+// challenge follows the request header, and is answered after the
+// response header.
+func (h *Handler) upgradeHixie76(w http.ResponseWriter, r *http.Request) (c *Conn) {
+	key1, err1 := hixie76Key(r.Header.Get("Sec-WebSocket-Key1"))
+	key2, err2 := hixie76Key(r.Header.Get("Sec-WebSocket-Key2"))
+	if err1 != nil || err2 != nil || !r.ProtoAtLeast(minProtoMajor, minProtoMinor) ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") ||
+		!connectionUpgrade(r.Header, h.Compliance) {
+		h.refuse(w, r, errMalformedClientHandshake, http.StatusBadRequest)
+		return
+	}
+	if !h.admit(w, r) {
+		return
+	}
+	conn, rw, err := hijack(w)
+	if err != nil {
+		h.upgradeError(w, r, err)
+		return
+	}
+	challenge := make([]byte, 8+hixie76ChallengeLength)
+	binary.BigEndian.PutUint32(challenge, key1)
+	binary.BigEndian.PutUint32(challenge[4:], key2)
+	if _, err = io.ReadFull(rw, challenge[8:]); err != nil {
+		recordFailure(r, err)
+		Log.Println(err)
+		conn.Close()
+		return
+	}
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	header := w.Header()
+	header.Set("Upgrade", "WebSocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Origin", r.Header.Get("Origin"))
+	header.Set("Sec-WebSocket-Location", scheme+"://"+r.Host+r.URL.RequestURI())
+	if h.ResponseHeader != nil {
+		h.ResponseHeader(header, http.StatusSwitchingProtocols)
+	}
+	rw.WriteString("HTTP/1.1 101 WebSocket Protocol Handshake\r\n")
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	answer := md5.Sum(challenge)
+	rw.Write(answer[:])
+	if err = rw.Flush(); err != nil {
+		recordFailure(r, err)
+		Log.Println(err)
+		conn.Close()
+		return
+	}
+	hc := &hixieConn{Conn: conn, r: rw.Reader}
+	c = newConn(hc, bufio.NewReadWriter(bufio.NewReader(hc), bufio.NewWriter(hc)), true, h.Buffers)
+	c.setNegotiated(header, hixie76Version)
+	h.setup(c, r)
+	c.unmaskedAllowed = true // Translated frames are never masked
+	return
+}
+
+// The number encoded in a Sec-WebSocket-Key1 or Sec-WebSocket-Key2 header:
+// its digits divided by its number of spaces
+func hixie76Key(key string) (n uint32, err error) {
+	var digits uint64
+	spaces := uint64(0)
+	for _, r := range key {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = digits*10 + uint64(r-'0')
+		case r == ' ':
+			spaces++
+		}
+		if digits > math.MaxUint32 {
+			return 0, errMalformedHixie76Key
+		}
+	}
+	if spaces == 0 || digits%spaces != 0 {
+		return 0, errMalformedHixie76Key
+	}
+	return uint32(digits / spaces), nil
+}
+
+// A hixie-76 connection, which translates its framing to and from RFC 6455
+// frames, so that a Conn runs on top of it. A text message is a 0x00 byte,
+// UTF-8 text and a 0xFF byte, and 0xFF 0x00 closes the connection. There
+// is nothing like binary messages, pings and pongs, those written are
+// dropped.
+type hixieConn struct {
+	net.Conn
+	r *bufio.Reader // Of the hijacked connection, may hold buffered frames
+
+	// Read side, only used by the reading goroutine
+	in        bytes.Buffer // Translated frames not yet read
+	inMessage bool         // Within a text message
+	inOp      byte         // Opcode of the next translated frame
+
+	// Write side, only used by the writing goroutine
+	out     bytes.Buffer // Written frames not yet complete
+	outText bool         // Within a text message, not a dropped one
+}
+
+func (hc *hixieConn) Read(p []byte) (n int, err error) {
+	for hc.in.Len() == 0 {
+		if err = hc.readFrame(); err != nil {
+			return
+		}
+	}
+	return hc.in.Read(p)
+}
+
+// Read the next part of a hixie-76 message, and translate it to a frame
+// if there is anything to deliver
+func (hc *hixieConn) readFrame() (err error) {
+	if !hc.inMessage {
+		var b byte
+		if b, err = hc.r.ReadByte(); err != nil {
+			return
+		}
+		switch b {
+		case 0x00:
+			hc.inMessage, hc.inOp = true, opCodeText
+			return
+		case 0xff:
+			if b, err = hc.r.ReadByte(); err == nil && b != 0x00 {
+				err = errHixie76Frame
+			}
+			if err == nil {
+				hc.translate(true, opCodeConnectionClose, nil)
+			}
+			return
+		}
+		return errHixie76Frame
+	}
+	chunk, err := hc.r.ReadSlice(0xff)
+	fin := err == nil
+	if fin {
+		chunk = chunk[:len(chunk)-1]
+	} else if err != bufio.ErrBufferFull {
+		return
+	}
+	hc.translate(fin, hc.inOp, chunk)
+	hc.inMessage, hc.inOp = !fin, opCodeContinuation
+	return nil
+}
+
+// Queue a frame to be read
+func (hc *hixieConn) translate(fin bool, op byte, payload []byte) {
+	fh, _ := newFrameHeader(fin, op, int64(len(payload)), nil)
+	hc.in.Write(fh.Bytes())
+	hc.in.Write(payload)
+}
+
+// Take frames to write, and write those which are complete
+func (hc *hixieConn) Write(p []byte) (n int, err error) {
+	hc.out.Write(p)
+	for {
+		buffered := hc.out.Bytes()
+		r := bytes.NewReader(buffered)
+		fh, err := wsframe.ReadHeader(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && int64(r.Len()) < fh.PayloadLength) {
+			break // Not complete yet
+		} else if err != nil {
+			return 0, err
+		}
+		start := len(buffered) - r.Len()
+		end := start + int(fh.PayloadLength)
+		if err = hc.writeFrame(fh, buffered[start:end]); err != nil {
+			return 0, err
+		}
+		hc.out.Next(end)
+	}
+	return len(p), nil
+}
+
+// Write a frame in hixie-76 framing, if there is an equivalent
+func (hc *hixieConn) writeFrame(fh *frameHeader, payload []byte) (err error) {
+	var b []byte
+	switch fh.OpCode {
+	case opCodeText:
+		hc.outText = true
+		b = append([]byte{0x00}, payload...)
+	case opCodeContinuation:
+		if !hc.outText {
+			return // Of a dropped binary message
+		}
+		b = payload
+	case opCodeConnectionClose:
+		_, err = hc.Conn.Write([]byte{0xff, 0x00})
+		return
+	case opCodeBinary:
+		hc.outText = false
+		Log.Println("Binary message dropped, not supported by hixie-76")
+		return
+	default:
+		return // Pings and pongs
+	}
+	if fh.Fin {
+		b = append(b, 0xff)
+		hc.outText = false
+	}
+	_, err = hc.Conn.Write(b)
+	return
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The example handshake of draft-hixie-thewebsocketprotocol-76
+const hixie76Request = "GET /demo HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key2: 12998 5 Y3 1  .P00\r\n" +
+	"Upgrade: WebSocket\r\n" +
+	"Sec-WebSocket-Key1: 4 @1  46546xW%0l 1 5\r\n" +
+	"Origin: http://example.com\r\n" +
+	"\r\n" +
+	"^n:ds[4U"
+
+// Start a server with h and send the hixie-76 example handshake request
+func hixie76Handshake(t *testing.T, h http.Handler) (client net.Conn, resp *http.Response) {
+	server := httptest.NewServer(h)
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(client, hixie76Request)
+	req, _ := http.NewRequest("GET", "/demo", nil)
+	if resp, err = http.ReadResponse(bufio.NewReaderSize(oneByteReader{client}, 16), req); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestHixie76(t *testing.T) {
+	h := NewHandler()
+	h.Hixie76 = true
+	client, resp := hixie76Handshake(t, h)
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v", resp.Status)
+	}
+	if loc := resp.Header.Get("Sec-WebSocket-Location"); !strings.HasSuffix(loc, "/demo") {
+		t.Errorf("Unexpected location %q", loc)
+	}
+	answer := make([]byte, 16)
+	io.ReadFull(client, answer)
+	if string(answer) != "8jKS'y:G*Co,Wxa-" {
+		t.Errorf("Unexpected challenge answer %q", answer)
+	}
+	c := <-h.Conns
+	if c.Version() != 0 {
+		t.Errorf("Expected version 0, got %v", c.Version())
+	}
+	client.Write([]byte("\x00hello\xff\x00\xff"))
+	for _, expected := range []string{"hello", ""} {
+		if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != expected {
+			t.Errorf("Expected %q, got %q", expected, msg)
+		}
+	}
+	c.Out <- &Message{Type: BinaryMessage, Reader: strings.NewReader("dropped")}
+	c.Out <- &Message{Type: TextMessage, Reader: strings.NewReader("hi")}
+	frame := make([]byte, 4)
+	io.ReadFull(client, frame)
+	if string(frame) != "\x00hi\xff" {
+		t.Errorf("Unexpected frame %q", frame)
+	}
+	client.Write([]byte{0xff, 0x00})
+	io.ReadFull(client, frame[:2])
+	if string(frame[:2]) != "\xff\x00" {
+		t.Errorf("Expected the closing handshake, got %q", frame[:2])
+	}
+	if err := c.Wait(); err != nil {
+		t.Errorf("Expected a clean close, got %v", err)
+	}
+}
+
+func TestHixie76Disabled(t *testing.T) {
+	client, resp := hixie76Handshake(t, NewHandler())
+	defer client.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("Expected 426, got %v", resp.Status)
+	}
+}
+
+func TestHixie76Key(t *testing.T) {
+	if n, err := hixie76Key("4 @1  46546xW%0l 1 5"); n != 829309203 || err != nil {
+		t.Errorf("Expected 829309203, got %v, %v", n, err)
+	}
+	for _, key := range []string{"12345", "1 2 3", "99999999999 "} {
+		if _, err := hixie76Key(key); err != errMalformedHixie76Key {
+			t.Errorf("Expected %q to be malformed, got %v", key, err)
+		}
+	}
+}
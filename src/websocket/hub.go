@@ -0,0 +1,313 @@
+package websocket
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A set of subscribed connections which messages are broadcast to. The
+// subscribers are partitioned into shards, each with its own lock and
+// worker goroutine, so that a broadcast to a large number of connections
+// doesn't serialize on one goroutine or one lock. A slow or dead
+// connection never blocks the delivery to the others.
+type Hub struct {
+	// How long a broadcast waits for room in the send queues of the
+	// connections, zero for not at all. The message is dropped for
+	// connections which are still full.
+	Timeout time.Duration
+
+	// If positive, a connection which fails this many broadcasts in a row
+	// is closed and unsubscribed
+	MaxFailures int
+
+	// Number of recent broadcasts kept for clients of SSEHandler resuming
+	// with Last-Event-ID
+	History int
+
+	shards    []*hubShard
+	done      chan bool // Closed by Close
+	closeOnce sync.Once
+	mu        sync.Mutex // Serializes Subscribe
+	next      int        // Shard of the next subscriber, round robin
+	events    hubEvents  // See SSEHandler
+}
+
+// The outcome of a broadcast
+type BroadcastResult struct {
+	Sent     int                // Number of connections the message was queued for
+	Failures []BroadcastFailure // Connections it wasn't queued for
+}
+
+// A connection a broadcast message couldn't be queued for. Err is
+// errMessageDropped if its send queue stayed full, errSlowClient if it was
+// evicted for that, or errConnClosed if it was already closed. Closed and
+// evicted connections are unsubscribed.
+type BroadcastFailure struct {
+	Conn *Conn
+	Err  error
+}
+
+// A partition of the subscribers of a hub
+type hubShard struct {
+	mu    sync.Mutex
+	conns map[*Conn]int // Broadcasts failed in a row per connection
+	jobs  chan *broadcastJob
+}
+
+// A message being broadcast, done when every shard has queued it
+type broadcastJob struct {
+	selector Selector // Recipients, everyone if nil
+	msgType  int
+	payload  []byte
+	source   *sharedSource // If not nil, read instead of payload
+	shared   *SharedBuffer // If not nil, sent instead of payload
+	wg       sync.WaitGroup
+	mu       sync.Mutex // Guards result
+	result   BroadcastResult
+}
+
+// Create a hub with the given number of shards, or one per CPU if not
+// positive. Close stops its workers.
+func NewHub(shards int) (h *Hub) {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	h = &Hub{shards: make([]*hubShard, shards), done: make(chan bool)}
+	for i := range h.shards {
+		s := &hubShard{
+			conns: make(map[*Conn]int),
+			jobs:  make(chan *broadcastJob),
+		}
+		h.shards[i] = s
+		go h.work(s)
+	}
+	return
+}
+
+// Add a connection to the broadcasts, unless already subscribed. It is
+// removed once closed, at the latest by the next broadcast.
+func (h *Hub) Subscribe(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.shards {
+		s.mu.Lock()
+		_, ok := s.conns[c]
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	s := h.shards[h.next%len(h.shards)]
+	h.next++
+	s.mu.Lock()
+	s.conns[c] = 0
+	s.mu.Unlock()
+}
+
+// Remove a connection from the broadcasts
+func (h *Hub) Unsubscribe(c *Conn) {
+	for _, s := range h.shards {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}
+}
+
+// Number of subscribed connections
+func (h *Hub) Len() (n int) {
+	for _, s := range h.shards {
+		s.mu.Lock()
+		n += len(s.conns)
+		s.mu.Unlock()
+	}
+	return
+}
+
+// Send a message of msgType to every subscriber, as a single frame. The
+// shards queue it in parallel, and Broadcast returns once it is queued for
+// the subscribers, or failed for them. The payload is shared, not copied,
+// and must not be modified afterwards. Clients of SSEHandler get it too.
+func (h *Hub) Broadcast(msgType int, payload []byte) BroadcastResult {
+	return h.SendTo(nil, msgType, payload)
+}
+
+// Broadcast a message to the subscribers selected, such as
+// AllTags("tenant:42", "beta") or AnyTag("shard:1", "shard:2"). The
+// selector is called from the workers of the shards, concurrently.
+func (h *Hub) SendTo(selector Selector, msgType int, payload []byte) BroadcastResult {
+	if payload == nil {
+		payload = []byte{}
+	}
+	if selector == nil {
+		h.events.publish(msgType, payload, h.History)
+	}
+	return h.dispatch(&broadcastJob{selector: selector, msgType: msgType, payload: payload})
+}
+
+// Broadcast a message of msgType read from r to every subscriber, like
+// Broadcast, without reading it into memory first. The message is read
+// once, in pooled chunks which are shared by the subscribers and reused
+// once every one of them has sent them. Returns once the message is queued,
+// r is read as the connections send it, and must not be used by the caller
+// afterwards. If reading r fails, the connections still sending the
+// message are closed with 1011 (internal error). Clients of SSEHandler
+// don't get it.
+func (h *Hub) BroadcastFrom(msgType int, r io.Reader) BroadcastResult {
+	job := &broadcastJob{msgType: msgType, source: newSharedSource(r)}
+	defer job.source.seal()
+	return h.dispatch(job)
+}
+
+// Broadcast b as a message of msgType to every subscriber, like Broadcast.
+// The send queues share b, which goes back to the pool once the last
+// connection has written it. The caller keeps its reference, and may
+// release it right away. Clients of SSEHandler don't get it.
+func (h *Hub) BroadcastShared(msgType int, b *SharedBuffer) BroadcastResult {
+	return h.dispatch(&broadcastJob{msgType: msgType, shared: b})
+}
+
+// Hand a job to every shard, and wait for it to be queued
+func (h *Hub) dispatch(job *broadcastJob) BroadcastResult {
+	for _, s := range h.shards {
+		job.wg.Add(1)
+		select {
+		case s.jobs <- job:
+		case <-h.done:
+			job.wg.Done()
+		}
+	}
+	job.wg.Wait()
+	return job.result
+}
+
+// Stop the workers. Broadcasts do nothing afterwards, the subscribed
+// connections are left open.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Queue the broadcasts for the connections of shard s, in order
+func (h *Hub) work(s *hubShard) {
+	var conns, full []*Conn
+	for {
+		var job *broadcastJob
+		select {
+		case job = <-s.jobs:
+		case <-h.done:
+			return
+		}
+		s.mu.Lock()
+		conns, full = conns[:0], full[:0]
+		for c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+		var result BroadcastResult
+		// First queue for everyone with room, then wait for the others
+		for _, c := range conns {
+			if job.selector != nil && !job.selector(c) {
+				continue
+			}
+			switch err := h.queue(c, job, nil); err {
+			case nil:
+				s.succeeded(c)
+				result.Sent++
+			case errMessageDropped:
+				full = append(full, c)
+			default:
+				result.Failures = append(result.Failures, s.failed(c, err, h.MaxFailures))
+			}
+		}
+		if len(full) > 0 {
+			expired := make(chan bool)
+			timer := time.AfterFunc(h.Timeout, func() { close(expired) })
+			for _, c := range full {
+				if err := h.queue(c, job, expired); err == nil {
+					s.succeeded(c)
+					result.Sent++
+				} else {
+					result.Failures = append(result.Failures, s.failed(c, err, h.MaxFailures))
+				}
+			}
+			timer.Stop()
+		}
+		job.mu.Lock()
+		job.result.Sent += result.Sent
+		job.result.Failures = append(job.result.Failures, result.Failures...)
+		job.mu.Unlock()
+		job.wg.Done()
+	}
+}
+
+// Queue a broadcast message for c. Returns errMessageDropped if the send
+// queue is full when expired is closed, immediately if expired is nil.
+func (h *Hub) queue(c *Conn, job *broadcastJob, expired <-chan bool) (err error) {
+	if c.State() != OPEN {
+		return errConnClosed
+	}
+	var req io.Reader = &writeRequest{Message: &Message{Type: job.msgType}, payload: job.payload}
+	if job.shared != nil {
+		req = job.shared.message(job.msgType)
+		defer func() {
+			if err != nil {
+				job.shared.Release()
+			}
+		}()
+	} else if job.source != nil {
+		sr := job.source.reader()
+		req = &Message{Type: job.msgType, Reader: sr, Sent: func(error) { sr.Close() }}
+		defer func() {
+			if err != nil {
+				sr.Close()
+			}
+		}()
+	}
+	if expired == nil {
+		select {
+		case c.Out <- req:
+			c.failLateUnsent()
+		default:
+			err = errMessageDropped
+		}
+		return
+	}
+	select {
+	case c.Out <- req:
+		c.failLateUnsent()
+	case <-c.done:
+		err = errConnClosed
+	case <-expired:
+		err = errMessageDropped
+	}
+	return
+}
+
+func (s *hubShard) succeeded(c *Conn) {
+	s.mu.Lock()
+	if _, ok := s.conns[c]; ok {
+		s.conns[c] = 0
+	}
+	s.mu.Unlock()
+}
+
+// Count a failed broadcast for c, and unsubscribe it if closed or evicted
+func (s *hubShard) failed(c *Conn, err error, maxFailures int) (f BroadcastFailure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures, ok := s.conns[c]
+	if !ok {
+		return BroadcastFailure{c, err} // Unsubscribed meanwhile
+	}
+	s.conns[c] = failures + 1
+	if err == errMessageDropped && maxFailures > 0 && failures+1 >= maxFailures {
+		// Without a closing handshake, which would wait for the full queue
+		err = errSlowClient
+		go c.fail(err)
+	}
+	if err != errMessageDropped {
+		delete(s.conns, c)
+	}
+	return BroadcastFailure{c, err}
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHubBroadcast(t *testing.T) {
+	n := packageGoroutines()
+	h := NewHub(3)
+	var clients []net.Conn
+	for i := 0; i < 7; i++ {
+		c, client := newPipeConn()
+		defer client.Close()
+		h.Subscribe(c)
+		clients = append(clients, client)
+	}
+	if result := h.Broadcast(TextMessage, []byte("Hi")); result.Sent != 7 || len(result.Failures) != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	for _, client := range clients {
+		expectFrames(t, client, []byte{0x81, 0x02, 'H', 'i'})
+	}
+	clients[0].Close()
+	for h.Len() == 7 {
+		h.Broadcast(BinaryMessage, []byte{0x2a})
+		runtime.Gosched()
+	}
+	if h.Len() != 6 {
+		t.Errorf("Expected 6 subscribers, got %v", h.Len())
+	}
+	for _, client := range clients[1:] {
+		client.Close()
+	}
+	h.Close()
+	waitForGoroutines(t, n)
+}
+
+func TestHubSlowConnection(t *testing.T) {
+	h := NewHub(1)
+	defer h.Close()
+	h.Timeout = 10 * time.Millisecond
+	h.MaxFailures = 2
+	fast, fastClient := newPipeConn()
+	defer fastClient.Close()
+	go io.Copy(ioutil.Discard, fastClient)
+	slow, slowClient := newPipeConn()
+	defer slowClient.Close()
+	h.Subscribe(fast)
+	h.Subscribe(slow)
+	var failures []BroadcastFailure
+	for i := 0; i < 1000 && len(failures) < 2; i++ {
+		result := h.Broadcast(BinaryMessage, make([]byte, 10))
+		if result.Sent < 1 {
+			t.Fatal("Broadcast to the fast connection failed")
+		}
+		failures = append(failures, result.Failures...)
+	}
+	if len(failures) != 2 || failures[0].Conn != slow || failures[0].Err != errMessageDropped {
+		t.Fatalf("Unexpected failures: %+v", failures)
+	}
+	if failures[1].Err != errSlowClient {
+		t.Errorf("Expected the slow connection evicted, got %v", failures[1].Err)
+	}
+	if err := slow.Wait(); err != errSlowClient {
+		t.Errorf("Expected errSlowClient, got %v", err)
+	}
+	if h.Len() != 1 {
+		t.Errorf("Expected 1 subscriber, got %v", h.Len())
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"io"
+	"sync"
+)
+
+// Stop reading from the network while n incoming messages are in flight,
+// zero for no limit, the default. A message is in flight from when it is
+// delivered on In until it has been read to the end, or its bytes taken
+// with Message.Bytes. While the application is behind, TCP flow control
+// pushes back on the other end-point, instead of messages piling up or
+// the connection stalling within a half read message. Control frames
+// aren't read either, so a message which is never read holds up the
+// connection until it is closed.
+func (c *Conn) SetInFlightLimit(n int) {
+	c.mu.Lock()
+	c.inFlightLimit = n
+	c.mu.Unlock()
+	c.signalConsumed() // The window may have grown
+}
+
+// The limit set with SetInFlightLimit
+func (c *Conn) InFlightLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlightLimit
+}
+
+// The number of incoming messages in flight, see SetInFlightLimit
+func (c *Conn) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight
+}
+
+// Block the router while the in flight window is full, or until incoming
+// messages are discarded
+func (c *Conn) waitInFlight() {
+	for {
+		c.mu.Lock()
+		full := c.inFlightLimit > 0 && c.inFlight >= c.inFlightLimit
+		c.mu.Unlock()
+		if !full {
+			return
+		}
+		select {
+		case <-c.consumed:
+		case <-c.inDone:
+			return
+		}
+	}
+}
+
+// Count an incoming message as in flight until it is consumed, if there
+// is a limit
+func (c *Conn) inFlightMessage(m *Message) *Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlightLimit <= 0 {
+		return m
+	}
+	c.inFlight++
+	m.Reader = &inFlightReader{Reader: m.Reader, c: c}
+	return m
+}
+
+// Wake the router if it waits for the window
+func (c *Conn) signalConsumed() {
+	select {
+	case c.consumed <- true:
+	default:
+	}
+}
+
+// The reader of an incoming message in flight
+type inFlightReader struct {
+	io.Reader
+	c    *Conn
+	once sync.Once
+}
+
+func (r *inFlightReader) Read(p []byte) (n int, err error) {
+	if n, err = r.Reader.Read(p); err != nil {
+		r.done()
+	}
+	return
+}
+
+// Count the message as consumed, once
+func (r *inFlightReader) done() {
+	r.once.Do(func() {
+		r.c.mu.Lock()
+		r.c.inFlight--
+		r.c.mu.Unlock()
+		r.c.signalConsumed()
+	})
+}
@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimit(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetInFlightLimit(1)
+	c.SetDirectDelivery(16)
+	go client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, 'a', 0x81, 0x81, 0, 0, 0, 0, 'b'})
+	first := <-c.In
+	select {
+	case <-c.In:
+		t.Fatal("Recieved a message beyond the in flight limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if n := c.InFlight(); n != 1 {
+		t.Errorf("Expected 1 message in flight, got %v", n)
+	}
+	if msg, _ := ioutil.ReadAll(first); string(msg) != "a" {
+		t.Errorf("Expected %q, got %q", "a", msg)
+	}
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "b" {
+		t.Errorf("Expected %q, got %q", "b", msg)
+	}
+	if n := c.InFlight(); n != 0 {
+		t.Errorf("Expected no messages in flight, got %v", n)
+	}
+}
+
+func TestInFlightBytes(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetInFlightLimit(1)
+	c.SetDelivery(DeliverBuffered)
+	go client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, 'a'})
+	if m := (<-c.In).(*Message); string(m.Bytes()) != "a" {
+		t.Errorf("Expected %q, got %q", "a", m.Bytes())
+	}
+	if n := c.InFlight(); n != 0 {
+		t.Errorf("Expected the message to be consumed by Bytes, got %v in flight", n)
+	}
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Called for every outgoing message before it is split into frames. It may
+// observe the message, transform it by changing its Type or replacing its
+// Reader, for instance to add an envelope or to encrypt it, or veto it by
+// returning an error. A vetoed message isn't sent, and is reported as
+// failed with the error.
+type OutgoingInterceptor func(c *Conn, m *Message) error
+
+// Add interceptors for outgoing messages, called in order after those
+// already added. Messages of WriteSized are streamed with their size as
+// given, so interceptors must not change their length.
+func (c *Conn) InterceptOutgoing(interceptors ...OutgoingInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outgoing = append(c.outgoing, interceptors...)
+}
+
+// Run the outgoing interceptors on a message taken from Out. Returns the
+// reader to send, or the error which vetoed it.
+func (c *Conn) interceptOutgoing(r io.Reader) (_ io.Reader, err error) {
+	c.mu.Lock()
+	interceptors := c.outgoing
+	c.mu.Unlock()
+	if len(interceptors) == 0 {
+		return r, nil
+	}
+	var m *Message
+	switch v := r.(type) {
+	case *Message:
+		m = v
+	case *writeRequest:
+		m = v.Message
+		if v.payload != nil {
+			m.Reader = bytes.NewReader(v.payload)
+			defer func() {
+				// Still sent as a single frame
+				v.payload, _ = ioutil.ReadAll(m.Reader)
+			}()
+		}
+	default:
+		m = &Message{Type: TextMessage, Reader: r}
+		r = m
+	}
+	for _, intercept := range interceptors {
+		if err = intercept(c, m); err != nil {
+			break
+		}
+	}
+	return r, err
+}
+
+// Called for every incoming message once all of its frames have arrived,
+// before it is delivered on In. The message is complete, so its payload
+// may be read, for instance to validate or decrypt it, and replaced. A
+// message is rejected by returning an error, and isn't delivered. If the
+// error is a *RejectError, the connection is also closed with its code.
+type IncomingInterceptor func(c *Conn, m *Message) error
+
+// An error of an incoming interceptor which closes the connection with Code
+// and Reason, such as 1008 for a policy violation. A code which must not be
+// sent in a close frame, such as 1006, closes it with 1011 (internal error)
+// instead. The reason is cut to the 123 bytes which fit in a close frame.
+type RejectError struct {
+	Code   uint16
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("Message rejected (%v): %v", e.Code, e.Reason)
+}
+
+// The status to close the connection with
+func (e *RejectError) status() *errConnection {
+	if !sendableCloseCode(e.Code) {
+		return newErrConnection(statusInternalError, "Invalid close code of interceptor")
+	}
+	reason := e.Reason
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return newErrConnection(e.Code, reason)
+}
+
+// Add interceptors for incoming messages, called in order after those
+// already added. With interceptors, incoming messages are reassembled in
+// memory instead of being streamed.
+func (c *Conn) InterceptIncoming(interceptors ...IncomingInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.incomingInterceptors = append(c.incomingInterceptors, interceptors...)
+}
+
+// The incoming interceptors, nil if there are none
+func (c *Conn) incoming() []IncomingInterceptor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.incomingInterceptors
+}
+
+// Add a data frame to the message being reassembled, and deliver the
+// message through the interceptors after the last frame
+func (c *Conn) reassemble(f *frame) (err error) {
+	if c.reassembled != nil && f.Op() != opCodeContinuation {
+		return newErrConnection(statusProtocolError, "Received unexpected data frame (expecting continuation frame)")
+	}
+	if c.reassembled == nil {
+		c.reassembled = &Message{Type: int(f.Op()), Reader: new(bytes.Buffer)}
+	}
+	if _, err = f.readPayloadTo(c.reassembled.Reader.(*bytes.Buffer)); err != nil || !f.header.Fin {
+		return
+	}
+	m := c.reassembled
+	c.reassembled = nil
+	for _, intercept := range c.incoming() {
+		if err = intercept(c, m); err != nil {
+			Log.Println(err)
+			if e, ok := err.(*RejectError); ok {
+				c.sendClose(e.status())
+			}
+			return nil
+		}
+	}
+	select {
+	case c.in <- c.inFlightMessage(m):
+	case <-c.inDone:
+	}
+	return
+}
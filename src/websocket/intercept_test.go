@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+var errVetoed = errors.New("Vetoed")
+
+// Uppercase text messages, and veto "secret"
+func upperInterceptor(c *Conn, m *Message) error {
+	payload, _ := ioutil.ReadAll(m.Reader)
+	if string(payload) == "secret" {
+		return errVetoed
+	}
+	m.Reader = bytes.NewReader(bytes.ToUpper(payload))
+	return nil
+}
+
+func TestInterceptOutgoing(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.InterceptOutgoing(upperInterceptor)
+	c.SendText("hi")
+	sent := make(chan error, 1)
+	c.Out <- &Message{Type: TextMessage, Reader: bytes.NewBufferString("secret"), Sent: func(err error) { sent <- err }}
+	c.Out <- bytes.NewBufferString("yo")
+	expectFrames(t, client, []byte{0x81, 0x02, 'H', 'I', 0x81, 0x02, 'Y', 'O'})
+	if err := <-sent; err != errVetoed {
+		t.Errorf("Expected the veto error, got %v", err)
+	}
+}
+
+func TestHandlerOutgoingInterceptors(t *testing.T) {
+	h := NewHandler()
+	var types []int
+	h.OutgoingInterceptors = []OutgoingInterceptor{
+		func(c *Conn, m *Message) error {
+			types = append(types, m.Type)
+			return nil
+		},
+		upperInterceptor,
+	}
+	client, resp := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal(resp.Status)
+	}
+	c := <-h.Conns
+	c.SendBinary([]byte("ok"))
+	expectFrames(t, client, []byte{0x82, 0x02, 'O', 'K'})
+	if len(types) != 1 || types[0] != BinaryMessage {
+		t.Errorf("Unexpected message types observed: %v", types)
+	}
+}
+
+func TestInterceptIncoming(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.InterceptIncoming(upperInterceptor)
+	go client.Write([]byte{
+		0x01, 0x82, 0, 0, 0, 0, 'h', 'e', // Fragmented
+		0x80, 0x81, 0, 0, 0, 0, 'y',
+		0x81, 0x86, 0, 0, 0, 0, 's', 'e', 'c', 'r', 'e', 't', // Rejected
+		0x81, 0x82, 0, 0, 0, 0, 'y', 'o',
+	})
+	for _, expected := range []string{"HEY", "YO"} {
+		if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != expected {
+			t.Errorf("Expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+func TestInterceptIncomingClose(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.InterceptIncoming(func(c *Conn, m *Message) error {
+		return &RejectError{Code: statusPolicyViolation, Reason: "No"}
+	})
+	go client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, 'x'})
+	expectFrames(t, client, []byte{0x88, 0x04, 0x03, 0xf0, 'N', 'o'})
+	go client.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xf0})
+	if _, ok := <-c.In; ok {
+		t.Error("Rejected message delivered")
+	}
+}
+
+func TestInterceptIncomingInvalidCode(t *testing.T) {
+	for _, code := range []uint16{CloseNoStatusReceived, CloseAbnormalClosure, CloseTLSHandshake, 1004, 2000, 999} {
+		c, client := newPipeConn()
+		c.InterceptIncoming(func(c *Conn, m *Message) error {
+			return &RejectError{Code: code, Reason: "No"}
+		})
+		go client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, 'x'})
+		if payload := readShortFrame(t, client); payload[:2] != "\x03\xf3" {
+			t.Errorf("%v: expected close with 1011, got %q", code, payload)
+		}
+		client.Close()
+	}
+}
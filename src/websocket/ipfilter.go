@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+var errIPDenied = classify(ErrHandshake, "Client address not allowed")
+
+// Check the client address of r against the allowlist, the denylist and
+// the lookup hook of the handler, in that order
+func (h *Handler) checkIP(r *http.Request) (err error) {
+	if len(h.AllowedNetworks) == 0 && len(h.DeniedNetworks) == 0 && h.CheckIP == nil {
+		return
+	}
+	ip := h.clientIP(r)
+	if len(h.AllowedNetworks) != 0 && !containsIP(h.AllowedNetworks, ip) {
+		return errIPDenied
+	}
+	if containsIP(h.DeniedNetworks, ip) {
+		return errIPDenied
+	}
+	if h.CheckIP != nil {
+		err = wrapError(ErrHandshake, h.CheckIP(ip))
+	}
+	return
+}
+
+// True if ip is within one of the networks
+func containsIP(networks []netip.Prefix, ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range networks {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refuse r with 403 if its client address isn't allowed. Returns true if it
+// may go on.
+func (h *Handler) filterIP(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.checkIP(r); err != nil {
+		h.refuse(w, r, err, http.StatusForbidden)
+		return false
+	}
+	return true
+}
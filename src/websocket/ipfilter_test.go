@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckIP(t *testing.T) {
+	banned := errors.New("Banned")
+	h := &Handler{
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::/32")},
+		DeniedNetworks:  []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")},
+		CheckIP: func(ip netip.Addr) error {
+			if ip == netip.MustParseAddr("192.0.2.7") {
+				return banned
+			}
+			return nil
+		},
+	}
+	tests := []struct {
+		remoteAddr string
+		expected   error
+	}{
+		{"192.0.2.1:1234", nil},
+		{"[2001:db8::1]:1234", nil},
+		{"[::ffff:192.0.2.1]:1234", nil},
+		{"198.51.100.7:1234", errIPDenied},
+		{"192.0.2.200:1234", errIPDenied},
+		{"192.0.2.7:1234", banned},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		err := h.checkIP(r)
+		if !errors.Is(err, test.expected) && err != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.remoteAddr, test.expected, err)
+		}
+		if err != nil && !errors.Is(err, ErrHandshake) {
+			t.Errorf("%v: %v is not a handshake error", test.remoteAddr, err)
+		}
+	}
+}
+
+func TestIPDeniedHandshake(t *testing.T) {
+	h := NewHandler()
+	h.DeniedNetworks = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	if _, resp := handshake(t, h, newHandshakeRequest()); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a denied address, got %v", resp.Status)
+	}
+	h.DeniedNetworks = nil
+	h.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	client, resp := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Allowed address refused: %v", resp.Status)
+	}
+}
@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"iter"
+)
+
+// Iterate over the incoming messages until the connection is closed:
+//
+//	for msg, err := range c.Messages() {
+//		...
+//	}
+//
+// If the connection failed, the last iteration has an empty message and the
+// error. Whatever is left unread of a message when the loop body returns is
+// discarded. Takes over all messages on the connection.
+func (c *Conn) Messages() iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for r := range c.In {
+			m := r.(*Message)
+			ok := yield(*m, nil)
+			io.Copy(ioutil.Discard, m)
+			if !ok {
+				return
+			}
+		}
+		if err := c.Err(); err != nil {
+			yield(Message{}, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestMessagesIterator(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.Out <- bytes.NewBufferString(fmt.Sprint(i))
+	}
+	i := 0
+	for msg, err := range c.Messages() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := ioutil.ReadAll(msg)
+		if msg.Type != TextMessage || string(payload) != fmt.Sprint(i) {
+			t.Errorf("Unexpected message %v: type %v, %q", i, msg.Type, payload)
+		}
+		if i++; i == 3 {
+			c.Close()
+		}
+	}
+	if i != 3 {
+		t.Errorf("Expected 3 messages, got %v", i)
+	}
+}
+
+func TestMessagesIteratorError(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.conn.Close()
+	for _, err = range c.Messages() {
+	}
+	if err == nil {
+		t.Error("Expected an error from the iterator")
+	}
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"websocket/wsframe"
+)
+
+// Just over 4 GiB, which doesn't fit in 32 bits
+const largeMessageSize = 1<<32 + 1
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteSizedLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Streams more than 4 GiB")
+	}
+	c, client := newPipeConn()
+	defer client.Close()
+	written := make(chan error)
+	go func() {
+		written <- c.WriteSized(BinaryMessage, io.LimitReader(zeroReader{}, largeMessageSize), largeMessageSize)
+	}()
+	h, payload, err := wsframe.Decode(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Fin || h.PayloadLength != largeMessageSize {
+		t.Errorf("Unexpected frame header: %v", h)
+	}
+	if n, _ := io.Copy(ioutil.Discard, payload); n != largeMessageSize {
+		t.Errorf("Expected %v bytes, got %v", int64(largeMessageSize), n)
+	}
+	if err = <-written; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceiveLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Streams more than 4 GiB")
+	}
+	c, client := newPipeConn()
+	defer client.Close()
+	go func() {
+		h, _ := wsframe.NewHeader(true, wsframe.OpBinary, largeMessageSize, []byte{1, 2, 3, 4})
+		wsframe.Encode(client, h, zeroReader{})
+	}()
+	m := (<-c.In).(*Message)
+	if n, err := io.Copy(ioutil.Discard, m); err != nil || n != largeMessageSize {
+		t.Errorf("Expected %v bytes, got %v (%v)", int64(largeMessageSize), n, err)
+	}
+}
+
+func TestWriteSizedShortReader(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+	if err := c.WriteSized(BinaryMessage, io.LimitReader(zeroReader{}, 10), 20); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if _, ok := c.Wait().(*CloseError); !ok {
+		t.Errorf("Expected the connection to fail, got %v", c.Err())
+	}
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"time"
+)
+
+// Limit the size of incoming messages to n bytes, zero for no limit, the
+// default. A message is refused from the header of the frame which would
+// exceed the limit, before any of its payload is read, and the connection
+// is closed with 1009 (message too big).
+func (c *Conn) SetReadLimit(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readLimit = n
+}
+
+// The limit set with SetReadLimit
+func (c *Conn) ReadLimit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readLimit
+}
+
+// Check the length announced by the header of a data frame against the
+// read limit, counting the frames of the message before it
+func (c *Conn) checkReadLimit(f *frame) (err error) {
+	if f.Op() != opCodeContinuation {
+		c.messageLength = 0
+	}
+	if limit := c.messageLimit(); limit > 0 && f.Len() > limit-c.messageLength {
+		return c.failWith(newErrConnection(statusMessageTooBig, "Message too big"))
+	}
+	c.messageLength += f.Len()
+	return
+}
+
+// Fail the connection because of e, after sending a close frame with its
+// status. Returns e, for the router to stop with.
+func (c *Conn) failWith(e *errConnection) error {
+	if c.sendClose(e) {
+		timer := time.NewTimer(closeTimeout)
+		defer timer.Stop()
+		select {
+		case <-c.sendDone:
+		case <-timer.C:
+		}
+	}
+	return e
+}
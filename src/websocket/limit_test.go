@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadLimit(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetReadLimit(4)
+	client.Write([]byte{0x81, 0x84, 0, 0, 0, 0, 'f', 'o', 'u', 'r'})
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "four" {
+		t.Errorf("Expected %q, got %q", "four", msg)
+	}
+	// Only the header, the payload is never read
+	client.Write([]byte{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if payload := readShortFrame(t, client); payload != "\x03\xf1Message too big" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	for range c.In {
+	}
+	if e, ok := c.Err().(*errConnection); !ok || e.code != statusMessageTooBig {
+		t.Errorf("Expected message too big, got %v", c.Err())
+	}
+}
+
+func TestReadLimitFragmented(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetReadLimit(4)
+	client.Write([]byte{0x01, 0x83, 0, 0, 0, 0, 'a', 'b', 'c'})
+	m := <-c.In
+	msg := make([]byte, 3)
+	io.ReadFull(m, msg)
+	go client.Write([]byte{0x80, 0x82, 0, 0, 0, 0, 'd', 'e'})
+	if payload := readShortFrame(t, client); payload != "\x03\xf1Message too big" {
+		t.Errorf("Unexpected close frame payload %q", payload)
+	}
+	if rest, err := ioutil.ReadAll(m); string(msg) != "abc" || len(rest) != 0 || err == nil {
+		t.Errorf("Expected the message to be cut after %q, got %q, %v", msg, rest, err)
+	}
+}
@@ -0,0 +1,28 @@
+package websocket
+
+import (
+	"net/http"
+)
+
+// Middleware for routers such as http.ServeMux, chi or gorilla/mux, so that
+// websocket and plain HTTP endpoints can share a route tree. Requests
+// asking for an upgrade to websocket are upgraded with the options of h,
+// and handle is called with the started connection, on the goroutine
+// serving the request. All other requests are passed on to next. The
+// connection is handle's to close, neither Conns nor Events is used.
+func (h *Handler) Middleware(handle func(c *Conn, r *http.Request)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			c := h.upgrade(w, r)
+			if c == nil {
+				return
+			}
+			c.start()
+			handle(c, r)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	rest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "rest")
+	})
+	paths := make(chan string, 1)
+	mw := NewHandler().Middleware(func(c *Conn, r *http.Request) {
+		paths <- r.URL.Path
+		c.Close()
+	})(rest)
+
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+	if w.Body.String() != "rest" {
+		t.Errorf("Plain request not passed on, got %q", w.Body.String())
+	}
+
+	client, resp := handshake(t, mw, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v", resp.StatusCode)
+	}
+	if path := <-paths; path != "/myconn" {
+		t.Errorf("Unexpected path %q", path)
+	}
+}
@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Capacity of the ring buffer of an incoming message stream
+const messageStreamSize = 0x1000
+
+// The payload of incoming messages on its way from the router to the
+// application, through a bounded ring buffer. Unlike io.Pipe, writes
+// return once the payload is buffered rather than once it has been read,
+// and the stream of a connection is reused for the next message once the
+// previous one has been read to the end.
+type messageStream struct {
+	mu                 sync.Mutex
+	readable, writable sync.Cond
+	buf                []byte
+	start, n           int                  // The unread data within buf
+	cur                *streamHandle        // Of the current message
+	recycled           chan<- *streamHandle // Of the connection, see Message.Recycle
+	closed             bool                 // No more writes, err is returned once the data is read
+	err                error
+	timeout            time.Duration // See Conn.SetAbandonTimeout
+	pending            time.Time     // Since when unread data waits for the reader
+	timer              *time.Timer   // Abandons the message, made once
+}
+
+// A message on a stream, both its reader and writer, allocated together
+// with the Message delivered. It goes stale once the stream is reused, and
+// then only returns the error it ended with.
+type streamHandle struct {
+	msg   Message
+	s     *messageStream
+	stale bool
+	err   error // Once stale
+}
+
+func newMessageStream(recycled chan<- *streamHandle) (s *messageStream) {
+	s = &messageStream{buf: make([]byte, messageStreamSize), recycled: recycled}
+	s.readable.L = &s.mu
+	s.writable.L = &s.mu
+	return
+}
+
+// Start streaming the payload of an incoming message: on a recycled
+// message and its stream if there is one, or on the stream of the previous
+// message if that has been read to the end, or on a new stream
+func (c *Conn) newMessageStream() *streamHandle {
+	select {
+	case h := <-c.recycled:
+		c.stream = h.s
+		return h.s.begin(c.AbandonTimeout(), h)
+	default:
+	}
+	if c.stream == nil || !c.stream.reusable() {
+		c.stream = newMessageStream(c.recycled)
+	}
+	return c.stream.begin(c.AbandonTimeout(), nil)
+}
+
+// True once the current message is written and read to the end
+func (s *messageStream) reusable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed && s.n == 0
+}
+
+// Reset the stream for the next message, on h if not nil
+func (s *messageStream) begin(timeout time.Duration, h *streamHandle) *streamHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur != nil && s.cur != h {
+		s.cur.stale, s.cur.err = true, s.err
+	}
+	s.start, s.n = 0, 0
+	s.closed, s.err = false, nil
+	s.timeout = timeout
+	if h == nil {
+		h = new(streamHandle)
+	}
+	*h = streamHandle{s: s}
+	s.cur = h
+	return h
+}
+
+// The message of msgType read through h
+func (h *streamHandle) message(msgType int) *Message {
+	h.msg = Message{Type: msgType, Reader: h}
+	return &h.msg
+}
+
+// Release m, and hand it back to the connection to be reused for a later
+// incoming message, which saves allocating one per message. Neither m nor
+// a copy of it may be used afterwards. A message which can't be reused,
+// such as one delivered otherwise than streamed, is only released. With
+// the default delivery and no in flight limit, a message read into a
+// buffer of the caller and then recycled is received without allocating.
+func (m *Message) Recycle() (err error) {
+	err = m.Release()
+	if h, ok := m.Reader.(*streamHandle); ok && m == &h.msg {
+		select {
+		case h.s.recycled <- h:
+		default: // Enough are waiting to be reused
+		}
+	}
+	return
+}
+
+func (h *streamHandle) Read(p []byte) (n int, err error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !h.stale && s.n == 0 && !s.closed {
+		s.readable.Wait()
+	}
+	if h.stale {
+		return 0, h.err
+	}
+	if s.n == 0 {
+		return 0, s.err
+	}
+	for n < len(p) && s.n > 0 {
+		end := s.start + s.n
+		if end > len(s.buf) {
+			end = len(s.buf)
+		}
+		m := copy(p[n:], s.buf[s.start:end])
+		s.start = (s.start + m) % len(s.buf)
+		s.n -= m
+		n += m
+	}
+	s.pending = time.Now()
+	s.writable.Signal()
+	return
+}
+
+// Buffer p, waiting while the buffer is full. A message which is closed,
+// such as when abandoned, discards what is written.
+func (h *streamHandle) Write(p []byte) (n int, err error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n = len(p)
+	for len(p) > 0 {
+		for !h.stale && !s.closed && s.n == len(s.buf) {
+			s.writable.Wait()
+		}
+		if h.stale || s.closed {
+			return
+		}
+		start := (s.start + s.n) % len(s.buf)
+		end := len(s.buf)
+		if start < s.start {
+			end = s.start
+		}
+		m := copy(s.buf[start:end], p)
+		if s.n == 0 {
+			s.pending = time.Now()
+			s.startTimer()
+		}
+		s.n += m
+		p = p[m:]
+		s.readable.Signal()
+	}
+	return
+}
+
+// Buffer what is read from r until it ends, like Write, reading straight
+// into the buffer
+func (h *streamHandle) ReadFrom(r io.Reader) (n int64, err error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for !h.stale && !s.closed && s.n == len(s.buf) {
+			s.writable.Wait()
+		}
+		if h.stale || s.closed {
+			s.mu.Unlock()
+			m, err := io.Copy(ioutil.Discard, r)
+			s.mu.Lock()
+			return n + m, err
+		}
+		// Only the writer fills the free space, so it stays free while
+		// reading into it without the lock
+		start := (s.start + s.n) % len(s.buf)
+		end := len(s.buf)
+		if start < s.start {
+			end = s.start
+		}
+		s.mu.Unlock()
+		m, e := r.Read(s.buf[start:end])
+		s.mu.Lock()
+		n += int64(m)
+		if m > 0 && !h.stale && !s.closed {
+			if s.n == 0 {
+				s.pending = time.Now()
+				s.startTimer()
+			}
+			s.n += m
+			s.readable.Signal()
+		}
+		if e == io.EOF {
+			return n, nil
+		} else if e != nil {
+			return n, e
+		}
+	}
+}
+
+// End the message, reads return err once the data is read
+func (h *streamHandle) CloseWithError(err error) error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !h.stale && !s.closed {
+		s.closed, s.err = true, err
+		s.readable.Broadcast()
+		s.writable.Broadcast()
+	}
+	return nil
+}
+
+func (h *streamHandle) Close() error {
+	return h.CloseWithError(io.EOF)
+}
+
+// Abandon the message if its data isn't read within the timeout. Called
+// with mu held.
+func (s *messageStream) startTimer() {
+	if s.timeout <= 0 {
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.timeout, s.expire)
+	} else {
+		s.timer.Reset(s.timeout)
+	}
+}
+
+// Discard the message if its data has waited for the reader for the
+// timeout, so that reading it fails with errMessageAbandoned
+func (s *messageStream) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 || s.timeout <= 0 {
+		return
+	}
+	if waited := time.Since(s.pending); waited < s.timeout {
+		s.timer.Reset(s.timeout - waited)
+		return
+	}
+	s.n = 0
+	s.closed, s.err = true, errMessageAbandoned
+	s.readable.Broadcast()
+	s.writable.Broadcast()
+}
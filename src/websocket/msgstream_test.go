@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMessageStream(t *testing.T) {
+	s := newMessageStream(nil)
+	first := s.begin(0, nil)
+	payload := bytes.Repeat([]byte("0123456789"), messageStreamSize/5) // Wraps around
+	go func() {
+		first.Write(payload)
+		first.Close()
+	}()
+	if data, err := ioutil.ReadAll(first); err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("Unexpected read of %v bytes (%v)", len(data), err)
+	}
+	if !s.reusable() {
+		t.Fatal("Stream not reusable once read to the end")
+	}
+	second := s.begin(0, nil)
+	second.Write([]byte("next"))
+	second.CloseWithError(io.ErrUnexpectedEOF)
+	if n, err := first.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+		t.Errorf("Stale message read %v bytes (%v)", n, err)
+	}
+	if s.reusable() {
+		t.Error("Stream reusable with unread data")
+	}
+	if data, err := ioutil.ReadAll(second); string(data) != "next" || err != io.ErrUnexpectedEOF {
+		t.Errorf("Unexpected read %q (%v)", data, err)
+	}
+}
+
+func TestMessageStreamReused(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	var streams []*messageStream
+	for _, expected := range []string{"Hi", "Ho"} {
+		go client.Write(append([]byte{0x81, 0x82, 0, 0, 0, 0}, expected...))
+		m := (<-c.In).(*Message)
+		if data, _ := ioutil.ReadAll(m); string(data) != expected {
+			t.Errorf("Expected %q, got %q", expected, data)
+		}
+		streams = append(streams, m.Reader.(*streamHandle).s)
+	}
+	if streams[0] != streams[1] {
+		t.Error("Stream not reused for the next message")
+	}
+}
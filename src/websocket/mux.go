@@ -0,0 +1,294 @@
+package websocket
+
+// Multiplexing of logical streams over one websocket connection.
+//
+// Every mux frame is sent as one binary message:
+//
+//	+------+-----------+---------------------+
+//	| type | stream id | data                |
+//	| 1 B  | 4 B (BE)  | depends on the type |
+//	+------+-----------+---------------------+
+//
+// Open has no data and opens the stream. Data carries stream payload, at
+// most the receive window the peer has announced. Close has no data and
+// means that the sender won't write anything more to the stream. Window
+// carries a 4 byte big endian increment of the receive window of the sender.
+// Streams opened by the client side have odd ids, by the server side even.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Mux frame types
+const (
+	muxOpen = byte(iota)
+	muxData
+	muxClose
+	muxWindow
+)
+
+const (
+	muxHeaderLength   = 5
+	muxInitialWindow  = 0x40000 // Initial receive window of every stream
+	muxMaxFrameLength = 0x4000  // Maximum data in a mux frame
+)
+
+var (
+	errMuxClosed         = classify(ErrClosed, "Mux is closed")
+	errStreamClosed      = classify(ErrClosed, "Stream is closed for writing")
+	errMalformedMuxFrame = classify(ErrProtocol, "Malformed mux frame")
+)
+
+// Multiple independent, flow controlled streams over one connection.
+// The mux takes over all messages on the connection.
+type Mux struct {
+	c       *Conn
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	accept  []*Stream  // Streams opened by the peer, not yet accepted
+	cond    *sync.Cond // Signaled when accept changes or the mux closes
+	closed  bool
+}
+
+// A logical stream of a Mux, implements io.ReadWriteCloser
+type Stream struct {
+	m            *Mux
+	id           uint32
+	cond         *sync.Cond   // Uses m.mu, signaled when the stream changes
+	buf          bytes.Buffer // Received, unread data
+	sendWindow   int          // Bytes the peer is ready to receive
+	consumed     int          // Bytes read but not yet announced as window
+	localClosed  bool
+	remoteClosed bool
+}
+
+// Start multiplexing streams over c. Both end-points must use a mux.
+func NewMux(c *Conn) (m *Mux) {
+	m = &Mux{
+		c:       c,
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+	}
+	if c.server {
+		m.nextID = 2
+	}
+	m.cond = sync.NewCond(&m.mu)
+	go m.readLoop()
+	return
+}
+
+// Open a new stream to the peer
+func (m *Mux) Open() (s *Stream, err error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		err = errMuxClosed
+		return
+	}
+	s = m.newStream(m.nextID)
+	m.nextID += 2
+	m.mu.Unlock()
+	err = m.send(muxOpen, s.id, nil)
+	return
+}
+
+// Wait for the next stream opened by the peer
+func (m *Mux) Accept() (s *Stream, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.accept) == 0 && !m.closed {
+		m.cond.Wait()
+	}
+	if len(m.accept) == 0 {
+		err = errMuxClosed
+		return
+	}
+	s, m.accept = m.accept[0], m.accept[1:]
+	return
+}
+
+// Close the mux and the underlying connection
+func (m *Mux) Close() error {
+	m.c.Close()
+	m.shutdown()
+	return nil
+}
+
+// Must be called with m.mu held
+func (m *Mux) newStream(id uint32) (s *Stream) {
+	s = &Stream{
+		m:          m,
+		id:         id,
+		sendWindow: muxInitialWindow,
+	}
+	s.cond = sync.NewCond(&m.mu)
+	m.streams[id] = s
+	return
+}
+
+// Send a mux frame as a binary message
+func (m *Mux) send(typ byte, id uint32, data []byte) (err error) {
+	buf := make([]byte, muxHeaderLength, muxHeaderLength+len(data))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:], id)
+	buf = append(buf, data...)
+	if m.c.State() != OPEN || m.c.enqueue(&Message{Type: BinaryMessage, Reader: bytes.NewReader(buf)}) != nil {
+		err = errMuxClosed
+	}
+	return
+}
+
+// Dispatch incoming mux frames to their streams until the connection closes
+func (m *Mux) readLoop() {
+	for r := range m.c.In {
+		frame, err := ioutil.ReadAll(r)
+		if err == nil {
+			err = m.dispatch(frame)
+		}
+		if err != nil {
+			Log.Println(err)
+			m.c.Close()
+			break
+		}
+	}
+	m.shutdown()
+}
+
+func (m *Mux) dispatch(frame []byte) (err error) {
+	if len(frame) < muxHeaderLength {
+		return errMalformedMuxFrame
+	}
+	typ, id, data := frame[0], binary.BigEndian.Uint32(frame[1:]), frame[muxHeaderLength:]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.streams[id]
+	switch {
+	case typ == muxOpen && s == nil:
+		m.accept = append(m.accept, m.newStream(id))
+		m.cond.Broadcast()
+	case s == nil:
+		// Frames for forgotten streams are ignored
+	case typ == muxData:
+		if s.buf.Len()+len(data) > muxInitialWindow {
+			return errMalformedMuxFrame // The peer ignored the window
+		}
+		s.buf.Write(data)
+	case typ == muxClose:
+		s.remoteClosed = true
+		m.forget(s)
+	case typ == muxWindow && len(data) == 4:
+		s.sendWindow += int(binary.BigEndian.Uint32(data))
+	default:
+		return errMalformedMuxFrame
+	}
+	if s != nil {
+		s.cond.Broadcast()
+	}
+	return
+}
+
+// Remove the stream once both sides are done with it.
+// Must be called with m.mu held.
+func (m *Mux) forget(s *Stream) {
+	if s.localClosed && s.remoteClosed {
+		delete(m.streams, s.id)
+	}
+}
+
+// Mark the mux as closed and wake up everyone waiting for it
+func (m *Mux) shutdown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.cond.Broadcast()
+	for _, s := range m.streams {
+		s.cond.Broadcast()
+	}
+}
+
+// Read data from the stream. Returns io.EOF once the peer has closed the
+// stream and all data is read.
+func (s *Stream) Read(p []byte) (n int, err error) {
+	m := s.m
+	m.mu.Lock()
+	for s.buf.Len() == 0 && !s.remoteClosed && !m.closed {
+		s.cond.Wait()
+	}
+	if s.buf.Len() == 0 {
+		m.mu.Unlock()
+		if s.remoteClosed {
+			err = io.EOF
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	n, _ = s.buf.Read(p)
+	s.consumed += n
+	var increment int
+	if s.consumed >= muxInitialWindow/2 && !s.remoteClosed {
+		increment, s.consumed = s.consumed, 0
+	}
+	m.mu.Unlock()
+	if increment > 0 {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(increment))
+		m.send(muxWindow, s.id, data)
+	}
+	return
+}
+
+// Write data to the stream, blocking while the peer's receive window is full
+func (s *Stream) Write(p []byte) (n int, err error) {
+	m := s.m
+	for len(p) > 0 {
+		m.mu.Lock()
+		for s.sendWindow == 0 && !s.localClosed && !m.closed {
+			s.cond.Wait()
+		}
+		if s.localClosed {
+			err = errStreamClosed
+		} else if m.closed {
+			err = errMuxClosed
+		}
+		if err != nil {
+			m.mu.Unlock()
+			return
+		}
+		chunk := len(p)
+		if chunk > s.sendWindow {
+			chunk = s.sendWindow
+		}
+		if chunk > muxMaxFrameLength {
+			chunk = muxMaxFrameLength
+		}
+		s.sendWindow -= chunk
+		m.mu.Unlock()
+		if err = m.send(muxData, s.id, p[:chunk]); err != nil {
+			return
+		}
+		n += chunk
+		p = p[chunk:]
+	}
+	return
+}
+
+// Close the stream for writing. The peer's data can still be read.
+func (s *Stream) Close() (err error) {
+	m := s.m
+	m.mu.Lock()
+	if s.localClosed {
+		m.mu.Unlock()
+		return
+	}
+	s.localClosed = true
+	m.forget(s)
+	s.cond.Broadcast()
+	m.mu.Unlock()
+	return m.send(muxClose, s.id, nil)
+}
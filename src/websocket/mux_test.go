@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+// Start a server where every accepted stream is echoed back
+func setupMuxEchoServer(t *testing.T) (server *httptest.Server) {
+	h := NewHandler()
+	server = httptest.NewServer(h)
+	go func() {
+		for c := range h.Conns {
+			m := NewMux(c)
+			go func() {
+				for {
+					s, err := m.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						io.Copy(s, s)
+						s.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func TestMuxEcho(t *testing.T) {
+	server := setupMuxEchoServer(t)
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(c)
+	defer m.Close()
+
+	// Larger than the window, so that flow control is exercised
+	payloads := [][]byte{
+		bytes.Repeat([]byte("a"), 3*muxInitialWindow+17),
+		bytes.Repeat([]byte("b"), 5),
+		bytes.Repeat([]byte("c"), muxMaxFrameLength*3),
+	}
+	done := make(chan error)
+	for _, p := range payloads {
+		go func(p []byte) {
+			s, err := m.Open()
+			if err != nil {
+				done <- err
+				return
+			}
+			go func() {
+				s.Write(p)
+				s.Close()
+			}()
+			echo, err := ioutil.ReadAll(s)
+			if err == nil && !bytes.Equal(echo, p) {
+				t.Errorf("Stream %v echo mismatch, %v bytes", s.id, len(echo))
+			}
+			done <- err
+		}(p)
+	}
+	for range payloads {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMuxClosedAccept(t *testing.T) {
+	server := setupMuxEchoServer(t)
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux(c)
+	m.Close()
+	if _, err = m.Accept(); err != errMuxClosed {
+		t.Errorf("Expected errMuxClosed, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// An extension accepted in the opening handshake, with its parameters.
+// Parameters without a value, such as "client_no_context_takeover", map to
+// the empty string.
+type Extension struct {
+	Name   string
+	Params map[string]string
+}
+
+// The subprotocol selected by the server, or empty if none was
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// The extensions accepted by the server, in the order of the handshake
+// response
+func (c *Conn) Extensions() []Extension {
+	return c.extensions
+}
+
+// True if c is the server end-point of the connection
+func (c *Conn) IsServer() bool {
+	return c.server
+}
+
+// The websocket protocol version of the connection, 0 for a legacy
+// hixie-76 connection, see Handler.Hixie76
+func (c *Conn) Version() int {
+	return c.version
+}
+
+// Record the parameters agreed on in the handshake response header
+func (c *Conn) setNegotiated(header http.Header, version int) {
+	c.subprotocol = strings.TrimSpace(header.Get("Sec-WebSocket-Protocol"))
+	c.extensions = parseExtensions(header)
+	c.version = version
+}
+
+// Parse the Sec-WebSocket-Extensions header, such as
+// "permessage-deflate; client_max_window_bits=15, x-foo"
+func parseExtensions(header http.Header) (extensions []Extension) {
+	for _, token := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		parts := strings.Split(token, ";")
+		e := Extension{Name: strings.TrimSpace(parts[0]), Params: make(map[string]string)}
+		if e.Name == "" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			if name = strings.TrimSpace(name); name != "" {
+				e.Params[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+		extensions = append(extensions, e)
+	}
+	return
+}
@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Size of the chunks of incoming payload passed from the reading goroutine
+// of a netConn to Read
+const netConnChunkSize = 0x8000
+
+// A byte stream on top of the messages of a websocket connection
+type netConn struct {
+	c       *Conn
+	msgType int
+	rmu     sync.Mutex // Held during Read
+	wmu     sync.Mutex // Held during Write
+
+	start   sync.Once     // Starts readLoop on the first Read
+	chunks  chan netChunk // Payload read by readLoop, closed with In
+	free    chan []byte   // Buffers taken by Read, back to readLoop
+	pending netChunk      // Rest of the chunk being read, if any
+	buf     []byte        // Buffer of pending, returned once it's read
+	closed  chan bool     // Closed by Close, stops readLoop
+	close   sync.Once
+	read    netConnDeadline // Of Read
+	write   netConnDeadline // Of Write
+}
+
+// Payload of an incoming message, or the error which ended one
+type netChunk struct {
+	b   []byte
+	err error
+}
+
+// Expose the connection as an ordinary byte stream, for tunneling other
+// protocols over websocket. Each Write is sent as one message of msgType,
+// and returns once it's queued, not once it's written. Reads return the
+// payloads of the incoming messages one after another, regardless of
+// their type, and io.EOF once the connection is closed. Deadlines and Close
+// interrupt Read and Write calls already waiting, as for any net.Conn, and
+// calls after Close fail with net.ErrClosed.
+func (c *Conn) AsNetConn(msgType int) net.Conn {
+	return &netConn{c: c, msgType: msgType, closed: make(chan bool)}
+}
+
+func (nc *netConn) Read(p []byte) (n int, err error) {
+	nc.rmu.Lock()
+	defer nc.rmu.Unlock()
+	nc.start.Do(func() {
+		nc.chunks = make(chan netChunk)
+		nc.free = make(chan []byte, 1)
+		nc.free <- make([]byte, netConnChunkSize)
+		go nc.readLoop()
+	})
+	expired := nc.read.wait()
+	select {
+	case <-nc.closed:
+		return 0, net.ErrClosed
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	if len(nc.pending.b) == 0 && nc.pending.err == nil {
+		var ok bool
+		select {
+		case nc.pending, ok = <-nc.chunks:
+			if !ok {
+				return 0, io.EOF
+			}
+			nc.buf = nc.pending.b[:cap(nc.pending.b)]
+		case <-nc.closed:
+			return 0, net.ErrClosed
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n = copy(p, nc.pending.b)
+	nc.pending.b = nc.pending.b[n:]
+	if len(nc.pending.b) == 0 {
+		err, nc.pending.err = nc.pending.err, nil
+		if nc.buf != nil {
+			nc.free <- nc.buf
+			nc.buf = nil
+		}
+	}
+	return
+}
+
+// Read the incoming messages into chunks for Read, until In is closed. A
+// Read waiting for a chunk can thus be interrupted, even in the middle of
+// a message whose payload is slow to arrive.
+func (nc *netConn) readLoop() {
+	defer close(nc.chunks)
+	for m := range nc.c.In {
+		for {
+			buf := <-nc.free
+			n, err := m.Read(buf)
+			if err == io.EOF {
+				err = nil
+				if n == 0 {
+					nc.free <- buf
+					break // On to the next message
+				}
+			}
+			if n == 0 && err == nil {
+				nc.free <- buf
+				continue
+			}
+			select {
+			case nc.chunks <- netChunk{buf[:n], err}:
+			case <-nc.closed:
+				return // Nobody reads anymore
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+func (nc *netConn) Write(p []byte) (n int, err error) {
+	nc.wmu.Lock()
+	defer nc.wmu.Unlock()
+	select {
+	case <-nc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if nc.c.State() != OPEN {
+		err = errConnClosed
+		return
+	}
+	expired := nc.write.wait()
+	select {
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	m := &Message{Type: nc.msgType, Reader: bytes.NewBuffer(append([]byte(nil), p...))}
+	select {
+	case nc.c.Out <- m:
+		n = len(p)
+	case <-nc.c.done:
+		err = errConnClosed
+	case <-nc.closed:
+		err = net.ErrClosed
+	case <-expired:
+		err = os.ErrDeadlineExceeded
+	}
+	return
+}
+
+// A timer firing at the deadline, or never if the deadline is zero
+func deadlineTimer(deadline time.Time) (timer *time.Timer) {
+	if deadline.IsZero() {
+		timer = time.NewTimer(time.Duration(1<<63 - 1))
+	} else {
+		timer = time.NewTimer(time.Until(deadline))
+	}
+	return
+}
+
+// A deadline of a netConn, which wakes the calls waiting for it when it
+// passes, also if it's moved while they wait
+type netConnDeadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan bool // Closed once the deadline has passed
+}
+
+// Move the deadline to t, none if zero
+func (d *netConnDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.channel() // Being closed by the timer
+	}
+	d.timer = nil
+	closed := false
+	select {
+	case <-d.channel():
+		closed = true
+	default:
+	}
+	if t.IsZero() || time.Until(t) > 0 {
+		if closed {
+			d.expired = make(chan bool)
+		}
+		if !t.IsZero() {
+			expired := d.expired
+			d.timer = time.AfterFunc(time.Until(t), func() { close(expired) })
+		}
+		return
+	}
+	if !closed {
+		close(d.expired)
+	}
+}
+
+// A channel closed once the current deadline has passed
+func (d *netConnDeadline) wait() <-chan bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.channel()
+}
+
+// The expired channel, created on first use. Must be called with d.mu held.
+func (d *netConnDeadline) channel() chan bool {
+	if d.expired == nil {
+		d.expired = make(chan bool)
+	}
+	return d.expired
+}
+
+func (nc *netConn) Close() error {
+	nc.close.Do(func() { close(nc.closed) })
+	nc.c.Close()
+	return nil
+}
+
+func (nc *netConn) LocalAddr() net.Addr {
+	return nc.c.conn.LocalAddr()
+}
+
+func (nc *netConn) RemoteAddr() net.Addr {
+	return nc.c.conn.RemoteAddr()
+}
+
+func (nc *netConn) SetDeadline(t time.Time) error {
+	nc.read.set(t)
+	nc.write.set(t)
+	return nil
+}
+
+func (nc *netConn) SetReadDeadline(t time.Time) error {
+	nc.read.set(t)
+	return nil
+}
+
+func (nc *netConn) SetWriteDeadline(t time.Time) error {
+	nc.write.set(t)
+	return nil
+}
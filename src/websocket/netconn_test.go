@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBinaryMessage(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Out <- &Message{Type: BinaryMessage, Reader: bytes.NewReader([]byte{0, 1, 2})}
+	m := (<-c.In).(*Message)
+	if m.Type != BinaryMessage {
+		t.Errorf("Expected binary message, got type %v", m.Type)
+	}
+}
+
+func TestNetConnStream(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := c.AsNetConn(BinaryMessage)
+	defer nc.Close()
+	for _, s := range []string{"Hello", ", ", "world"} {
+		if _, err = nc.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, len("Hello, world"))
+	if _, err = io.ReadFull(nc, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "Hello, world" {
+		t.Errorf("Stream mismatch: %q", buf)
+	}
+}
+
+func TestNetConnReadDeadline(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := c.AsNetConn(BinaryMessage)
+	defer nc.Close()
+	nc.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err = nc.Read(make([]byte, 1)); err != os.ErrDeadlineExceeded {
+		t.Errorf("Expected os.ErrDeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNetConnDeadlineInterruptsRead(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	nc := c.AsNetConn(BinaryMessage)
+	// A message of 4 bytes, of which only 2 arrive for now
+	go client.Write([]byte{0x82, 0x84, 0, 0, 0, 0, 'a', 'b'})
+	buf := make([]byte, 4)
+	if n, err := nc.Read(buf); err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("Expected ab, got %q (%v)", buf[:n], err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		nc.SetReadDeadline(time.Now())
+	}()
+	if _, err := nc.Read(buf); err != os.ErrDeadlineExceeded {
+		t.Fatalf("Expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	nc.SetReadDeadline(time.Time{})
+	go client.Write([]byte{'c', 'd'})
+	if n, err := nc.Read(buf); err != nil || string(buf[:n]) != "cd" {
+		t.Errorf("Expected cd, got %q (%v)", buf[:n], err)
+	}
+}
+
+func TestNetConnCloseInterruptsRead(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	nc := c.AsNetConn(BinaryMessage)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		nc.Close()
+	}()
+	// The client never completes the closing handshake, so only Close
+	// itself can end the Read before the close timeout
+	start := time.Now()
+	buf := make([]byte, 4)
+	if _, err := nc.Read(buf); err != net.ErrClosed {
+		t.Fatalf("Expected net.ErrClosed, got %v", err)
+	}
+	if d := time.Since(start); d > closeTimeout/2 {
+		t.Errorf("Read returned %v after Close", d)
+	}
+	if _, err := nc.Read(buf); err != net.ErrClosed {
+		t.Errorf("Expected net.ErrClosed from Read after Close, got %v", err)
+	}
+	if _, err := nc.Write(buf); err != net.ErrClosed {
+		t.Errorf("Expected net.ErrClosed from Write after Close, got %v", err)
+	}
+}
+
+func TestNetConnDeadlineInterruptsWrite(t *testing.T) {
+	c := newIdleConn() // Nothing takes from Out
+	nc := c.AsNetConn(BinaryMessage)
+	for i := 0; i < cap(c.out); i++ {
+		nc.Write([]byte("x"))
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		nc.SetWriteDeadline(time.Now().Add(-time.Second))
+	}()
+	if _, err := nc.Write([]byte("x")); err != os.ErrDeadlineExceeded {
+		t.Errorf("Expected os.ErrDeadlineExceeded, got %v", err)
+	}
+}
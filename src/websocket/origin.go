@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errOriginNotAllowed = classify(ErrHandshake, "Origin not allowed")
+	errMissingOrigin    = classify(ErrHandshake, "Missing Origin header")
+)
+
+// Check the Origin header of r against the allowlist of the handler
+func (h *Handler) checkOrigin(r *http.Request) (err error) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Only browsers are required to send it
+		if h.StrictOrigin {
+			err = errMissingOrigin
+		}
+		return
+	}
+	if len(h.AllowedOrigins) == 0 {
+		return
+	}
+	for _, pattern := range h.AllowedOrigins {
+		if originMatches(pattern, origin) {
+			return
+		}
+	}
+	return errOriginNotAllowed
+}
+
+// True if the origin, such as "https://app.example.com", matches the
+// pattern. The scheme and port must be equal, a host pattern "*.example.com"
+// matches the subdomains of example.com at any depth but not example.com.
+func originMatches(pattern, origin string) bool {
+	p, err := url.Parse(strings.ToLower(pattern))
+	if err != nil {
+		return false
+	}
+	o, err := url.Parse(strings.ToLower(origin))
+	if err != nil || o.Scheme != p.Scheme || o.Port() != p.Port() {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(p.Hostname(), "*"); ok {
+		return strings.HasPrefix(suffix, ".") && strings.HasSuffix(o.Hostname(), suffix) && len(o.Hostname()) > len(suffix)
+	}
+	return o.Hostname() == p.Hostname()
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOriginMatches(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		expected        bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://EXAMPLE.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://example.com:8443", "https://example.com:8443", true},
+		{"https://example.com", "https://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://badexample.com", false},
+		{"https://*.example.com", "https://example.com.evil.net", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://example.com", "null", false},
+	}
+	for _, test := range tests {
+		if originMatches(test.pattern, test.origin) != test.expected {
+			t.Errorf("Pattern %q, origin %q: expected %v", test.pattern, test.origin, test.expected)
+		}
+	}
+}
+
+func TestOriginAllowlist(t *testing.T) {
+	h := NewHandler()
+	h.AllowedOrigins = []string{"http://*.example.com"}
+	req := newHandshakeRequest() // From http://localhost
+	if _, resp := handshake(t, h, req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for another origin, got %v", resp.Status)
+	}
+	req = newHandshakeRequest()
+	req.Header.Set("Origin", "http://app.example.com")
+	client, resp := handshake(t, h, req)
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Allowed origin refused: %v", resp.Status)
+	}
+	req = newHandshakeRequest()
+	req.Header.Del("Origin")
+	client, resp = handshake(t, h, req)
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Handshake without origin refused: %v", resp.Status)
+	}
+	h.StrictOrigin = true
+	if _, resp = handshake(t, h, req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 without origin in strict mode, got %v", resp.Status)
+	}
+}
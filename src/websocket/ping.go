@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+var errPingTimeout = classify(ErrTimeout, "Nothing received in time after a ping")
+
+// Pings sent periodically to probe that the other end-point is alive. They
+// are independent of reading, so a connection may be probed often while
+// its application stays silent for long.
+type PingSchedule struct {
+	Interval time.Duration
+
+	// If positive, each interval is varied randomly by up to Jitter either
+	// way, so that the pings of many connections don't align
+	Jitter time.Duration
+
+	// If not nil, called for the payload of every ping, which is cut to 125
+	// bytes. Otherwise the pings are those of Ping, which time the round
+	// trip for Stats.
+	Payload func() []byte
+
+	// Send unsolicited pongs instead of pings, as a one-way heartbeat which
+	// the other end-point isn't asked to answer
+	Pongs bool
+
+	// If positive, the connection is closed without a closing handshake if
+	// no frame at all, pong or other, is received within Timeout after a
+	// ping. The other end-point is then presumed gone, such as dropped by
+	// a NAT or load balancer without notice. Err returns errPingTimeout.
+	// Timeout counts from when the ping is flushed to the network, not
+	// from when it's queued, so a ping waiting behind large outgoing
+	// messages doesn't count against the other end-point.
+	Timeout time.Duration
+}
+
+// Send pings on schedule p until the connection closes, replacing any
+// earlier schedule. A zero Interval stops the pings.
+func (c *Conn) SchedulePings(p PingSchedule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pingStop != nil {
+		close(c.pingStop)
+		c.pingStop = nil
+	}
+	if p.Interval <= 0 {
+		return
+	}
+	c.pingStop = make(chan bool)
+	go c.pingLoop(p, c.pingStop)
+}
+
+func (c *Conn) pingLoop(p PingSchedule, stop <-chan bool) {
+	timer := time.NewTimer(p.next())
+	defer timer.Stop()
+	var silence <-chan time.Time // Fires Timeout after the checked ping
+	var framesIn int64           // Frames received when it was flushed
+	var checking bool            // A ping is checked, once it's flushed
+	flushed := make(chan bool, 1)
+	for {
+		select {
+		case <-timer.C:
+		case <-flushed:
+			framesIn = atomic.LoadInt64(&c.framesIn)
+			silence = time.After(p.Timeout)
+			continue
+		case <-silence:
+			if atomic.LoadInt64(&c.framesIn) == framesIn {
+				c.fail(errPingTimeout)
+				return
+			}
+			silence, checking = nil, false
+			continue
+		case <-stop:
+			return
+		case <-c.done:
+			return
+		}
+		var payload []byte
+		if p.Payload != nil {
+			if payload = p.Payload(); len(payload) > 125 {
+				payload = payload[:125]
+			}
+		}
+		opCode := byte(opCodePing)
+		switch {
+		case p.Pongs:
+			opCode = opCodePong
+		case p.Payload == nil:
+			_, payload = c.rtt.ping(time.Now())
+		}
+		fh, _ := newFrameHeader(true, opCode, int64(len(payload)), c.mask())
+		f := newFrame(fh, bytes.NewReader(payload))
+		if p.Timeout > 0 && !checking {
+			checking = true
+			f.onFlush = func() { flushed <- true }
+		}
+		c.queue(f)
+		timer.Reset(p.next())
+	}
+}
+
+// The time until the next ping
+func (p PingSchedule) next() (d time.Duration) {
+	d = p.Interval
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*p.Jitter+1))) - p.Jitter
+	}
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return
+}
+
+// Send an unsolicited pong, a one-way heartbeat which the other end-point
+// doesn't answer. The payload can be at most 125 bytes.
+func (c *Conn) Pong(payload []byte) error {
+	return c.WriteControl(opCodePong, payload, time.Time{})
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSchedulePings(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SchedulePings(PingSchedule{Interval: 10 * time.Millisecond, Payload: func() []byte { return []byte("hb") }})
+	for i := 0; i < 2; i++ {
+		if payload := readShortFrame(t, client); payload != "hb" {
+			t.Errorf("Unexpected ping payload %q", payload)
+		}
+	}
+	c.SchedulePings(PingSchedule{})
+	// At most one ping may have been sent while stopping
+	var n int
+	for {
+		client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		read, err := client.Read(make([]byte, 16))
+		if n += read; err != nil {
+			break
+		}
+	}
+	if n > 4 {
+		t.Errorf("Pings sent after stopping: %v bytes", n)
+	}
+}
+
+func TestSchedulePingsStats(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SchedulePings(PingSchedule{Interval: time.Millisecond})
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil || header[0] != 0x89 || header[1] != 8 {
+		t.Fatalf("Expected a ping, got %X (%v)", header, err)
+	}
+	payload := make([]byte, 8)
+	io.ReadFull(client, payload)
+	c.SchedulePings(PingSchedule{})
+	client.Write(append([]byte{0x8a, 0x88, 0, 0, 0, 0}, payload...))
+	for i := 0; c.Stats().Samples == 0; i++ {
+		if i == 100 {
+			t.Fatal("The pong wasn't timed")
+		}
+		// Drain the pings sent before stopping
+		client.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		client.Read(make([]byte, 10))
+	}
+}
+
+func TestPingScheduleJitter(t *testing.T) {
+	p := PingSchedule{Interval: time.Second, Jitter: 100 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := p.next(); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("Interval out of range: %v", d)
+		}
+	}
+}
+
+func TestPong(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go c.Pong([]byte("hb"))
+	expectFrames(t, client, []byte{0x8a, 0x02, 'h', 'b'})
+	if err := c.Pong(make([]byte, 126)); err != errInvalidControlFrame {
+		t.Errorf("Expected %v, got %v", errInvalidControlFrame, err)
+	}
+}
+
+func TestSchedulePongs(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SchedulePings(PingSchedule{Interval: time.Millisecond, Pongs: true})
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil || header[0] != 0x8a || header[1] != 0 {
+		t.Errorf("Expected an empty pong, got %X (%v)", header, err)
+	}
+	c.SchedulePings(PingSchedule{})
+}
+
+func TestPingTimeout(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client) // Never answers
+	c.SchedulePings(PingSchedule{Interval: 10 * time.Millisecond, Timeout: 30 * time.Millisecond})
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("Silent connection not closed")
+	}
+	if err := c.Err(); err != errPingTimeout || !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected %v, got %v", errPingTimeout, err)
+	}
+}
+
+func TestPingTimeoutAnswered(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SchedulePings(PingSchedule{Interval: 10 * time.Millisecond, Timeout: 30 * time.Millisecond, Payload: func() []byte { return []byte("hb") }})
+	for end := time.Now().Add(100 * time.Millisecond); time.Now().Before(end); {
+		if payload := readShortFrame(t, client); payload != "hb" {
+			t.Fatalf("Unexpected ping payload %q", payload)
+		}
+		client.Write([]byte{0x8a, 0x82, 0, 0, 0, 0, 'h', 'b'})
+	}
+	if c.State() != OPEN {
+		t.Errorf("Answering connection closed: %v", c.Err())
+	}
+	c.SchedulePings(PingSchedule{})
+}
+
+// Check that Timeout counts from when the ping is flushed, not from when
+// it's queued behind a large message the client is slow to read.
+func TestPingTimeoutQueued(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go c.SendBinary(make([]byte, 1<<16)) // A single frame
+	c.SchedulePings(PingSchedule{Interval: 10 * time.Millisecond, Timeout: 30 * time.Millisecond, Payload: func() []byte { return []byte("hb") }})
+	time.Sleep(100 * time.Millisecond) // The pings wait behind the message
+	client.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(client, header[:2]); err != nil {
+			break // The deadline, after reading for a while
+		}
+		length := int(header[1] & 0x7f)
+		if length == 127 {
+			io.ReadFull(client, header[:8])
+			length = int(binary.BigEndian.Uint64(header))
+		}
+		io.CopyN(ioutil.Discard, client, int64(length))
+		if header[0] == 0x89 {
+			client.Write([]byte{0x8a, 0x82, 0, 0, 0, 0, 'h', 'b'})
+		}
+	}
+	if c.State() != OPEN {
+		t.Errorf("Connection closed while its pings were queued: %v", c.Err())
+	}
+	c.SchedulePings(PingSchedule{})
+}
@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Presence event types
+const (
+	PresenceJoin    = "join"    // A member joined the room
+	PresenceLeave   = "leave"   // A member left the room
+	PresenceMembers = "members" // The current members, sent to who joins
+)
+
+// A change of the members of a room. Sent to the connections in the room
+// as a JSON text message, such as
+//
+//	{"type":"join","room":"lobby","member":"alice"}
+//	{"type":"members","room":"lobby","members":["alice","bob"]}
+type PresenceEvent struct {
+	Type    string   `json:"type"`
+	Room    string   `json:"room"`
+	Member  string   `json:"member,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
+// A named group of connections on a hub, with presence tracking. Each
+// connection joins as a member, chosen by the application, such as a user
+// name. A member may have several connections, it is present while it has
+// any of them in the room.
+type Room struct {
+	Name string
+
+	// A member whose last connection leaves is only reported as left if it
+	// doesn't join again within Debounce, so that flapping reconnects
+	// don't cause any events
+	Debounce time.Duration
+
+	// If not nil, called with every presence event except members
+	// snapshots. It must not join or leave the room.
+	OnPresence func(e PresenceEvent)
+
+	hub     *Hub
+	tag     string
+	mu      sync.Mutex
+	emitMu  sync.Mutex // Keeps events in order, taken while holding mu
+	members map[string]map[*Conn]bool
+	conns   map[*Conn]*roomMembership
+	leaving map[string]*time.Timer // Members waiting for the debounce
+}
+
+// The membership of a connection in a room
+type roomMembership struct {
+	member string
+	left   chan bool // Closed when the connection leaves the room
+}
+
+// Create a room on h. Its connections are subscribed to h and tagged
+// "room:" followed by the name of the room.
+func NewRoom(h *Hub, name string, debounce time.Duration) (r *Room) {
+	r = &Room{
+		Name:     name,
+		Debounce: debounce,
+		hub:      h,
+		tag:      "room:" + name,
+		members:  make(map[string]map[*Conn]bool),
+		conns:    make(map[*Conn]*roomMembership),
+		leaving:  make(map[string]*time.Timer),
+	}
+	return
+}
+
+// Add a connection to the room as member. The other connections are told
+// that the member joined, unless it was already present, and c gets a
+// snapshot of the members. The connection leaves the room when closed.
+func (r *Room) Join(c *Conn, member string) {
+	r.mu.Lock()
+	if _, ok := r.conns[c]; ok {
+		r.mu.Unlock()
+		return
+	}
+	m := &roomMembership{member: member, left: make(chan bool)}
+	r.conns[c] = m
+	joined := r.members[member] == nil
+	if timer, ok := r.leaving[member]; ok {
+		// Back within the debounce
+		timer.Stop()
+		delete(r.leaving, member)
+		joined = false
+	}
+	if joined {
+		r.members[member] = make(map[*Conn]bool)
+	}
+	r.members[member][c] = true
+	snapshot := PresenceEvent{Type: PresenceMembers, Room: r.Name, Members: r.memberList()}
+	r.emitMu.Lock()
+	r.mu.Unlock()
+	defer r.emitMu.Unlock()
+
+	c.Tag(r.tag)
+	r.hub.Subscribe(c)
+	go func() {
+		select {
+		case <-c.done:
+			r.Leave(c)
+		case <-m.left:
+		}
+	}()
+	if joined {
+		r.emit(PresenceEvent{Type: PresenceJoin, Room: r.Name, Member: member}, func(other *Conn) bool {
+			return other != c && other.HasTag(r.tag)
+		})
+	}
+	if data, err := json.Marshal(snapshot); err == nil {
+		c.Send(&Message{Type: TextMessage, Reader: bytes.NewReader(data)})
+	}
+}
+
+// Remove a connection from the room. Its member is reported as left once
+// it has no connection left in the room, after the debounce.
+func (r *Room) Leave(c *Conn) {
+	r.mu.Lock()
+	m, ok := r.conns[c]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.conns, c)
+	close(m.left)
+	c.Untag(r.tag)
+	delete(r.members[m.member], c)
+	if len(r.members[m.member]) > 0 {
+		r.mu.Unlock()
+		return
+	}
+	if r.Debounce > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(r.Debounce, func() {
+			r.mu.Lock()
+			if r.leaving[m.member] != timer {
+				r.mu.Unlock()
+				return // Joined again
+			}
+			r.left(m.member)
+		})
+		r.leaving[m.member] = timer
+		r.mu.Unlock()
+		return
+	}
+	r.left(m.member)
+}
+
+// Remove a member and report that it left. Must be called with r.mu held,
+// which is released.
+func (r *Room) left(member string) {
+	delete(r.members, member)
+	delete(r.leaving, member)
+	r.emitMu.Lock()
+	r.mu.Unlock()
+	defer r.emitMu.Unlock()
+	r.emit(PresenceEvent{Type: PresenceLeave, Room: r.Name, Member: member}, AllTags(r.tag))
+}
+
+// The members present in the room, sorted
+func (r *Room) Members() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.memberList()
+}
+
+// Must be called with r.mu held
+func (r *Room) memberList() (members []string) {
+	members = make([]string, 0, len(r.members))
+	for member := range r.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return
+}
+
+// Broadcast a message to the connections in the room
+func (r *Room) Broadcast(msgType int, payload []byte) BroadcastResult {
+	return r.hub.SendTo(AllTags(r.tag), msgType, payload)
+}
+
+// Send a presence event to the selected connections, and to OnPresence.
+// Must be called with r.emitMu held.
+func (r *Room) emit(e PresenceEvent, selector Selector) {
+	if data, err := json.Marshal(e); err == nil {
+		r.hub.SendTo(selector, TextMessage, data)
+	}
+	if r.OnPresence != nil {
+		r.OnPresence(e)
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Read a short unfragmented frame sent by the server, and return its payload
+func readShortFrame(t *testing.T, client net.Conn) string {
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil {
+		t.Fatalf("Short read: %v", err)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(client, payload); err != nil {
+		t.Fatalf("Short read: %v", err)
+	}
+	return string(payload)
+}
+
+func TestRoomPresence(t *testing.T) {
+	h := NewHub(1)
+	defer h.Close()
+	r := NewRoom(h, "lobby", 0)
+	events := make(chan PresenceEvent, 10)
+	r.OnPresence = func(e PresenceEvent) { events <- e }
+
+	alice, aliceClient := newPipeConn()
+	defer aliceClient.Close()
+	r.Join(alice, "alice")
+	if msg := readShortFrame(t, aliceClient); msg != `{"type":"members","room":"lobby","members":["alice"]}` {
+		t.Errorf("Unexpected snapshot: %s", msg)
+	}
+	bob, bobClient := newPipeConn()
+	r.Join(bob, "bob")
+	if msg := readShortFrame(t, aliceClient); msg != `{"type":"join","room":"lobby","member":"bob"}` {
+		t.Errorf("Unexpected join event: %s", msg)
+	}
+	if msg := readShortFrame(t, bobClient); msg != `{"type":"members","room":"lobby","members":["alice","bob"]}` {
+		t.Errorf("Unexpected snapshot: %s", msg)
+	}
+	bobClient.Close()
+	if msg := readShortFrame(t, aliceClient); msg != `{"type":"leave","room":"lobby","member":"bob"}` {
+		t.Errorf("Unexpected leave event: %s", msg)
+	}
+	expected := []PresenceEvent{
+		{Type: PresenceJoin, Room: "lobby", Member: "alice"},
+		{Type: PresenceJoin, Room: "lobby", Member: "bob"},
+		{Type: PresenceLeave, Room: "lobby", Member: "bob"},
+	}
+	for _, e := range expected {
+		if got := <-events; !reflect.DeepEqual(got, e) {
+			t.Errorf("Expected %+v, got %+v", e, got)
+		}
+	}
+	if members := r.Members(); !reflect.DeepEqual(members, []string{"alice"}) {
+		t.Errorf("Unexpected members: %v", members)
+	}
+}
+
+func TestRoomDebounce(t *testing.T) {
+	h := NewHub(1)
+	defer h.Close()
+	r := NewRoom(h, "lobby", 50*time.Millisecond)
+	events := make(chan PresenceEvent, 10)
+	r.OnPresence = func(e PresenceEvent) { events <- e }
+	first, firstClient := newPipeConn()
+	defer firstClient.Close()
+	go io.Copy(io.Discard, firstClient)
+	r.Join(first, "alice")
+	<-events
+	r.Leave(first)
+	second, secondClient := newPipeConn()
+	defer secondClient.Close()
+	go io.Copy(io.Discard, secondClient)
+	r.Join(second, "alice") // Reconnected within the debounce
+	select {
+	case e := <-events:
+		t.Errorf("Unexpected event: %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+	r.Leave(second)
+	select {
+	case e := <-events:
+		if e.Type != PresenceLeave {
+			t.Errorf("Expected leave, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("No leave event after the debounce")
+	}
+	if len(r.Members()) != 0 {
+		t.Errorf("Unexpected members: %v", r.Members())
+	}
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"io"
+)
+
+// Priority bands of outgoing messages. A message is queued in the band of
+// its Priority, 0 being the most urgent, and the bands are drained in
+// order: a message is only sent once the more urgent bands are empty. If
+// MaxSkips is positive, a waiting message is skipped for at most that many
+// more urgent messages, so that lower bands are never starved. The zero
+// value, a single band, sends messages in the order they are queued.
+//
+// Messages are ordered once taken from Out, at most as many as Out can
+// hold at a time.
+type Priorities struct {
+	Bands    int
+	MaxSkips int
+}
+
+// The priority bands of the connection
+func (c *Conn) Priorities() Priorities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.priorities
+}
+
+// Change the priority bands, from the next message sent
+func (c *Conn) SetPriorities(p Priorities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.priorities = p
+}
+
+// Outgoing messages taken from Out, waiting in their bands
+type outQueue struct {
+	bands   [][]io.Reader
+	skipped []int // Times the head of each band was skipped
+	n       int
+}
+
+// The band of an outgoing message, limited to the bands there are
+func priority(r io.Reader, bands int) (band int) {
+	if m := outgoingMessage(r); m != nil {
+		band = m.Priority
+	}
+	if band >= bands {
+		band = bands - 1
+	}
+	if band < 0 {
+		band = 0
+	}
+	return
+}
+
+func (q *outQueue) push(r io.Reader, bands int) {
+	for len(q.bands) < bands || len(q.bands) == 0 {
+		q.bands = append(q.bands, nil)
+		q.skipped = append(q.skipped, 0)
+	}
+	band := priority(r, len(q.bands))
+	q.bands[band] = append(q.bands[band], r)
+	q.n++
+}
+
+// Take the next message to send, or nil if there is none
+func (q *outQueue) pop(maxSkips int) (r io.Reader) {
+	next := -1
+	for band, waiting := range q.bands {
+		if len(waiting) == 0 {
+			continue
+		}
+		if next < 0 {
+			next = band
+		} else if maxSkips > 0 && q.skipped[band] >= maxSkips {
+			next = band // Starved
+			break
+		}
+	}
+	if next < 0 {
+		return nil
+	}
+	for band, waiting := range q.bands {
+		if band != next && len(waiting) > 0 {
+			q.skipped[band]++
+		}
+	}
+	q.skipped[next] = 0
+	r, q.bands[next] = q.bands[next][0], q.bands[next][1:]
+	q.n--
+	return
+}
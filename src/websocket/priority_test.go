@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+// A message of one byte, in a priority band
+func priorityMessage(b byte, priority int) *Message {
+	return &Message{Type: BinaryMessage, Priority: priority, Reader: bytes.NewReader([]byte{b})}
+}
+
+func TestOutQueuePriorities(t *testing.T) {
+	var q outQueue
+	for i, band := range []int{2, 1, 0, 2, 0, 5} {
+		q.push(priorityMessage(byte(i), band), 3)
+	}
+	var order []byte
+	for r := q.pop(0); r != nil; r = q.pop(0) {
+		b, _ := r.(*Message).Reader.(*bytes.Reader).ReadByte()
+		order = append(order, b)
+	}
+	if !bytes.Equal(order, []byte{2, 4, 1, 0, 3, 5}) {
+		t.Errorf("Unexpected order: %v", order)
+	}
+}
+
+func TestOutQueueStarvation(t *testing.T) {
+	var q outQueue
+	q.push(priorityMessage(0, 1), 2)
+	for i := 1; i <= 5; i++ {
+		q.push(priorityMessage(byte(i), 0), 2)
+	}
+	var order []byte
+	for r := q.pop(2); r != nil; r = q.pop(2) {
+		b, _ := r.(*Message).Reader.(*bytes.Reader).ReadByte()
+		order = append(order, b)
+	}
+	if !bytes.Equal(order, []byte{1, 2, 0, 3, 4, 5}) {
+		t.Errorf("Unexpected order: %v", order)
+	}
+}
+
+func TestConnPriorities(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), true, &Buffers{Out: 0x40})
+	c.SetPriorities(Priorities{Bands: 2})
+	// Queued before the connection starts, so all are ordered at once
+	c.Out <- priorityMessage('a', 1)
+	c.Out <- priorityMessage('b', 1)
+	c.Out <- priorityMessage('c', 0)
+	c.start()
+	expectFrames(t, client, []byte{0x82, 0x01, 'c', 0x82, 0x01, 'a', 0x82, 0x01, 'b'})
+}
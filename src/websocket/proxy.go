@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Headers forwarded to the backend by a ReverseProxy, unless configured
+var defaultForwardHeaders = []string{"Authorization", "Cookie", "User-Agent"}
+
+// Headers which apply to a single connection, and aren't passed on with a
+// refusal of the backend, see RFC 7230 section 6.1
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// A reverse proxy for websocket connections, like httputil.ReverseProxy.
+// Every client is upgraded and relayed to its own backend connection.
+type ReverseProxy struct {
+	// Returns the ws or wss URL of the backend for a request
+	Backend func(r *http.Request) *url.URL
+
+	// Dialer used to connect to the backend. If nil, DefaultDialer is used.
+	// Its Header and Subprotocols are replaced for every request.
+	Dialer *Dialer
+
+	// Request headers forwarded to the backend. If nil, Authorization,
+	// Cookie and User-Agent are forwarded. The Origin header, requested
+	// subprotocols and X-Forwarded-For are always forwarded.
+	ForwardHeaders []string
+}
+
+// A reverse proxy relaying to target, with the request path appended to the
+// target path
+func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
+	return &ReverseProxy{
+		Backend: func(r *http.Request) *url.URL {
+			u := *target
+			u.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(r.URL.Path, "/")
+			u.RawQuery = r.URL.RawQuery
+			return &u
+		},
+	}
+}
+
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := wsClientHandshake(r, Strict); err != nil {
+		// Let the handler respond to the malformed handshake
+		NewHandler().ServeHTTP(w, r)
+		return
+	}
+	backend, resp, err := p.dialBackend(r)
+	if err == errHandshakeRefused {
+		// Pass the refusal on to the client
+		copyEndToEndHeaders(w.Header(), resp.Header)
+		// The body may have been cut short by the dialer
+		w.Header().Del("Content-Length")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	} else if err != nil {
+		Log.Println(err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", protocol)
+	}
+	c := NewHandler().upgrade(w, r)
+	if c == nil {
+		backend.Close()
+		return
+	}
+	c.start()
+	Relay(context.Background(), c, backend)
+}
+
+// Connect to the backend for r, forwarding the relevant headers
+func (p *ReverseProxy) dialBackend(r *http.Request) (c *Conn, resp *http.Response, err error) {
+	d := DefaultDialer
+	if p.Dialer != nil {
+		d = p.Dialer
+	}
+	dialer := *d
+	dialer.Header = make(http.Header)
+	forward := p.ForwardHeaders
+	if forward == nil {
+		forward = defaultForwardHeaders
+	}
+	for _, k := range forward {
+		if vs := r.Header.Values(k); len(vs) > 0 {
+			dialer.Header[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		dialer.Header.Set("X-Forwarded-For", host)
+	}
+	dialer.Subprotocols = headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	return dialer.DialContext(r.Context(), p.Backend(r).String(), r.Header.Get("Origin"))
+}
+
+// Copy the headers of src to dst, except the hop-by-hop ones and those
+// listed in its Connection header
+func copyEndToEndHeaders(dst, src http.Header) {
+	skip := make(map[string]bool)
+	for _, k := range hopHeaders {
+		skip[k] = true
+	}
+	for _, k := range headerTokens(src, "Connection") {
+		skip[http.CanonicalHeaderKey(k)] = true
+	}
+	for k, vs := range src {
+		if !skip[k] {
+			dst[k] = append(dst[k], vs...)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReverseProxy(t *testing.T) {
+	var auth, path string
+	backend := setupEchoServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth, path = r.Header.Get("Authorization"), r.URL.Path
+			w.Header().Set("Sec-WebSocket-Protocol", headerTokens(r.Header, "Sec-WebSocket-Protocol")[0])
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer backend.Close()
+	target, _ := url.Parse(wsURL(backend) + "/backend")
+	proxy := httptest.NewServer(NewSingleHostReverseProxy(target))
+	defer proxy.Close()
+
+	d := &Dialer{
+		Header:       http.Header{"Authorization": {"Bearer token"}},
+		Subprotocols: []string{"chat", "superchat"},
+	}
+	c, resp, err := d.Dial(wsURL(proxy)+"/echo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "chat" {
+		t.Errorf("Subprotocol not forwarded: %q", protocol)
+	}
+	if auth != "Bearer token" || path != "/backend/echo" {
+		t.Errorf("Request not forwarded properly: %q %q", auth, path)
+	}
+	c.Out <- bytes.NewBufferString("Hello")
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "Hello" {
+		t.Errorf("Echo through proxy mismatch: %q", msg)
+	}
+}
+
+func TestReverseProxyRefused(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Go away", http.StatusForbidden)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(wsURL(backend))
+	proxy := httptest.NewServer(NewSingleHostReverseProxy(target))
+	defer proxy.Close()
+	if _, resp, err := Dial(wsURL(proxy), ""); err != errHandshakeRefused || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected refusal with status 403, got %v", err)
+	}
+}
+
+func TestReverseProxyRefusedHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="backend"`)
+		w.Header().Set("Retry-After", "120")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "secret")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		http.Error(w, "Log in first", http.StatusUnauthorized)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(wsURL(backend))
+	proxy := httptest.NewServer(NewSingleHostReverseProxy(target))
+	defer proxy.Close()
+	_, resp, err := Dial(wsURL(proxy), "")
+	if err != errHandshakeRefused || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected refusal with status 401, got %v", err)
+	}
+	if v := resp.Header.Get("WWW-Authenticate"); v != `Basic realm="backend"` {
+		t.Errorf("WWW-Authenticate not passed on: %q", v)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "120" {
+		t.Errorf("Retry-After not passed on: %q", v)
+	}
+	if vs := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(vs, []string{"a=1", "b=2"}) {
+		t.Errorf("Set-Cookie not passed on: %q", vs)
+	}
+	for _, k := range []string{"X-Hop", "Keep-Alive"} {
+		if v := resp.Header.Get(k); v != "" {
+			t.Errorf("Hop-by-hop header %v passed on: %q", k, v)
+		}
+	}
+}
+
+func TestReverseProxyRefusedLongBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 2*maxHandshakeBodyLength)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(wsURL(backend))
+	client, resp := handshake(t, NewSingleHostReverseProxy(target), newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %v", resp.Status)
+	}
+	if body, err := ioutil.ReadAll(resp.Body); err != nil || len(body) != maxHandshakeBodyLength {
+		t.Errorf("Expected the first %v bytes of the body, got %v bytes: %v", maxHandshakeBodyLength, len(body), err)
+	}
+}
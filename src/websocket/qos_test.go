@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSendDroppable(t *testing.T) {
+	c := newIdleConn()
+	for i := 0; i < cap(c.out); i++ {
+		if err := c.Send(&Message{Type: TextMessage, QoS: Droppable, Reader: new(bytes.Buffer)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send(&Message{Type: TextMessage, QoS: Droppable, Reader: new(bytes.Buffer)}); err != errMessageDropped {
+		t.Errorf("Expected errMessageDropped, got %v", err)
+	}
+	if n := c.Dropped(); n != 1 {
+		t.Errorf("Expected 1 dropped message, got %v", n)
+	}
+}
+
+func TestSendEvictsSlowClient(t *testing.T) {
+	c := newIdleConn()
+	c.EvictTimeout = 10 * time.Millisecond
+	for i := 0; i < cap(c.out); i++ {
+		c.Send(&Message{Type: TextMessage, Reader: new(bytes.Buffer)})
+	}
+	if err := c.Send(&Message{Type: TextMessage, Reader: new(bytes.Buffer)}); err != errSlowClient {
+		t.Errorf("Expected errSlowClient, got %v", err)
+	}
+	if c.State() == OPEN {
+		t.Error("Slow client not evicted")
+	}
+}
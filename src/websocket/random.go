@@ -0,0 +1,27 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+)
+
+// A randomness source shared by the goroutines of a connection
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// The randomness source of a dialer
+func (d *Dialer) random() io.Reader {
+	if d.Rand == nil {
+		return rand.Reader
+	}
+	return d.Rand
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Reads the bytes 0, 1, 2 and so on, wrapping around
+type countingReader struct {
+	next byte
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+func TestDialerRand(t *testing.T) {
+	h := NewHandler()
+	keys := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Sec-WebSocket-Key")
+		h.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	d := &Dialer{Rand: &countingReader{}}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expected := base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+	if key := <-keys; key != expected {
+		t.Errorf("Expected key %v, got %v", expected, key)
+	}
+	if m := c.mask(); !bytes.Equal(m, []byte{16, 17, 18, 19}) {
+		t.Errorf("Unexpected masking key %v", m)
+	}
+}
+
+func TestDialerRandExhausted(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	// The key, and the masking key of a single frame
+	d := &Dialer{Rand: bytes.NewReader(make([]byte, 16+4))}
+	c, _, err := d.Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SendText("first"); err != nil {
+		t.Fatal(err)
+	}
+	c.SendText("second")
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("Connection not closed once the masking keys ran out")
+	}
+	if err = c.Err(); !errors.Is(err, io.EOF) || !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected %v, got %v", io.EOF, err)
+	}
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errRateLimited = classify(ErrHandshake, "Too many handshake attempts")
+
+// A limit of the rate of handshake attempts of each client IP address, see
+// Conn.ClientIP. Every address has a bucket of Burst attempts, refilled at
+// Rate attempts per second. Attempts beyond it are refused with 429 before
+// the handshake is validated, so that a flood of them costs little.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[netip.Addr]*tokenBucket
+	swept   time.Time // When full buckets were last forgotten
+}
+
+// The attempts left of an address, as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimit(rate float64, burst int) (l *RateLimit) {
+	l = &RateLimit{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[netip.Addr]*tokenBucket),
+	}
+	return
+}
+
+// Take an attempt of ip at now. If none is left, returns false and how long
+// until there is one.
+func (l *RateLimit) allow(ip netip.Addr, now time.Time) (ok bool, retry time.Duration) {
+	burst := math.Max(float64(l.Burst), 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[netip.Addr]*tokenBucket)
+	}
+	l.sweep(now, burst)
+	b, found := l.buckets[ip]
+	if !found {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		if l.Rate > 0 {
+			retry = time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+		}
+		return
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget the buckets which have been refilled, at most once per time it
+// takes to refill one, so that the map only holds recent addresses
+func (l *RateLimit) sweep(now time.Time, burst float64) {
+	if l.Rate <= 0 {
+		return
+	}
+	refill := time.Duration(burst / l.Rate * float64(time.Second))
+	if now.Sub(l.swept) < refill {
+		return
+	}
+	l.swept = now
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// Refuse r with 429 if its client has no handshake attempts left. Returns
+// true if it may go on.
+func (h *Handler) rateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if h.RateLimit == nil {
+		return true
+	}
+	ok, retry := h.RateLimit.allow(h.clientIP(r), time.Now())
+	if !ok {
+		if retry > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		}
+		h.refuse(w, r, errRateLimited, http.StatusTooManyRequests)
+	}
+	return ok
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimitBucket(t *testing.T) {
+	l := NewRateLimit(2, 3)
+	a, b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(a, now); !ok {
+			t.Fatalf("Attempt %v within the burst refused", i)
+		}
+	}
+	ok, retry := l.allow(a, now)
+	if ok {
+		t.Error("Attempt beyond the burst allowed")
+	}
+	if retry != time.Second/2 {
+		t.Errorf("Expected retry after 500ms, got %v", retry)
+	}
+	if ok, _ := l.allow(b, now); !ok {
+		t.Error("Attempt of another address refused")
+	}
+	if ok, _ := l.allow(a, now.Add(time.Second/2)); !ok {
+		t.Error("Attempt refused once refilled")
+	}
+	l.allow(a, now.Add(10*time.Second))
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected the refilled bucket to be forgotten, %v left", len(l.buckets))
+	}
+}
+
+func TestRateLimitHandshake(t *testing.T) {
+	h := NewHandler()
+	h.RateLimit = NewRateLimit(0.1, 1)
+	client, resp := handshake(t, h, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("First attempt refused: %v", resp.Status)
+	}
+	_, resp = handshake(t, h, newHandshakeRequest())
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %v", resp.Status)
+	}
+	if after := resp.Header.Get("Retry-After"); after != "10" {
+		t.Errorf("Expected Retry-After 10, got %q", after)
+	}
+	if h.Stats().Rejected != 1 {
+		t.Error("Refused attempt not counted")
+	}
+}
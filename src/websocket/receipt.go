@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"io"
+	"time"
+)
+
+// Start tracking an outgoing message taken from Out, so that its Sent
+// callback is called exactly once. Returns false, after reporting the
+// message as failed, if the connection is no longer open.
+func (c *Conn) track(r io.Reader) bool {
+	m := outgoingMessage(r)
+	c.mu.Lock()
+	open := c.state == OPEN
+	if open && m != nil && m.Sent != nil {
+		if c.unsent == nil {
+			c.unsent = make(map[*Message]bool)
+		}
+		c.unsent[m] = true
+	}
+	c.mu.Unlock()
+	if !open && m != nil && m.Sent != nil {
+		m.Sent(errConnClosed)
+	}
+	return open
+}
+
+// The message of an outgoing reader, or nil if it isn't one
+func outgoingMessage(r io.Reader) *Message {
+	switch m := r.(type) {
+	case *Message:
+		return m
+	case *writeRequest:
+		return m.Message
+	}
+	return nil
+}
+
+// Call the Sent callback of a tracked message, unless already called
+func (c *Conn) reportSent(r io.Reader, err error) {
+	m := outgoingMessage(r)
+	if m == nil {
+		return
+	}
+	c.mu.Lock()
+	tracked := c.unsent[m]
+	delete(c.unsent, m)
+	c.mu.Unlock()
+	if tracked {
+		m.Sent(err)
+	}
+}
+
+// Report messages which were queued on Out after the connection closed,
+// and after the message send loop stopped taking them
+func (c *Conn) failLateUnsent() {
+	select {
+	case <-c.done:
+		c.failUnsent()
+	default:
+	}
+}
+
+// Report every message which is not yet sent as failed, once the
+// connection is closed. Messages waiting on Out are taken and failed too,
+// and so are those sent on Out later, see drainOut.
+func (c *Conn) failUnsent() {
+	c.mu.Lock()
+	unsent := c.unsent
+	c.unsent = nil
+	aborted := outgoingMessage(c.aborted)
+	c.mu.Unlock()
+	for m := range unsent {
+		if m == aborted {
+			m.Sent(errMessageAborted)
+		} else {
+			m.Sent(errConnClosed)
+		}
+	}
+	c.outDrain.Do(func() { go c.drainOut() })
+	for {
+		select {
+		case r := <-c.out:
+			c.failOutgoing(r, errConnClosed)
+		default:
+			return
+		}
+	}
+}
+
+// Fail the messages sent on Out after the connection is closed, so that a
+// sender isn't blocked once the buffer of Out is full. Stops when nothing
+// has been sent on Out for closeTimeout.
+func (c *Conn) drainOut() {
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-c.out:
+			c.failOutgoing(r, errConnClosed)
+			timer.Reset(closeTimeout)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Report an outgoing message which was never tracked as failed with err
+func (c *Conn) failOutgoing(r io.Reader, err error) {
+	if m := outgoingMessage(r); m != nil && m.Sent != nil {
+		m.Sent(err)
+	}
+	if req, ok := r.(*writeRequest); ok && req.done != nil {
+		req.done <- err
+	}
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestSentReceipt(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+	sent := make(chan error, 1)
+	c.Out <- &Message{Type: TextMessage, Reader: bytes.NewBufferString("Hello"), Sent: func(err error) {
+		sent <- err
+	}}
+	if err := <-sent; err != nil {
+		t.Errorf("Expected the message to be sent, got %v", err)
+	}
+}
+
+func TestUnsentReceipts(t *testing.T) {
+	c, client := newPipeConn()
+	// Nothing is read from the client, so the messages get stuck
+	const n = 50
+	results := make(chan error, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			m := &Message{Type: TextMessage, Reader: bytes.NewBufferString("Hello"), Sent: func(err error) {
+				results <- err
+			}}
+			if c.Send(m) != nil {
+				results <- errConnClosed // Not accepted
+			}
+		}
+	}()
+	for len(c.out) < cap(c.out) {
+		runtime.Gosched()
+	}
+	client.Close()
+	c.Wait()
+	for i := 0; i < n; i++ {
+		if err := <-results; err != errConnClosed {
+			t.Errorf("Expected %v for a message which wasn't sent, got %v", errConnClosed, err)
+		}
+	}
+}
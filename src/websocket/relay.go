@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// Pipe messages between a and b in both directions until either connection
+// closes, then close the other one with the same status. Messages keep their
+// type and their fragmentation, each frame is relayed as a frame of the same
+// payload as it arrives, so a slow receiver slows down the sender. Returns
+// the error which ended a or b, see Conn.Err, or nil if both were closed
+// cleanly. If ctx is done first, both connections are closed with status
+// going away and ctx.Err() is returned.
+func Relay(ctx context.Context, a, b *Conn) error {
+	done := make(chan bool, 2)
+	go relayMessages(a, b, done)
+	go relayMessages(b, a, done)
+	select {
+	case <-done:
+		<-done
+		if err := a.Err(); err != nil {
+			return err
+		}
+		return b.Err()
+	case <-ctx.Done():
+		goingAway := newErrConnection(statusGoingAway, "")
+		a.sendClose(goingAway)
+		b.sendClose(goingAway)
+		return ctx.Err()
+	}
+}
+
+// Send all messages from src to dst, then close dst the way src was closed
+func relayMessages(src, dst *Conn, done chan<- bool) {
+	var current *relayedFrames // Message being relayed
+	src.OnFragment(func(_ *Conn, f Fragment) {
+		if current == nil {
+			current = &relayedFrames{frames: make(chan Fragment), done: dst.done}
+			if dst.enqueue(&Message{Type: f.Type, Reader: current}) != nil {
+				current.frames = nil // Dropped
+			}
+		}
+		current.relay(f)
+		if f.Last {
+			current = nil
+		}
+	})
+	// Messages which arrived before the fragments are relayed
+	for r := range src.In {
+		if dst.enqueue(r) != nil {
+			io.Copy(ioutil.Discard, r)
+		}
+	}
+	if current != nil && current.frames != nil {
+		// The router has stopped, the message is unfinished
+		close(current.frames)
+	}
+	dst.sendClose(src.closeStatus().reply())
+	done <- true
+}
+
+// The frames of a relayed message, sent as they arrive with the same
+// boundaries, see sendRelayed. Read gives their payloads one after another,
+// for when an interceptor wraps the message.
+type relayedFrames struct {
+	frames chan Fragment // Closed if the message is unfinished, nil if dropped
+	done   <-chan bool   // Of the receiving connection
+	buf    bytes.Reader  // Rest of the frame being read
+	last   bool          // The last frame has been read
+}
+
+// Pass on a frame
+func (rf *relayedFrames) relay(f Fragment) {
+	if rf.frames == nil {
+		return
+	}
+	select {
+	case rf.frames <- f:
+	case <-rf.done:
+		rf.frames = nil
+	}
+}
+
+// The next frame. Fails with io.ErrUnexpectedEOF if the message ends
+// without its last frame, and errConnClosed if the receiving connection
+// closes first.
+func (rf *relayedFrames) next() (f Fragment, err error) {
+	var ok bool
+	select {
+	case f, ok = <-rf.frames:
+		if !ok {
+			err = io.ErrUnexpectedEOF
+		}
+	case <-rf.done:
+		err = errConnClosed
+	}
+	return
+}
+
+func (rf *relayedFrames) Read(p []byte) (n int, err error) {
+	for rf.buf.Len() == 0 {
+		if rf.last {
+			return 0, io.EOF
+		}
+		var f Fragment
+		if f, err = rf.next(); err != nil {
+			return
+		}
+		rf.buf.Reset(f.Payload)
+		rf.last = f.Last
+	}
+	return rf.buf.Read(p)
+}
+
+// Send the frames of a relayed message with their boundaries, each queued
+// as soon as it arrives
+func (c *Conn) sendRelayed(r io.Reader, rf *relayedFrames, op byte) (n int64, err error) {
+	for {
+		var in Fragment
+		if in, err = rf.next(); err != nil {
+			return
+		}
+		fh, _ := newFrameHeader(in.Last, op, int64(len(in.Payload)), c.mask())
+		f := newFrame(fh, bytes.NewReader(in.Payload))
+		if in.Last {
+			f.sent = r
+		}
+		if err = c.queue(f); err != nil {
+			return
+		}
+		n += int64(len(in.Payload))
+		if in.Last {
+			return
+		}
+		c.mu.Lock()
+		c.partial = r
+		c.mu.Unlock()
+		op = opCodeContinuation
+	}
+}
+
+// The status the other end-point closed the connection with, or abnormal
+// closure if no close frame was received
+func (c *Conn) closeStatus() *errConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remoteClose == nil {
+		return newErrConnection(statusAbnormalClosure, "")
+	}
+	return c.remoteClose
+}
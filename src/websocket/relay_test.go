@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	h := NewHandler()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	relayed := make(chan error)
+	go func() {
+		relayed <- Relay(context.Background(), <-h.Conns, <-h.Conns)
+	}()
+	a, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Out <- &Message{Type: BinaryMessage, Reader: bytes.NewBufferString("Hello b")}
+	m := (<-b.In).(*Message)
+	if msg, _ := ioutil.ReadAll(m); m.Type != BinaryMessage || string(msg) != "Hello b" {
+		t.Errorf("Relayed message mismatch: type %v, %q", m.Type, msg)
+	}
+
+	a.sendClose(newErrConnection(4000, "bye"))
+	for range b.In {
+	}
+	if b.remoteClose == nil || b.remoteClose.code != 4000 {
+		t.Errorf("Close status not propagated: %v", b.remoteClose)
+	}
+	var ce *CloseError
+	if err = <-relayed; !errors.As(err, &ce) || ce.Code != 4000 || ce.Text != "bye" {
+		t.Errorf("Expected the close status of a, got %v", err)
+	}
+}
+
+func TestRelayCancel(t *testing.T) {
+	h := NewHandler()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	relayed := make(chan error)
+	go func() {
+		relayed <- Relay(ctx, <-h.Conns, <-h.Conns)
+	}()
+	a, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Dial(wsURL(server), ""); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err = <-relayed; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	for range a.In {
+	}
+	if a.remoteClose == nil || a.remoteClose.code != statusGoingAway {
+		t.Errorf("Expected going away status, got %v", a.remoteClose)
+	}
+}
+
+func TestParseClosePayload(t *testing.T) {
+	cases := []struct {
+		payload []byte
+		code    uint16
+		reason  string
+	}{
+		{nil, statusNoStatusRcvd, ""},
+		{[]byte{0x03}, statusProtocolError, "Malformed close frame"},
+		{[]byte{0x03, 0xE8}, statusNormalClosure, ""},
+		{[]byte{0x0F, 0xA0, 'b', 'y', 'e'}, 4000, "bye"},
+	}
+	for _, c := range cases {
+		e := parseClosePayload(c.payload)
+		if e.code != c.code || e.reason != c.reason {
+			t.Errorf("Payload %X parsed as %v", c.payload, e)
+		}
+	}
+}
+
+func TestRelayFragmentation(t *testing.T) {
+	h := NewHandler()
+	a, _ := handshake(t, h, newHandshakeRequest())
+	defer a.Close()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	relayed := make(chan error, 1)
+	go func() {
+		relayed <- Relay(context.Background(), <-h.Conns, <-h.Conns)
+	}()
+	b, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments := make(chan Fragment, 4)
+	b.OnFragment(func(c *Conn, f Fragment) { fragments <- f })
+	a.Write([]byte{
+		0x02, 0x83, 0, 0, 0, 0, 'o', 'n', 'e',
+		0x00, 0x82, 0, 0, 0, 0, 't', 'w',
+		0x80, 0x85, 0, 0, 0, 0, 't', 'h', 'r', 'e', 'e',
+	})
+	var got []Fragment
+	for len(got) < 3 {
+		select {
+		case f := <-fragments:
+			got = append(got, f)
+		case <-time.After(time.Second):
+			t.Fatalf("Only %v fragments relayed", len(got))
+		}
+	}
+	expected := []Fragment{
+		{BinaryMessage, []byte("one"), false},
+		{BinaryMessage, []byte("tw"), false},
+		{BinaryMessage, []byte("three"), true},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected fragments %v, got %v", expected, got)
+	}
+	// Lost without a close frame
+	a.Close()
+	if err = <-relayed; !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected the abnormal closure, got %v", err)
+	}
+}
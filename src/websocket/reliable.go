@@ -0,0 +1,218 @@
+package websocket
+
+// Acknowledged delivery of messages across reconnects.
+//
+// Every frame of the reliability layer is sent as one binary message,
+// starting with a type byte and a 8 byte big endian sequence number:
+//
+//	data:   0x00 | seq | message type (1 B) | payload
+//	ack:    0x01 | seq
+//	resume: 0x02 | seq
+//
+// Data messages are numbered from 1 and up, by each end-point separately.
+// An ack acknowledges all data messages up to and including seq, the
+// receiver may ack every message or only some of them. Data messages must be
+// received in order, a data message out of order is dropped. When a
+// connection is attached, both end-points first send resume with the last
+// data message they received in order, and then send every data message
+// after the one the other end-point resumed from, in order. Data messages
+// which were already received are acked again but otherwise ignored.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+)
+
+// Reliability layer frame types
+const (
+	reliableData = byte(iota)
+	reliableAck
+	reliableResume
+)
+
+const reliableHeaderLength = 9
+
+var (
+	errReliableBufferFull = classify(ErrQueueFull, "Too many unacknowledged messages")
+	errMalformedReliable  = classify(ErrProtocol, "Malformed reliability layer frame")
+)
+
+// A message buffered until the other end-point acknowledges it
+type reliableMessage struct {
+	seq     uint64
+	msgType int
+	payload []byte
+}
+
+// Reliable delivery of messages over a series of connections. Sent messages
+// are kept until acknowledged, and resent after reconnecting.
+type Reliable struct {
+	// Held while data messages are queued on the connection, so that they
+	// are queued in order. The queue may block, so mu is never held then,
+	// and acks are processed meanwhile.
+	sendMu sync.Mutex
+
+	mu         sync.Mutex
+	c          *Conn             // Currently attached connection, or nil
+	bufferSize int               // Maximum number of unacknowledged messages
+	unacked    []reliableMessage // Sent messages, in order
+	nextSeq    uint64            // Sequence number of the next sent message
+	received   uint64            // Last sequence number received in order
+	in         chan reliableMessage
+	onDetach   func(c *Conn) // Called when an attached connection closes
+}
+
+// Create a reliability layer keeping at most bufferSize unacknowledged
+// messages. Attach a connection to start sending.
+func NewReliable(bufferSize int) (r *Reliable) {
+	r = &Reliable{
+		bufferSize: bufferSize,
+		nextSeq:    1,
+		in:         make(chan reliableMessage, 0x10),
+	}
+	return
+}
+
+// Continue over a new connection, both end-points must use a reliability
+// layer on it. Messages not acknowledged on the previous connection are
+// sent again once the other end-point tells where it resumes from. The
+// previous connection is closed, if still attached.
+func (r *Reliable) Attach(c *Conn) {
+	r.mu.Lock()
+	previous := r.c
+	r.c = c
+	received := r.received
+	r.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	r.sendFrame(c, reliableResume, received, nil)
+	go r.readLoop(c)
+}
+
+// Send a message. Returns errReliableBufferFull if too many messages are
+// waiting to be acknowledged. Messages sent while no connection is attached
+// are sent once one is.
+func (r *Reliable) Send(msgType int, payload []byte) (err error) {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+	r.mu.Lock()
+	if len(r.unacked) >= r.bufferSize {
+		r.mu.Unlock()
+		err = errReliableBufferFull
+		return
+	}
+	m := reliableMessage{seq: r.nextSeq, msgType: msgType, payload: payload}
+	r.nextSeq++
+	r.unacked = append(r.unacked, m)
+	c := r.c
+	r.mu.Unlock()
+	if c != nil {
+		r.sendData(c, m)
+	}
+	return
+}
+
+// Wait for the next message, received exactly once and in order
+func (r *Reliable) Receive() (msgType int, payload []byte) {
+	m := <-r.in
+	return m.msgType, m.payload
+}
+
+// Number of sent messages not yet acknowledged
+func (r *Reliable) Unacked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.unacked)
+}
+
+func (r *Reliable) sendData(c *Conn, m reliableMessage) {
+	r.sendFrame(c, reliableData, m.seq, append([]byte{byte(m.msgType)}, m.payload...))
+}
+
+func (r *Reliable) sendFrame(c *Conn, typ byte, seq uint64, data []byte) {
+	buf := make([]byte, reliableHeaderLength, reliableHeaderLength+len(data))
+	buf[0] = typ
+	binary.BigEndian.PutUint64(buf[1:], seq)
+	buf = append(buf, data...)
+	if c.State() == OPEN {
+		c.enqueue(&Message{Type: BinaryMessage, Reader: bytes.NewReader(buf)})
+	}
+}
+
+// Process incoming frames until the connection closes, then detach it
+func (r *Reliable) readLoop(c *Conn) {
+	for m := range c.In {
+		frame, err := ioutil.ReadAll(m)
+		if err == nil {
+			err = r.process(c, frame)
+		}
+		if err != nil {
+			Log.Println(err)
+			c.Close()
+			break
+		}
+	}
+	r.mu.Lock()
+	detached := r.c == c
+	if detached {
+		r.c = nil
+	}
+	r.mu.Unlock()
+	if detached && r.onDetach != nil {
+		r.onDetach(c)
+	}
+}
+
+func (r *Reliable) process(c *Conn, frame []byte) (err error) {
+	if len(frame) < reliableHeaderLength {
+		return errMalformedReliable
+	}
+	typ, seq, data := frame[0], binary.BigEndian.Uint64(frame[1:]), frame[reliableHeaderLength:]
+	switch typ {
+	case reliableData:
+		if len(data) < 1 {
+			return errMalformedReliable
+		}
+		r.mu.Lock()
+		fresh := seq == r.received+1
+		if fresh {
+			r.received = seq
+		}
+		received := r.received
+		r.mu.Unlock()
+		if fresh {
+			r.in <- reliableMessage{seq: seq, msgType: int(data[0]), payload: data[1:]}
+		}
+		// Out of order messages are dropped, they will be sent again
+		r.sendFrame(c, reliableAck, received, nil)
+	case reliableAck:
+		r.mu.Lock()
+		r.acknowledge(seq)
+		r.mu.Unlock()
+	case reliableResume:
+		r.sendMu.Lock()
+		r.mu.Lock()
+		r.acknowledge(seq)
+		unacked := append([]reliableMessage(nil), r.unacked...)
+		r.mu.Unlock()
+		for _, m := range unacked {
+			r.sendData(c, m)
+		}
+		r.sendMu.Unlock()
+	default:
+		return errMalformedReliable
+	}
+	return
+}
+
+// Forget messages up to and including seq. Must be called with r.mu held.
+func (r *Reliable) acknowledge(seq uint64) {
+	i := 0
+	for i < len(r.unacked) && r.unacked[i].seq <= seq {
+		i++
+	}
+	r.unacked = r.unacked[i:]
+}
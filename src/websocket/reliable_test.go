@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReliableResume(t *testing.T) {
+	h := NewHandler()
+	server := httptest.NewServer(h)
+	defer server.Close()
+	serverSide := NewReliable(0x10)
+	go func() {
+		for c := range h.Conns {
+			serverSide.Attach(c)
+		}
+	}()
+	clientSide := NewReliable(0x10)
+
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSide.Attach(c)
+	clientSide.Send(TextMessage, []byte("1"))
+	if _, payload := serverSide.Receive(); string(payload) != "1" {
+		t.Fatalf("Unexpected first message %q", payload)
+	}
+
+	// Lose the connection, and send while disconnected
+	c.conn.Close()
+	for attached := true; attached; {
+		time.Sleep(time.Millisecond)
+		clientSide.mu.Lock()
+		attached = clientSide.c != nil
+		clientSide.mu.Unlock()
+	}
+	clientSide.Send(TextMessage, []byte("2"))
+	clientSide.Send(BinaryMessage, []byte("3"))
+
+	if c, _, err = Dial(wsURL(server), ""); err != nil {
+		t.Fatal(err)
+	}
+	clientSide.Attach(c)
+	defer c.Close()
+	for _, expected := range []string{"2", "3"} {
+		if _, payload := serverSide.Receive(); string(payload) != expected {
+			t.Errorf("Expected message %q, got %q", expected, payload)
+		}
+	}
+	select {
+	case m := <-serverSide.in:
+		t.Errorf("Message received twice: %q", m.payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := clientSide.Unacked(); n != 0 {
+		t.Errorf("%v messages left unacknowledged", n)
+	}
+}
+
+func TestReliableBufferFull(t *testing.T) {
+	r := NewReliable(2)
+	r.Send(TextMessage, nil)
+	r.Send(TextMessage, nil)
+	if err := r.Send(TextMessage, nil); err != errReliableBufferFull {
+		t.Errorf("Expected errReliableBufferFull, got %v", err)
+	}
+}
+
+func TestReliableAckWhileQueueFull(t *testing.T) {
+	c := newIdleConn() // Nothing takes from Out
+	r := NewReliable(0x100)
+	r.Attach(c) // Queues the resume
+	for i := 1; i < cap(c.out); i++ {
+		r.Send(TextMessage, []byte("x"))
+	}
+	blocked := make(chan bool)
+	go func() {
+		r.Send(TextMessage, []byte("x"))
+		close(blocked)
+	}()
+	for r.Unacked() < cap(c.out) {
+		time.Sleep(time.Millisecond) // Until the send is numbered
+	}
+	ack := make([]byte, reliableHeaderLength)
+	ack[0] = reliableAck
+	binary.BigEndian.PutUint64(ack[1:], uint64(cap(c.out)-1))
+	processed := make(chan error)
+	go func() { processed <- r.process(c, ack) }()
+	select {
+	case <-processed:
+	case <-time.After(time.Second):
+		t.Fatal("Ack not processed while a send waits for the queue")
+	}
+	if n := r.Unacked(); n != 1 {
+		t.Errorf("Expected the blocked message left unacknowledged, got %v", n)
+	}
+	select {
+	case <-blocked:
+		t.Error("Send didn't wait for room in the queue")
+	default:
+	}
+	<-c.out
+	<-blocked
+}
@@ -0,0 +1,30 @@
+package websocket
+
+import (
+	"net/http"
+)
+
+// A response writer which passes its header to the ResponseHeader hook of
+// the handler just before it is written. The refusals of the handler all
+// call WriteHeader.
+type hookedWriter struct {
+	http.ResponseWriter
+	h *Handler
+}
+
+// Let the ResponseHeader hook of h see the responses written to w, if set
+func (h *Handler) hookResponse(w http.ResponseWriter) http.ResponseWriter {
+	if h.ResponseHeader == nil {
+		return w
+	}
+	return hookedWriter{w, h}
+}
+
+func (w hookedWriter) WriteHeader(status int) {
+	w.h.ResponseHeader(w.Header(), status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w hookedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
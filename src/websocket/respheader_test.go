@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResponseHeader(t *testing.T) {
+	h := NewHandler()
+	h.AllowedOrigins = []string{"http://localhost"}
+	statuses := make(chan int, 2)
+	h.ResponseHeader = func(header http.Header, status int) {
+		statuses <- status
+		header.Del("Server")
+		header.Del("X-Content-Type-Options")
+		header.Set("Server", "edge")
+	}
+	wrapped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "websocket/1.0")
+		h.ServeHTTP(w, r)
+	})
+	client, resp := handshake(t, wrapped, newHandshakeRequest())
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake refused: %v", resp.Status)
+	}
+	if server := resp.Header.Get("Server"); server != "edge" {
+		t.Errorf("Expected Server edge in the 101, got %q", server)
+	}
+	if status := <-statuses; status != http.StatusSwitchingProtocols {
+		t.Errorf("Hook called with %v, expected 101", status)
+	}
+	req := newHandshakeRequest()
+	req.Header.Set("Origin", "http://elsewhere")
+	_, resp = handshake(t, wrapped, req)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %v", resp.Status)
+	}
+	if server := resp.Header.Get("Server"); server != "edge" {
+		t.Errorf("Expected Server edge in the refusal, got %q", server)
+	}
+	if _, ok := resp.Header["X-Content-Type-Options"]; ok {
+		t.Error("Header removed by the hook was written")
+	}
+	if status := <-statuses; status != http.StatusForbidden {
+		t.Errorf("Hook called with %v, expected 403", status)
+	}
+}
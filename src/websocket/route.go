@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Routes incoming messages to separate handlers, by their type or by a
+// kind decoded from them. Each handler runs on its own goroutine and gets
+// its messages in order, so a slow handler, such as one for JSON commands,
+// doesn't hold up another, such as one for binary heartbeats. Messages are
+// read into memory before they are routed. Messages without a handler are
+// discarded.
+type Router struct {
+	Text   func(c *Conn, r io.Reader)
+	Binary func(c *Conn, r io.Reader)
+
+	// If not nil, called for every message to decode it into a kind, such
+	// as the "type" field of a JSON envelope, and a value. The value is
+	// passed to the handler of the kind in Kinds, if there is one, and the
+	// message to Text or Binary otherwise. An error closes the connection
+	// with 1003 (unsupported data).
+	Decode func(msgType int, payload []byte) (kind string, v interface{}, err error)
+	Kinds  map[string]func(c *Conn, v interface{})
+
+	// Number of messages which may wait for each handler while it is busy,
+	// before the routing blocks
+	Queue int
+}
+
+// Route the messages of the connection with rt, blocking until the
+// connection is closed and the handlers have returned. Takes over all
+// messages on the connection.
+func (c *Conn) Route(rt *Router) {
+	var wg sync.WaitGroup
+	queues := make(map[string]chan func())
+	dispatch := func(route string, call func()) {
+		q, ok := queues[route]
+		if !ok {
+			q = make(chan func(), rt.Queue)
+			queues[route] = q
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for call := range q {
+					call()
+				}
+			}()
+		}
+		q <- call
+	}
+	var rejected bool
+	for r := range c.In {
+		m := r.(*Message)
+		payload, err := ioutil.ReadAll(m)
+		if err != nil || rejected {
+			continue // Closing
+		}
+		if rt.Decode != nil {
+			kind, v, err := rt.Decode(m.Type, payload)
+			if err != nil {
+				Log.Println(err)
+				c.sendClose(newErrConnection(statusUnsupportedData, "Unsupported message"))
+				rejected = true
+				continue
+			}
+			if handle, ok := rt.Kinds[kind]; ok {
+				dispatch("kind:"+kind, func() { handle(c, v) })
+				continue
+			}
+		}
+		if m.Type == TextMessage && rt.Text != nil {
+			dispatch("text", func() { rt.Text(c, bytes.NewReader(payload)) })
+		} else if m.Type == BinaryMessage && rt.Binary != nil {
+			dispatch("binary", func() { rt.Binary(c, bytes.NewReader(payload)) })
+		}
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
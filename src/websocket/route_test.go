@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteByType(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	texts, binaries := make(chan string, 2), make(chan string, 2)
+	release := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		c.Route(&Router{
+			Text: func(c *Conn, r io.Reader) {
+				msg, _ := ioutil.ReadAll(r)
+				<-release
+				texts <- string(msg)
+			},
+			Binary: func(c *Conn, r io.Reader) {
+				msg, _ := ioutil.ReadAll(r)
+				binaries <- string(msg)
+			},
+		})
+		close(done)
+	}()
+	client.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	client.Write([]byte{0x82, 0x82, 0, 0, 0, 0, 'b', '1'})
+	client.Write([]byte{0x82, 0x82, 0, 0, 0, 0, 'b', '2'})
+	// The binary handler isn't held up by the busy text handler
+	for _, expected := range []string{"b1", "b2"} {
+		select {
+		case msg := <-binaries:
+			if msg != expected {
+				t.Errorf("Expected %q, got %q", expected, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Binary message not routed")
+		}
+	}
+	close(release)
+	if msg := <-texts; msg != "hi" {
+		t.Errorf("Expected %q, got %q", "hi", msg)
+	}
+	client.Close()
+	<-done
+}
+
+func TestRouteDecode(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	pings, texts := make(chan interface{}, 1), make(chan string, 1)
+	done := make(chan bool)
+	go func() {
+		c.Route(&Router{
+			// "kind:value"
+			Decode: func(msgType int, payload []byte) (kind string, v interface{}, err error) {
+				kind, value, ok := strings.Cut(string(payload), ":")
+				if !ok {
+					err = errors.New("Missing kind")
+				}
+				return kind, value, err
+			},
+			Kinds: map[string]func(c *Conn, v interface{}){
+				"ping": func(c *Conn, v interface{}) { pings <- v },
+			},
+			Text: func(c *Conn, r io.Reader) {
+				msg, _ := ioutil.ReadAll(r)
+				texts <- string(msg)
+			},
+		})
+		close(done)
+	}()
+	client.Write([]byte{0x81, 0x86, 0, 0, 0, 0, 'p', 'i', 'n', 'g', ':', '1'})
+	client.Write([]byte{0x81, 0x83, 0, 0, 0, 0, 'x', ':', '2'})
+	if v := <-pings; v != "1" {
+		t.Errorf("Expected %q, got %v", "1", v)
+	}
+	if msg := <-texts; msg != "x:2" {
+		t.Errorf("Expected %q, got %q", "x:2", msg)
+	}
+	client.Write([]byte{0x81, 0x81, 0, 0, 0, 0, '?'})
+	if reason := readShortFrame(t, client); reason != "\x03\xebUnsupported message" {
+		t.Errorf("Unexpected close frame payload %q", reason)
+	}
+	client.Close()
+	<-done
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// Default maximum size of a message read by a MessageScanner
+const defaultMaxMessageSize = 1 << 20
+
+var errMessageTooLarge = classify(ErrProtocol, "Message exceeds the maximum message size")
+
+// Reads the incoming messages of a connection one by one, like a
+// bufio.Scanner, for a loop without the iterator of Messages. Takes over
+// all messages on the connection.
+//
+//	s := websocket.NewMessageScanner(c)
+//	for s.Scan() {
+//		handle(s.Type(), s.Bytes())
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+type MessageScanner struct {
+	c       *Conn
+	max     int
+	msgType int
+	buf     []byte
+	err     error
+}
+
+func NewMessageScanner(c *Conn) *MessageScanner {
+	return &MessageScanner{c: c, max: defaultMaxMessageSize}
+}
+
+// Set the maximum size of a message, 1 MiB by default. A larger message
+// stops the scanning and closes the connection with status 1009.
+func (s *MessageScanner) SetMaxMessageSize(max int) {
+	s.max = max
+}
+
+// Wait for and read the next message. Returns false when the connection is
+// closed or an error occurred.
+func (s *MessageScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	r, ok := <-s.c.In
+	if !ok {
+		s.err = s.c.Err()
+		return false
+	}
+	m := r.(*Message)
+	s.msgType = m.Type
+	s.buf, s.err = ioutil.ReadAll(io.LimitReader(m, int64(s.max)+1))
+	if s.err == nil && len(s.buf) > s.max {
+		s.buf = nil
+		s.err = errMessageTooLarge
+		s.c.sendClose(newErrConnection(statusMessageTooBig, ""))
+	}
+	io.Copy(ioutil.Discard, m)
+	return s.err == nil
+}
+
+// Type of the latest message
+func (s *MessageScanner) Type() int {
+	return s.msgType
+}
+
+// Payload of the latest message. The slice is only valid until the next Scan.
+func (s *MessageScanner) Bytes() []byte {
+	return s.buf
+}
+
+// Payload of the latest message as a reader
+func (s *MessageScanner) Reader() io.Reader {
+	return bytes.NewReader(s.buf)
+}
+
+// The first error which stopped the scanning, or nil if the connection was
+// closed normally
+func (s *MessageScanner) Err() error {
+	return s.err
+}
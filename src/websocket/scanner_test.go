@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestMessageScanner(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Out <- bytes.NewBufferString("text")
+	c.Out <- &Message{Type: BinaryMessage, Reader: bytes.NewReader([]byte{1, 2})}
+	s := NewMessageScanner(c)
+	if !s.Scan() || s.Type() != TextMessage || string(s.Bytes()) != "text" {
+		t.Errorf("Unexpected first message: type %v, %q", s.Type(), s.Bytes())
+	}
+	if !s.Scan() || s.Type() != BinaryMessage || !bytes.Equal(s.Bytes(), []byte{1, 2}) {
+		t.Errorf("Unexpected second message: type %v, %v", s.Type(), s.Bytes())
+	}
+	c.Close()
+	if s.Scan() {
+		t.Error("Scan succeeded after close")
+	}
+	if s.Err() != nil {
+		t.Errorf("Unexpected error after normal close: %v", s.Err())
+	}
+}
+
+func TestMessageScannerTooLarge(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Out <- bytes.NewBufferString("12345")
+	s := NewMessageScanner(c)
+	s.SetMaxMessageSize(4)
+	if s.Scan() {
+		t.Error("Too large message scanned")
+	}
+	if s.Err() != errMessageTooLarge {
+		t.Errorf("Expected errMessageTooLarge, got %v", s.Err())
+	}
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"time"
+)
+
+// A message waiting to be sent by SendAfter
+type scheduledMessage struct {
+	*Message
+	timer *time.Timer
+}
+
+// Send a message after d, like Send. Returns cancel, which stops the
+// message from being sent and returns true, unless it already was queued or
+// the connection has closed. If sending fails, or the connection closes
+// before d, the Sent callback of the message is called with the error.
+func (c *Conn) SendAfter(d time.Duration, m *Message) (cancel func() bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != OPEN || c.closeSent {
+		return nil, errConnClosed
+	}
+	s := &scheduledMessage{Message: m}
+	// The timer is set before the callback can take c.mu
+	s.timer = time.AfterFunc(d, func() {
+		if !c.unschedule(s) {
+			return
+		}
+		if err := c.Send(m); err != nil && m.Sent != nil {
+			m.Sent(err)
+		}
+	})
+	if c.scheduled == nil {
+		c.scheduled = make(map[*scheduledMessage]bool)
+	}
+	c.scheduled[s] = true
+	cancel = func() bool {
+		if !c.unschedule(s) {
+			return false
+		}
+		s.timer.Stop()
+		return true
+	}
+	return
+}
+
+// Send a message at t, like SendAfter
+func (c *Conn) SendAt(t time.Time, m *Message) (cancel func() bool, err error) {
+	return c.SendAfter(time.Until(t), m)
+}
+
+// Forget a scheduled message, returns false if it already was
+func (c *Conn) unschedule(s *scheduledMessage) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ok = c.scheduled[s]
+	delete(c.scheduled, s)
+	return
+}
+
+// Stop the scheduled messages once the connection has closed, and report
+// them as failed
+func (c *Conn) cancelScheduled() {
+	c.mu.Lock()
+	scheduled := c.scheduled
+	c.scheduled = nil
+	c.mu.Unlock()
+	for s := range scheduled {
+		s.timer.Stop()
+		if s.Sent != nil {
+			s.Sent(errConnClosed)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSendAfter(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	start := time.Now()
+	c.SendAfter(30*time.Millisecond, &Message{Type: TextMessage, Reader: bytes.NewBufferString("Late")})
+	cancel, _ := c.SendAt(start.Add(10*time.Millisecond), &Message{Type: TextMessage, Reader: bytes.NewBufferString("Never")})
+	if !cancel() || cancel() {
+		t.Error("Cancel should succeed exactly once")
+	}
+	c.SendAt(start.Add(10*time.Millisecond), &Message{Type: TextMessage, Reader: bytes.NewBufferString("Soon")})
+	expectFrames(t, client, []byte{0x81, 0x04, 'S', 'o', 'o', 'n', 0x81, 0x04, 'L', 'a', 't', 'e'})
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Sent too early, after %v", elapsed)
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	c, client := newPipeConn()
+	sent := make(chan error, 1)
+	cancel, err := c.SendAfter(time.Hour, &Message{Type: TextMessage, Reader: bytes.NewBufferString("Never"), Sent: func(err error) { sent <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	c.Wait()
+	select {
+	case err := <-sent:
+		if err != errConnClosed {
+			t.Errorf("Expected errConnClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Scheduled message not cancelled on close")
+	}
+	if cancel() {
+		t.Error("Cancelled after close")
+	}
+	if _, err = c.SendAfter(time.Second, &Message{}); err != errConnClosed {
+		t.Errorf("Expected errConnClosed, got %v", err)
+	}
+}
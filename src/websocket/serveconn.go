@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Perform the server side of the handshake on conn, an already accepted
+// connection, without net/http serving it. The handshake request is read
+// from conn, and upgraded with the options of h, a zero Handler if nil. Its
+// Conns and Events are not used, the started connection is returned
+// instead. If the handshake fails, the response is written, conn is closed
+// and the error matches ErrHandshake. Set a deadline on conn to bound the
+// handshake, and clear it once the connection is returned.
+func ServeConn(conn net.Conn, h *Handler) (c *Conn, err error) {
+	if h == nil {
+		h = &Handler{}
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	r, err := http.ReadRequest(rw.Reader)
+	if err != nil {
+		conn.Close()
+		err = wrapError(ErrHandshake, err)
+		return
+	}
+	r.RemoteAddr = conn.RemoteAddr().String()
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		r.TLS = &state
+	}
+	var failure error
+	r = r.WithContext(context.WithValue(r.Context(), handshakeFailureKey{}, &failure))
+	if c = h.upgrade(&connResponse{conn: conn, rw: rw, header: make(http.Header)}, r); c == nil {
+		rw.Flush()
+		conn.Close()
+		if err = wrapError(ErrHandshake, failure); err == nil {
+			err = errMalformedClientHandshake
+		}
+		return
+	}
+	c.start()
+	return
+}
+
+// The context key of where ServeConn keeps the reason its handshake failed
+type handshakeFailureKey struct{}
+
+// Keep the reason the handshake of r failed, if it is served by ServeConn
+func recordFailure(r *http.Request, err error) {
+	if failure, ok := r.Context().Value(handshakeFailureKey{}).(*error); ok {
+		*failure = err
+	}
+}
+
+// A response written straight to the connection of ServeConn. A refusal
+// ends with the connection being closed, which delimits its body.
+type connResponse struct {
+	conn        net.Conn
+	rw          *bufio.ReadWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *connResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *connResponse) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.header.Set("Connection", "close")
+	fmt.Fprintf(w.rw, "HTTP/1.1 %03d %s\r\n", status, http.StatusText(status))
+	w.header.Write(w.rw)
+	w.rw.WriteString("\r\n")
+}
+
+func (w *connResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.rw.Write(p)
+}
+
+func (w *connResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, w.rw, nil
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Serve a handshake over a pipe, and read the response of req
+func serveConnPipe(t *testing.T, h *Handler, req *http.Request) (client net.Conn, resp *http.Response, c *Conn, err error) {
+	client, server := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		c, err = ServeConn(server, h)
+		close(done)
+	}()
+	go req.Write(client)
+	resp, rerr := http.ReadResponse(bufio.NewReaderSize(oneByteReader{client}, 16), req)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		ioutil.ReadAll(resp.Body)
+	}
+	<-done
+	return
+}
+
+func TestServeConn(t *testing.T) {
+	client, resp, c, err := serveConnPipe(t, nil, newHandshakeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v", resp.Status)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept %q", accept)
+	}
+	client.Write([]byte{0x81, 0x82, 0x00, 0x00, 0x00, 0x00, 'h', 'i'})
+	msg := <-c.In
+	if b, _ := ioutil.ReadAll(msg); string(b) != "hi" {
+		t.Errorf("Expected hi, got %q", b)
+	}
+	c.Out <- strings.NewReader("yo")
+	if payload := readShortFrame(t, client); payload != "yo" {
+		t.Errorf("Expected yo, got %q", payload)
+	}
+}
+
+func TestServeConnRefused(t *testing.T) {
+	h := NewHandler()
+	h.AllowedOrigins = []string{"https://example.com"}
+	client, resp, c, err := serveConnPipe(t, h, newHandshakeRequest())
+	defer client.Close()
+	if c != nil {
+		t.Error("Refused handshake returned a connection")
+	}
+	if !errors.Is(err, errOriginNotAllowed) || !errors.Is(err, ErrHandshake) {
+		t.Errorf("Expected %v, got %v", errOriginNotAllowed, err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", resp.Status)
+	}
+	if !resp.Close {
+		t.Error("Refusal doesn't close the connection")
+	}
+}
+
+func TestServeConnMalformedRequest(t *testing.T) {
+	client, server := net.Pipe()
+	go client.Write([]byte("garbage\r\n\r\n"))
+	if _, err := ServeConn(server, nil); !errors.Is(err, ErrHandshake) {
+		t.Errorf("Expected a handshake error, got %v", err)
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("Connection not closed")
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A standalone websocket server, for deployments where the websocket
+// endpoint is all there is to serve. Every connection is passed to Handle.
+type Server struct {
+	Addr string // TCP address to listen on, ":http" or ":https" if empty
+	Path string // Only requests for this path are upgraded, any if empty
+
+	// Called in its own goroutine for every new connection. The connection
+	// is not closed when Handle returns.
+	Handle func(c *Conn)
+
+	// Options of the upgrade, such as Buffers and Budget, a zero Handler if
+	// nil. Its Conns and Events are not used.
+	Handler *Handler
+
+	// Used by ListenAndServeTLS, may be nil if certificate files are given.
+	// Set ClientAuth and ClientCAs to require client certificates.
+	TLSConfig *tls.Config
+
+	// Maximum duration for reading the handshake request, none if zero
+	HandshakeTimeout time.Duration
+
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+// Listen on Addr and serve websocket connections until Close is called
+func (s *Server) ListenAndServe() error {
+	return s.httpServer().ListenAndServe()
+}
+
+// Listen on Addr and serve websocket connections over TLS until Close is
+// called. The certificate and key files are only needed if TLSConfig has
+// no certificate.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	return s.httpServer().ListenAndServeTLS(certFile, keyFile)
+}
+
+// Serve websocket connections accepted on l until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	return s.httpServer().Serve(l)
+}
+
+// Serve websocket connections over TLS accepted on l until Close is called
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	return s.httpServer().ServeTLS(l, certFile, keyFile)
+}
+
+// Stop listening. Connections already upgraded are left open.
+func (s *Server) Close() error {
+	return s.httpServer().Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Path != "" && r.URL.Path != s.Path {
+		http.NotFound(w, r)
+		return
+	}
+	h := s.Handler
+	if h == nil {
+		h = &Handler{}
+	}
+	c := h.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	c.start()
+	s.Handle(c)
+}
+
+// The underlying HTTP server, created on first use
+func (s *Server) httpServer() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv == nil {
+		s.srv = &http.Server{
+			Addr:              s.Addr,
+			Handler:           s,
+			TLSConfig:         s.TLSConfig,
+			ReadHeaderTimeout: s.HandshakeTimeout,
+		}
+	}
+	return s.srv
+}
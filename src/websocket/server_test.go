@@ -3,31 +3,45 @@
 package websocket
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
 
 func setupServerAndHandshake(t *testing.T) (h *Handler, client net.Conn) {
 	h = NewHandler()
-	http.Handle("/myconn", h)
-	go http.ListenAndServe(":8080", nil)
-	var (
-		req *http.Request
-		err error
-	)
-	req, err = http.NewRequest("GET", "/myconn", nil)
+	client, resp := handshake(t, h, newHandshakeRequest())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Error("Could not read server handshake")
+		t.FailNow()
+	}
+	return
+}
+
+// A valid handshake request from a client
+func newHandshakeRequest() (req *http.Request) {
+	req, _ = http.NewRequest("GET", "/myconn", nil)
 	req.Header.Set("Upgrade", "websocket")
 	req.Header.Set("Connection", "Upgrade")
 	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
 	req.Header.Set("Origin", "http://localhost")
 	req.Header.Set("Sec-WebSocket-Version", "13")
-	client, err = net.Dial("tcp", "localhost:8080")
+	return
+}
+
+// Start a server with handler h, send req and read the handshake response.
+func handshake(t *testing.T, h http.Handler, req *http.Request) (client net.Conn, resp *http.Response) {
+	server := httptest.NewServer(h)
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
 	if err != nil {
 		t.Error("Couldn't open TCP connection")
 		t.FailNow()
@@ -37,14 +51,23 @@ func setupServerAndHandshake(t *testing.T) (h *Handler, client net.Conn) {
 		t.Error("Could not write request")
 		t.FailNow()
 	}
-	_, err = io.CopyN(ioutil.Discard, client, 194) // Fixed handshake length
+	// Read the response byte by byte, so that no frame data is consumed
+	resp, err = http.ReadResponse(bufio.NewReaderSize(oneByteReader{client}, 16), req)
 	if err != nil {
-		t.Error("Could not discard server handshake")
+		t.Error("Could not read server handshake")
 		t.FailNow()
 	}
 	return
 }
 
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:1])
+}
+
 // Check that the server closes the underlying TCP connection after client requests it.
 func TestDirectClose(t *testing.T) {
 	var (
@@ -75,3 +98,500 @@ func TestDirectClose(t *testing.T) {
 		t.Errorf("Server didn't close the TCP connection after closing frame (%v)", err)
 	}
 }
+
+func TestUnsupportedVersion(t *testing.T) {
+	req := newHandshakeRequest()
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	_, resp := handshake(t, NewHandler(), req)
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("Expected status 426, got %v", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		t.Errorf("Expected supported version 13, got %q", v)
+	}
+}
+
+func TestMalformedHandshake(t *testing.T) {
+	req := newHandshakeRequest()
+	req.Header.Del("Sec-WebSocket-Key")
+	_, resp := handshake(t, NewHandler(), req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %v", resp.StatusCode)
+	}
+}
+
+func TestConnectionHeaderVariants(t *testing.T) {
+	variants := [][]string{
+		{"Upgrade"},
+		{"upgrade"},
+		{"keep-alive, Upgrade"},
+		{"Upgrade, keep-alive"},
+		{"keep-alive,Upgrade"},
+		{" keep-alive ,  upgrade "},
+		{"keep-alive", "Upgrade"},
+	}
+	for _, v := range variants {
+		req := newHandshakeRequest()
+		req.Header["Connection"] = v
+		if _, err := wsClientHandshake(req, Strict); err != nil {
+			t.Errorf("Connection header %q rejected: %v", v, err)
+		}
+	}
+	for _, v := range [][]string{{"keep-alive"}, {"Upgrades"}, {""}, nil} {
+		req := newHandshakeRequest()
+		req.Header["Connection"] = v
+		if _, err := wsClientHandshake(req, Strict); err == nil {
+			t.Errorf("Connection header %q accepted", v)
+		}
+	}
+}
+
+func TestMultiValuedVersion(t *testing.T) {
+	variants := [][]string{{"13"}, {"8, 13"}, {"8", "13"}, {"13, 8"}}
+	for _, v := range variants {
+		req := newHandshakeRequest()
+		req.Header["Sec-Websocket-Version"] = v
+		if _, err := wsClientHandshake(req, Strict); err != nil {
+			t.Errorf("Sec-WebSocket-Version %q rejected: %v", v, err)
+		}
+	}
+	for _, v := range [][]string{{"8"}, {"8, 7"}, {"8", "abc"}, nil} {
+		req := newHandshakeRequest()
+		req.Header["Sec-Websocket-Version"] = v
+		if _, err := wsClientHandshake(req, Strict); err != errUnsupportedVersion {
+			t.Errorf("Sec-WebSocket-Version %q not rejected: %v", v, err)
+		}
+	}
+}
+
+func TestRepeatedSecWebSocketKey(t *testing.T) {
+	req := newHandshakeRequest()
+	req.Header.Add("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if _, err := wsClientHandshake(req, Strict); err != errMalformedSecWSKey {
+		t.Errorf("Repeated Sec-WebSocket-Key not rejected: %v", err)
+	}
+}
+
+func TestHeaderTokens(t *testing.T) {
+	header := http.Header{"Sec-Websocket-Protocol": {"chat, superchat", " v2.chat ", ""}}
+	expected := []string{"chat", "superchat", "v2.chat"}
+	if tokens := headerTokens(header, "Sec-WebSocket-Protocol"); !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %q, got %q", expected, tokens)
+	}
+}
+
+func TestFailedHandshakeNotHijacked(t *testing.T) {
+	req := newHandshakeRequest()
+	req.Header.Del("Upgrade")
+	w := httptest.NewRecorder() // Does not implement http.Hijacker
+	NewHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %v", w.Code)
+	}
+}
+
+func TestUpgradeErrorHook(t *testing.T) {
+	var hookErr error
+	h := NewHandler()
+	h.OnUpgradeError = func(r *http.Request, err error) {
+		hookErr = err
+	}
+	w := httptest.NewRecorder() // Does not implement http.Hijacker
+	h.ServeHTTP(w, newHandshakeRequest())
+	if hookErr != ErrNotHijackable {
+		t.Errorf("Expected ErrNotHijackable in hook, got %v", hookErr)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %v", w.Code)
+	}
+}
+
+func TestHandshakeErrorHook(t *testing.T) {
+	type refusal struct {
+		err    error
+		status int
+	}
+	var refused []refusal
+	h := NewHandler()
+	h.AllowedOrigins = []string{"https://example.com"}
+	h.OnHandshakeError = func(r *http.Request, err error, status int) {
+		if r.URL.Path != "/myconn" {
+			t.Errorf("Unexpected request %v", r.URL)
+		}
+		refused = append(refused, refusal{err, status})
+	}
+	badVersion := newHandshakeRequest()
+	badVersion.Header.Set("Sec-WebSocket-Version", "8")
+	for _, req := range []*http.Request{badVersion, newHandshakeRequest()} {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.Drain()
+	h.ServeHTTP(httptest.NewRecorder(), newHandshakeRequest())
+	expected := []refusal{
+		{errUnsupportedVersion, http.StatusUpgradeRequired},
+		{errOriginNotAllowed, http.StatusForbidden},
+		{errOriginNotAllowed, http.StatusForbidden}, // Checked before draining
+	}
+	if len(refused) != len(expected) {
+		t.Fatalf("Unexpected refusals: %v", refused)
+	}
+	for i := range expected {
+		if refused[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], refused[i])
+		}
+	}
+	h.AllowedOrigins = nil
+	h.ServeHTTP(httptest.NewRecorder(), newHandshakeRequest())
+	if last := refused[len(refused)-1]; last.err != errDraining || last.status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the draining refusal, got %v", last)
+	}
+}
+
+// A middleware response writer which only exposes http.ResponseWriter
+type wrappedWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestUpgradeThroughWrappedWriter(t *testing.T) {
+	h := NewHandler()
+	wrapped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(wrappedWriter{w}, r)
+	})
+	if _, resp := handshake(t, wrapped, newHandshakeRequest()); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected status 101, got %v", resp.StatusCode)
+	}
+}
+
+// Check that control frames written by the application reach the client.
+func TestWriteControl(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	c := <-h.Conns
+	if err := c.WriteControl(opCodePong, []byte("hi"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	closePayload := []byte{0x03, 0xE9, 'b', 'y', 'e'}
+	if err := c.WriteControl(opCodeConnectionClose, closePayload, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{0x8A, 0x02, 'h', 'i', 0x88, 0x05}, closePayload...)
+	buf := make([]byte, len(expected))
+	client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("Short read: %v", err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("Didn't recieve the control frames: %X", buf)
+	}
+	if err := c.WriteControl(opCodePing, nil, time.Time{}); err != errConnClosed {
+		t.Errorf("Expected %v after close, got %v", errConnClosed, err)
+	}
+}
+
+func TestWriteControlInvalid(t *testing.T) {
+	c := newIdleConn()
+	for _, tc := range []struct {
+		opCode  byte
+		payload []byte
+	}{
+		{opCodeText, nil},
+		{opCodePing, make([]byte, 126)},
+		{opCodeConnectionClose, []byte{0x03}},
+		{opCodeConnectionClose, []byte{0x03, 0xED}},       // 1005
+		{opCodeConnectionClose, []byte{0x03, 0xEE}},       // 1006
+		{opCodeConnectionClose, []byte{0x03, 0xE7}},       // 999
+		{opCodeConnectionClose, []byte{0x03, 0xE8, 0xFF}}, // Invalid UTF-8
+	} {
+		if err := c.WriteControl(tc.opCode, tc.payload, time.Time{}); err != errInvalidControlFrame {
+			t.Errorf("Expected %v for opcode %X, got %v", errInvalidControlFrame, tc.opCode, err)
+		}
+	}
+}
+
+// Check that a dropped TCP connection is reported as an abnormal closure.
+func TestAbnormalClosure(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	c := <-h.Conns
+	client.Close()
+	for range c.In {
+	}
+	closeErr, ok := c.Err().(*CloseError)
+	if !ok || closeErr.Code != statusAbnormalClosure {
+		t.Errorf("Expected a CloseError with code 1006, got %v", c.Err())
+	}
+}
+
+// Check that a closing handshake leaves no error.
+func TestCleanClosureNoError(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	c := <-h.Conns
+	client.Write([]byte{0x88, 0x80, 0x05, 0x06, 0x07, 0x08})
+	for range c.In {
+	}
+	if c.Err() != nil {
+		t.Errorf("Expected no error, got %v", c.Err())
+	}
+}
+
+// Use the connection from several goroutines while it closes, run with -race.
+func TestConcurrentClose(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	defer client.Close()
+	c := <-h.Conns
+	go io.Copy(ioutil.Discard, client)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			c.Ping()
+			c.SendText("Hello")
+			c.Close()
+			c.State()
+			done <- true
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if c.State() == OPEN {
+		t.Error("Connection still open after Close")
+	}
+}
+
+// Start a server side connection over a synchronous pipe
+func newPipeConn() (c *Conn, client net.Conn) {
+	server, client := net.Pipe()
+	c = newConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), true, nil)
+	c.start()
+	return
+}
+
+// The stacks of the goroutines started by the package rather than by the
+// tests, such as the loops of a connection, by goroutine ID
+func packageGoroutines() (stacks map[string]string) {
+	buf := make([]byte, 1<<16)
+	for n := runtime.Stack(buf, true); n == len(buf); n = runtime.Stack(buf, true) {
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks = make(map[string]string)
+	for _, g := range strings.Split(string(bytes.TrimRight(buf, "\x00")), "\n\n") {
+		i := strings.Index(g, "\ncreated by websocket.")
+		if i < 0 {
+			continue
+		}
+		if creator := g[i+1:]; !strings.Contains(creator, "_test.go:") {
+			stacks[strings.Fields(g)[1]] = g
+		}
+	}
+	return
+}
+
+// Wait for the goroutines started by the package since before to exit,
+// the exiting goroutines may not be done yet, and Out is drained for up to
+// closeTimeout. Reports those left over.
+func waitForGoroutines(t *testing.T, before map[string]string) {
+	deadline := time.Now().Add(closeTimeout + time.Second)
+	for {
+		var leaked []string
+		for id, stack := range packageGoroutines() {
+			if _, ok := before[id]; !ok {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("Leaked %v goroutines:\n\n%v", len(leaked), strings.Join(leaked, "\n\n"))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Check that a connection dropped in the middle of everything releases
+// all of its goroutines and users.
+func TestWaitReleasesGoroutines(t *testing.T) {
+	before := packageGoroutines()
+	c, client := newPipeConn()
+	// An unfinished message which is never read
+	client.Write([]byte{0x01, 0x83, 0x00, 0x00, 0x00, 0x00, 'H', 'e', 'l'})
+	// Outgoing messages which are never read by the client
+	for i := 0; i < cap(c.out); i++ {
+		c.Out <- bytes.NewBufferString("Hello")
+	}
+	sent := make(chan error)
+	go func() {
+		// Eventually blocks, as nothing is sent
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = c.SendText("Hello")
+		}
+		sent <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	if _, ok := c.Wait().(*CloseError); !ok {
+		t.Errorf("Expected a CloseError, got %v", c.Err())
+	}
+	if err := <-sent; err != errConnClosed {
+		t.Errorf("Expected %v from a blocked sender, got %v", errConnClosed, err)
+	}
+	if err := c.SendText("Hello"); err != errConnClosed {
+		t.Errorf("Expected %v after the connection ended, got %v", errConnClosed, err)
+	}
+	for range c.In {
+	}
+	waitForGoroutines(t, before)
+}
+
+// Check that senders on Out aren't blocked once the connection is closed,
+// although nothing takes the messages anymore.
+func TestOutAfterClose(t *testing.T) {
+	c, client := newPipeConn()
+	go io.Copy(ioutil.Discard, client)
+	c.Close()
+	client.Close()
+	c.Wait()
+	failed := make(chan error, 2*cap(c.out))
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 2*cap(c.out); i++ {
+			c.Out <- &Message{Type: TextMessage, Reader: bytes.NewBufferString("Hello"), Sent: func(err error) {
+				failed <- err
+			}}
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Sender on Out blocked after the connection closed")
+	}
+	for i := 0; i < 2*cap(c.out); i++ {
+		if err := <-failed; err != errConnClosed {
+			t.Fatalf("Expected %v, got %v", errConnClosed, err)
+		}
+	}
+}
+
+// Check that a closing handshake the other end-point never completes
+// times out.
+func TestWaitCloseTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Waits for the close timeout")
+	}
+	before := packageGoroutines()
+	c, client := newPipeConn()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+	c.Close()
+	c.Wait()
+	if c.State() != CLOSED || c.Cleanly() {
+		t.Error("Connection not closed uncleanly after the close timeout")
+	}
+	client.Close()
+	waitForGoroutines(t, before)
+}
+
+// Close many times, concurrently with the close frame of the client.
+func TestCloseIdempotent(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	defer client.Close()
+	c := <-h.Conns
+	go io.Copy(ioutil.Discard, client)
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			errs <- c.Close()
+		}()
+	}
+	client.Write([]byte{0x88, 0x80, 0x05, 0x06, 0x07, 0x08})
+	first := 0
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err == nil {
+			first++
+		} else if err != errConnClosed {
+			t.Errorf("Expected %v, got %v", errConnClosed, err)
+		}
+	}
+	if first > 1 {
+		t.Errorf("%v calls closed the connection", first)
+	}
+	if err := c.Close(); err != errConnClosed {
+		t.Errorf("Expected %v after close, got %v", errConnClosed, err)
+	}
+}
+
+func TestCloseAfterDrop(t *testing.T) {
+	c, client := newPipeConn()
+	client.Close()
+	c.Wait()
+	if _, ok := c.Close().(*CloseError); !ok {
+		t.Errorf("Expected a CloseError, got %v", c.Close())
+	}
+}
+
+// Check that an unread message doesn't stall the connection.
+func TestAbandonedMessage(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.SetAbandonTimeout(20 * time.Millisecond)
+	go client.Write([]byte{
+		0x81, 0x85, 0x00, 0x00, 0x00, 0x00, 'H', 'e', 'l', 'l', 'o',
+		0x81, 0x85, 0x00, 0x00, 0x00, 0x00, 'W', 'o', 'r', 'l', 'd',
+	})
+	abandoned := <-c.In
+	select {
+	case m := <-c.In:
+		if msg, _ := ioutil.ReadAll(m); string(msg) != "World" {
+			t.Errorf("Unexpected message: %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Connection stalled by an unread message")
+	}
+	// The next message doesn't wait for it, so it may not be abandoned yet
+	time.Sleep(50 * time.Millisecond)
+	if _, err := ioutil.ReadAll(abandoned); err != errMessageAbandoned {
+		t.Errorf("Expected %v, got %v", errMessageAbandoned, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Path: "/myconn", Handle: func(c *Conn) {
+		for r := range c.In {
+			buf, _ := ioutil.ReadAll(r)
+			c.Out <- bytes.NewBuffer(buf)
+		}
+	}}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	c, _, err := Dial("ws://"+l.Addr().String()+"/myconn", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Out <- bytes.NewBufferString("Hello")
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "Hello" {
+		t.Errorf("Echoed message mismatch: %q", msg)
+	}
+	_, resp, err := Dial("ws://"+l.Addr().String()+"/other", "")
+	if err != errHandshakeRefused || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for another path, got %v", err)
+	}
+	s.Close()
+	if err = <-served; err != http.ErrServerClosed {
+		t.Errorf("Expected http.ErrServerClosed, got %v", err)
+	}
+	if c.State() != OPEN {
+		t.Error("Connection closed with the server")
+	}
+}
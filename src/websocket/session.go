@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Name of the query parameter carrying the resumption token
+const resumeParameter = "resume"
+
+// Length of resumption tokens in bytes, before encoding
+const resumeTokenLength = 16
+
+// Server side state which survives reconnects of its client.
+// Messages are sent and received through the embedded reliability layer.
+type Session struct {
+	*Reliable
+	Token string      // The resumption token of the session
+	Value interface{} // Free to use for the application, e.g. subscriptions
+	done  chan bool
+	timer *time.Timer // Expires the session while it is detached
+}
+
+// Closed when the session has expired
+func (s *Session) Done() <-chan bool {
+	return s.done
+}
+
+// A websocket handler where every client gets a session. The resumption
+// token of the session is sent as a text message as soon as the connection
+// is open, before any message of the reliability layer. A client which
+// reconnects with the token in the "resume" query parameter within TTL
+// continues its session, otherwise a new session is created.
+type SessionHandler struct {
+	Sessions   chan *Session // New sessions
+	TTL        time.Duration // How long a detached session is kept
+	BufferSize int           // Unacknowledged messages kept per session
+
+	handler  Handler
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionHandler(ttl time.Duration, bufferSize int) (sh *SessionHandler) {
+	sh = &SessionHandler{
+		Sessions:   make(chan *Session, 0x10),
+		TTL:        ttl,
+		BufferSize: bufferSize,
+		sessions:   make(map[string]*Session),
+	}
+	return
+}
+
+func (sh *SessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := sh.handler.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	c.start()
+	s, resumed := sh.resume(r.URL.Query().Get(resumeParameter))
+	if !resumed {
+		var err error
+		if s, err = sh.newSession(); err != nil {
+			Log.Println(err)
+			c.Close()
+			return
+		}
+	}
+	c.enqueue(bytes.NewBufferString(s.Token))
+	s.Attach(c)
+	if !resumed {
+		sh.Sessions <- s
+	}
+}
+
+// Find the session for a token, and stop it from expiring
+func (sh *SessionHandler) resume(token string) (s *Session, ok bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if s, ok = sh.sessions[token]; ok && s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return
+}
+
+func (sh *SessionHandler) newSession() (s *Session, err error) {
+	token := make([]byte, resumeTokenLength)
+	if _, err = io.ReadFull(rand.Reader, token); err != nil {
+		return
+	}
+	s = &Session{
+		Reliable: NewReliable(sh.BufferSize),
+		Token:    base64.RawURLEncoding.EncodeToString(token),
+		done:     make(chan bool),
+	}
+	s.onDetach = func(c *Conn) {
+		sh.detached(s)
+	}
+	sh.mu.Lock()
+	sh.sessions[s.Token] = s
+	sh.mu.Unlock()
+	return
+}
+
+// Start the expiry timer of a session which lost its connection
+func (sh *SessionHandler) detached(s *Session) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if s.timer != nil {
+		return
+	}
+	s.timer = time.AfterFunc(sh.TTL, func() {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		if sh.sessions[s.Token] == s && s.timer != nil {
+			delete(sh.sessions, s.Token)
+			close(s.done)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Connect to a session handler, and return the resumption token
+func dialSession(t *testing.T, urlStr string) (c *Conn, token string) {
+	c, _, err := Dial(urlStr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(<-c.In)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = string(b)
+	return
+}
+
+func TestSessionResume(t *testing.T) {
+	sh := NewSessionHandler(time.Minute, 0x10)
+	server := httptest.NewServer(sh)
+	defer server.Close()
+	client := NewReliable(0x10)
+	c, token := dialSession(t, wsURL(server))
+	client.Attach(c)
+	s := <-sh.Sessions
+	if s.Token != token {
+		t.Fatalf("Token mismatch %q %q", s.Token, token)
+	}
+
+	// The server sends while the client is away
+	c.conn.Close()
+	s.Send(TextMessage, []byte("While you were away"))
+
+	c, resumedToken := dialSession(t, wsURL(server)+"?resume="+token)
+	defer c.Close()
+	if resumedToken != token {
+		t.Fatalf("Session not resumed, new token %q", resumedToken)
+	}
+	client.Attach(c)
+	if _, payload := client.Receive(); string(payload) != "While you were away" {
+		t.Errorf("Unexpected message %q", payload)
+	}
+	select {
+	case s = <-sh.Sessions:
+		t.Error("New session created on resume")
+	default:
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	sh := NewSessionHandler(10*time.Millisecond, 0x10)
+	server := httptest.NewServer(sh)
+	defer server.Close()
+	c, token := dialSession(t, wsURL(server))
+	s := <-sh.Sessions
+	c.conn.Close()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Session did not expire")
+	}
+	c, newToken := dialSession(t, wsURL(server)+"?resume="+token)
+	defer c.Close()
+	if newToken == token {
+		t.Error("Expired session resumed")
+	}
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"io"
+	"sync"
+)
+
+// Size of the chunks a shared source is read in
+const sharedChunkSize = 0x1000
+
+// Chunks of shared sources, reused once every reader has passed them
+var sharedChunks sync.Pool // Of *[]byte
+
+// A source read once and streamed to several readers, such as the message
+// of BroadcastFrom. It is read in chunks on demand, by whichever reader
+// gets ahead, and each chunk is returned to the pool once every reader has
+// passed it or has been closed.
+type sharedSource struct {
+	mu      sync.Mutex
+	r       io.Reader
+	err     error          // From r, once it ended or failed
+	chunks  []*sharedChunk // Read so far, released ones have a nil buf
+	readers int            // Readers not yet closed
+	sealed  bool           // No more readers are added
+}
+
+// A chunk of a shared source, with the number of readers which still need
+// it. While readers may be added, the source holds a reference of its own.
+type sharedChunk struct {
+	buf  *[]byte
+	n    int
+	refs int
+}
+
+// A reader of a shared source, with its own position
+type sharedReader struct {
+	src    *sharedSource
+	chunk  int // Index of the current chunk
+	off    int // Position within the current chunk
+	closed bool
+}
+
+func newSharedSource(r io.Reader) *sharedSource {
+	return &sharedSource{r: r}
+}
+
+// Add a reader, from the start of the source. Must not be called once the
+// source is sealed.
+func (s *sharedSource) reader() *sharedReader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readers++
+	for _, c := range s.chunks {
+		c.refs++
+	}
+	return &sharedReader{src: s}
+}
+
+// Stop adding readers, and drop the reference of the source to the chunks
+func (s *sharedSource) seal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = true
+	for _, c := range s.chunks {
+		s.unref(c)
+	}
+}
+
+// Read the next chunk from r. Called with mu held.
+func (s *sharedSource) readChunk() {
+	buf, _ := sharedChunks.Get().(*[]byte)
+	if buf == nil {
+		b := make([]byte, sharedChunkSize)
+		buf = &b
+	}
+	n, err := io.ReadFull(s.r, *buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	s.err = err
+	if n == 0 {
+		sharedChunks.Put(buf)
+		return
+	}
+	refs := s.readers
+	if !s.sealed {
+		refs++
+	}
+	s.chunks = append(s.chunks, &sharedChunk{buf: buf, n: n, refs: refs})
+}
+
+// Drop a reference to c, returning it to the pool if it was the last.
+// Called with mu held.
+func (s *sharedSource) unref(c *sharedChunk) {
+	if c.refs--; c.refs == 0 {
+		sharedChunks.Put(c.buf)
+		c.buf = nil
+	}
+}
+
+func (sr *sharedReader) Read(p []byte) (n int, err error) {
+	s := sr.src
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sr.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if sr.chunk == len(s.chunks) {
+		if s.err == nil {
+			s.readChunk()
+		}
+		if sr.chunk == len(s.chunks) {
+			return 0, s.err
+		}
+	}
+	c := s.chunks[sr.chunk]
+	n = copy(p, (*c.buf)[sr.off:c.n])
+	if sr.off += n; sr.off == c.n {
+		s.unref(c)
+		sr.chunk, sr.off = sr.chunk+1, 0
+	}
+	return
+}
+
+// Release the chunks the reader hasn't passed yet. Safe to call more than
+// once.
+func (sr *sharedReader) Close() error {
+	s := sr.src
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sr.closed {
+		return nil
+	}
+	sr.closed = true
+	s.readers--
+	for _, c := range s.chunks[sr.chunk:] {
+		s.unref(c)
+	}
+	return nil
+}
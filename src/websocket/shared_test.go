@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSharedSource(t *testing.T) {
+	payload := strings.Repeat("x", 2*sharedChunkSize+10)
+	s := newSharedSource(strings.NewReader(payload))
+	a, b := s.reader(), s.reader()
+	s.seal()
+	if data, err := ioutil.ReadAll(a); err != nil || string(data) != payload {
+		t.Fatalf("Unexpected read of %v bytes (%v)", len(data), err)
+	}
+	if s.chunks[0].buf == nil {
+		t.Error("Chunk released before every reader passed it")
+	}
+	io.CopyN(ioutil.Discard, b, sharedChunkSize)
+	if s.chunks[0].buf != nil {
+		t.Error("Chunk not released once every reader passed it")
+	}
+	b.Close()
+	for i, c := range s.chunks {
+		if c.buf != nil {
+			t.Errorf("Chunk %v not released after closing", i)
+		}
+	}
+}
+
+func TestHubBroadcastFrom(t *testing.T) {
+	h := NewHub(2)
+	defer h.Close()
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		c, client := newPipeConn()
+		defer client.Close()
+		h.Subscribe(c)
+		clients = append(clients, client)
+	}
+	payload := strings.Repeat("y", 300)
+	if result := h.BroadcastFrom(TextMessage, strings.NewReader(payload)); result.Sent != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	for _, client := range clients {
+		var got bytes.Buffer
+		for got.Len() < len(payload) {
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(client, header); err != nil {
+				t.Fatal(err)
+			}
+			length := int64(header[1])
+			if length == 0x7e {
+				ext := make([]byte, 2)
+				io.ReadFull(client, ext)
+				length = int64(binary.BigEndian.Uint16(ext))
+			}
+			io.CopyN(&got, client, length)
+		}
+		if got.String() != payload {
+			t.Errorf("Unexpected payload of %v bytes", got.Len())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffers of SharedBuffer, reused once released
+var sharedBuffers sync.Pool // Of *[]byte
+
+// A pooled payload which can sit in the send queues of many connections at
+// once, without a copy per connection, see Hub.BroadcastShared and
+// Conn.SendShared. It is reference counted, every queued message holding a
+// reference until it has been written or has failed, and goes back to the
+// pool once the last reference is released.
+type SharedBuffer struct {
+	buf  *[]byte
+	refs int32
+}
+
+// A shared buffer of size bytes, with one reference, the caller's. Its
+// contents are undefined until written through Bytes.
+func NewSharedBuffer(size int) (b *SharedBuffer) {
+	buf, _ := sharedBuffers.Get().(*[]byte)
+	if buf == nil || cap(*buf) < size {
+		s := make([]byte, size)
+		buf = &s
+	}
+	*buf = (*buf)[:size]
+	return &SharedBuffer{buf: buf, refs: 1}
+}
+
+// The payload, to be filled in before the buffer is sent, and not to be
+// modified afterwards
+func (b *SharedBuffer) Bytes() []byte {
+	return *b.buf
+}
+
+// Take another reference
+func (b *SharedBuffer) Retain() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Drop a reference. The buffer must not be used once the caller has
+// released its references.
+func (b *SharedBuffer) Release() {
+	switch refs := atomic.AddInt32(&b.refs, -1); {
+	case refs == 0:
+		sharedBuffers.Put(b.buf)
+	case refs < 0:
+		panic("websocket: SharedBuffer released too many times")
+	}
+}
+
+// A message sending b as a single frame, holding a reference until it has
+// been written or has failed
+func (b *SharedBuffer) message(msgType int) io.Reader {
+	b.Retain()
+	m := &Message{Type: msgType, Sent: func(error) { b.Release() }}
+	return &writeRequest{Message: m, payload: b.Bytes()}
+}
+
+// Queue b as a message of msgType, in a single frame, like Send. The
+// caller keeps its reference, and may release it right away.
+func (c *Conn) SendShared(msgType int, b *SharedBuffer) (err error) {
+	if c.State() != OPEN {
+		return errConnClosed
+	}
+	r := b.message(msgType)
+	if err = c.enqueue(r); err != nil {
+		b.Release()
+	}
+	return
+}
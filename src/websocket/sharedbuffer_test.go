@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHubBroadcastShared(t *testing.T) {
+	h := NewHub(2)
+	defer h.Close()
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		c, client := newPipeConn()
+		defer client.Close()
+		h.Subscribe(c)
+		clients = append(clients, client)
+	}
+	b := NewSharedBuffer(2)
+	copy(b.Bytes(), "Hi")
+	if result := h.BroadcastShared(TextMessage, b); result.Sent != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	b.Release()
+	for _, client := range clients {
+		expectFrames(t, client, []byte{0x81, 0x02, 'H', 'i'})
+	}
+	for i := 0; atomic.LoadInt32(&b.refs) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("%v references left", atomic.LoadInt32(&b.refs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendSharedClosed(t *testing.T) {
+	c, client := newPipeConn()
+	client.Close()
+	for range c.In {
+	}
+	b := NewSharedBuffer(1)
+	if err := c.SendShared(BinaryMessage, b); err != errConnClosed {
+		t.Errorf("Expected %v, got %v", errConnClosed, err)
+	}
+	if refs := atomic.LoadInt32(&b.refs); refs != 1 {
+		t.Errorf("Expected only the caller's reference, got %v", refs)
+	}
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Length of the record header on disk, message type and payload length
+const spillHeaderLength = 5
+
+var (
+	errSpillFull   = classify(ErrQueueFull, "Spill file is full")
+	errSpillClosed = classify(ErrClosed, "Spill is closed")
+)
+
+// An outgoing queue which never drops or blocks on messages. When the send
+// queue of the connection is full, messages are written to a file, and sent
+// from there in order as soon as the connection catches up.
+type Spill struct {
+	c        *Conn
+	maxBytes int64 // Maximum size of the records not yet sent
+
+	mu       sync.Mutex
+	file     *os.File
+	readOff  int64 // Offset of the next record to send
+	writeOff int64 // Offset of the end of the file
+	count    int   // Number of records not yet sent
+	closed   bool
+	wake     chan bool // Signals the drain loop that records were written
+	done     chan bool // Closed by Close
+}
+
+// Create a spill for c, using a temporary file in dir (or the default
+// directory for temporary files if empty) holding at most maxBytes of
+// messages not yet sent. The file grows to at most twice that, before the
+// records already sent are removed from it.
+func NewSpill(c *Conn, dir string, maxBytes int64) (s *Spill, err error) {
+	file, err := ioutil.TempFile(dir, "websocket-spill-")
+	if err != nil {
+		return
+	}
+	s = &Spill{
+		c:        c,
+		maxBytes: maxBytes,
+		file:     file,
+		wake:     make(chan bool, 1),
+		done:     make(chan bool),
+	}
+	go s.drain()
+	return
+}
+
+// Queue a message for sending. Returns errSpillFull if the message doesn't
+// fit in the spill file.
+func (s *Spill) Send(msgType int, payload []byte) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSpillClosed
+	}
+	if s.count == 0 {
+		select {
+		case s.c.Out <- &Message{Type: msgType, Reader: bytes.NewReader(payload)}:
+			return
+		default: // The send queue is full
+		}
+	}
+	size := int64(spillHeaderLength + len(payload))
+	if s.writeOff-s.readOff+size > s.maxBytes {
+		return errSpillFull
+	}
+	if s.writeOff+size > 2*s.maxBytes {
+		if err = s.compact(); err != nil {
+			return
+		}
+	}
+	record := make([]byte, spillHeaderLength, size)
+	record[0] = byte(msgType)
+	binary.BigEndian.PutUint32(record[1:], uint32(len(payload)))
+	record = append(record, payload...)
+	if _, err = s.file.WriteAt(record, s.writeOff); err != nil {
+		return
+	}
+	s.writeOff += size
+	s.count++
+	select {
+	case s.wake <- true:
+	default:
+	}
+	return
+}
+
+// Number of messages and bytes waiting in the spill file
+func (s *Spill) Spilled() (messages int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.writeOff - s.readOff
+}
+
+// Stop sending, and remove the spill file. Spilled messages are lost.
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// Send spilled records in order, blocking while the send queue is full
+func (s *Spill) drain() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+		for {
+			s.mu.Lock()
+			if s.count == 0 || s.closed {
+				s.mu.Unlock()
+				break
+			}
+			m, size, err := s.readRecord()
+			s.mu.Unlock()
+			if err != nil {
+				Log.Println(err)
+				s.Close()
+				return
+			}
+			select {
+			case s.c.Out <- m:
+			case <-s.c.done:
+				s.Close()
+				return
+			case <-s.done:
+				return
+			}
+			s.mu.Lock()
+			s.readOff += size
+			s.count--
+			if s.count == 0 {
+				// Start over from the beginning of the file
+				s.readOff, s.writeOff = 0, 0
+				s.file.Truncate(0)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Move the records not yet sent to the beginning of the file, so that it
+// doesn't grow with the records already sent while some are always left.
+// The record being sent by drain is kept, as it is at readOff until sent.
+// Must be called with s.mu held.
+func (s *Spill) compact() (err error) {
+	pending := s.writeOff - s.readOff
+	// The records are moved towards the beginning, so each part is read
+	// before it is overwritten
+	src := io.NewSectionReader(s.file, s.readOff, pending)
+	if _, err = io.Copy(io.NewOffsetWriter(s.file, 0), src); err != nil {
+		return
+	}
+	if err = s.file.Truncate(pending); err != nil {
+		return
+	}
+	s.readOff, s.writeOff = 0, pending
+	return
+}
+
+// Read the next record to send. Must be called with s.mu held.
+func (s *Spill) readRecord() (m *Message, size int64, err error) {
+	header := make([]byte, spillHeaderLength)
+	if _, err = s.file.ReadAt(header, s.readOff); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err = s.file.ReadAt(payload, s.readOff+spillHeaderLength); err != nil {
+		return
+	}
+	m = &Message{Type: int(header[0]), Reader: bytes.NewReader(payload)}
+	size = int64(spillHeaderLength + len(payload))
+	return
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// A connection which is not started, so that nothing drains c.out
+func newIdleConn() (c *Conn) {
+	conn, _ := net.Pipe()
+	return newConn(conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), true, nil)
+}
+
+func TestSpillOrder(t *testing.T) {
+	c := newIdleConn()
+	s, err := NewSpill(c, t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	const n = 100
+	for i := 0; i < n; i++ {
+		if err = s.Send(TextMessage, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages, _ := s.Spilled(); messages == 0 {
+		t.Error("Nothing spilled to disk")
+	}
+	for i := 0; i < n; i++ {
+		payload, _ := ioutil.ReadAll(<-c.out)
+		if string(payload) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %v, got %q", i, payload)
+		}
+	}
+	if messages, bytes := s.Spilled(); messages != 0 || bytes != 0 {
+		t.Errorf("Spill not drained: %v messages, %v bytes", messages, bytes)
+	}
+}
+
+func TestSpillFull(t *testing.T) {
+	c := newIdleConn()
+	s, err := NewSpill(c, t.TempDir(), 2*(spillHeaderLength+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < cap(c.out); i++ {
+		s.Send(TextMessage, make([]byte, 10))
+	}
+	for i := 0; i < 2; i++ {
+		if err = s.Send(TextMessage, make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Send(TextMessage, make([]byte, 10)); err != errSpillFull {
+		t.Errorf("Expected errSpillFull, got %v", err)
+	}
+}
+
+func TestSpillNeverEmpty(t *testing.T) {
+	c := newIdleConn()
+	size := int64(spillHeaderLength + 3)
+	s, err := NewSpill(c, t.TempDir(), 2*size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	next := 0
+	send := func() {
+		if err := s.Send(TextMessage, []byte(fmt.Sprintf("%03d", next))); err != nil {
+			t.Fatalf("Message %v: %v", next, err)
+		}
+		next++
+	}
+	for i := 0; i <= cap(c.out); i++ {
+		send()
+	}
+	// One message is always left in the spill, while many times maxBytes
+	// flows through it
+	for i := 0; i < 100; i++ {
+		send()
+		payload, _ := ioutil.ReadAll(<-c.out)
+		if string(payload) != fmt.Sprintf("%03d", i) {
+			t.Fatalf("Expected message %v, got %q", i, payload)
+		}
+		for j := 0; ; j++ {
+			if messages, bytes := s.Spilled(); messages == 1 && bytes == size {
+				break
+			} else if j == 100 {
+				t.Fatalf("Spill not drained to one message: %v messages, %v bytes", messages, bytes)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if info, _ := s.file.Stat(); info.Size() > 2*s.maxBytes {
+		t.Errorf("Spill file grew to %v bytes", info.Size())
+	}
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Number of events which may wait for a client of SSEHandler, before it is
+// dropped to resume from the history
+const sseQueue = 0x10
+
+// A broadcast as delivered to the clients of SSEHandler
+type hubEvent struct {
+	id      uint64
+	msgType int
+	payload []byte
+}
+
+// The broadcasts of a hub, kept for and passed on to the clients of
+// SSEHandler
+type hubEvents struct {
+	mu        sync.Mutex
+	last      uint64     // ID of the latest event
+	history   []hubEvent // Latest events, oldest first
+	listeners map[chan hubEvent]bool
+}
+
+// Record a broadcast, keeping at most history events, and pass it on to
+// the listeners. Listeners without room are closed and removed.
+func (e *hubEvents) publish(msgType int, payload []byte, history int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if history <= 0 && len(e.listeners) == 0 {
+		return
+	}
+	e.last++
+	ev := hubEvent{e.last, msgType, payload}
+	if history > 0 {
+		e.history = append(e.history, ev)
+		if len(e.history) > history {
+			e.history = append(e.history[:0:0], e.history[len(e.history)-history:]...)
+		}
+	}
+	for l := range e.listeners {
+		select {
+		case l <- ev:
+		default:
+			close(l)
+			delete(e.listeners, l)
+		}
+	}
+}
+
+// Start listening, returning the events kept which are newer than after
+func (e *hubEvents) listen(after uint64) (l chan hubEvent, missed []hubEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ev := range e.history {
+		if ev.id > after {
+			missed = append(missed, ev)
+		}
+	}
+	if e.listeners == nil {
+		e.listeners = make(map[chan hubEvent]bool)
+	}
+	l = make(chan hubEvent, sseQueue)
+	e.listeners[l] = true
+	return
+}
+
+func (e *hubEvents) stopListening(l chan hubEvent) {
+	e.mu.Lock()
+	delete(e.listeners, l)
+	e.mu.Unlock()
+}
+
+// An HTTP handler streaming the broadcasts of h as Server-Sent Events, to
+// clients which can't upgrade to websocket, such as behind restrictive
+// proxies. Only broadcasts to every subscriber are streamed, not those of
+// SendTo with a selector, BroadcastFrom or BroadcastShared. Text messages
+// are sent as data, binary messages as base64 data of a "binary" event. A
+// client reconnecting with the Last-Event-ID header first gets the
+// broadcasts it missed, as far as they are kept in History. A client too
+// slow to keep up is disconnected, and resumes the same way.
+func (h *Hub) SSEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		after, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+		l, missed := h.events.listen(after)
+		defer h.events.stopListening(l)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for _, ev := range missed {
+			writeEvent(w, ev)
+		}
+		if err := rc.Flush(); err != nil {
+			Log.Println(err)
+			return
+		}
+		for {
+			select {
+			case ev, ok := <-l:
+				if !ok {
+					return // Too slow
+				}
+				writeEvent(w, ev)
+				if rc.Flush() != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-h.done:
+				return
+			}
+		}
+	})
+}
+
+// Write an event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, ev hubEvent) {
+	data := string(ev.payload)
+	if ev.msgType == BinaryMessage {
+		fmt.Fprint(w, "event: binary\n")
+		data = base64.StdEncoding.EncodeToString(ev.payload)
+	}
+	fmt.Fprintf(w, "id: %d\n", ev.id)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
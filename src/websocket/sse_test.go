@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Read the lines of the next event
+func readEvent(t *testing.T, r *bufio.Reader) (lines []string) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Short read: %v", err)
+		}
+		if line == "\n" {
+			return
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestSSEHandler(t *testing.T) {
+	h := NewHub(1)
+	defer h.Close()
+	h.History = 2
+	h.Broadcast(TextMessage, []byte("one"))
+	h.Broadcast(TextMessage, []byte("two\nlines"))
+	h.Broadcast(BinaryMessage, []byte{0xff})
+	server := httptest.NewServer(h.SSEHandler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	expected := [][]string{
+		{"id: 2", "data: two", "data: lines"},
+		{"event: binary", "id: 3", "data: /w=="},
+	}
+	for _, e := range expected {
+		if lines := readEvent(t, r); strings.Join(lines, "|") != strings.Join(e, "|") {
+			t.Errorf("Expected %q, got %q", e, lines)
+		}
+	}
+	h.Broadcast(TextMessage, []byte("four"))
+	if lines := readEvent(t, r); strings.Join(lines, "|") != "id: 4|data: four" {
+		t.Errorf("Unexpected live event %q", lines)
+	}
+}
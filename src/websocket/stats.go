@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rttWindow   = 64 // Number of round trip times the statistics are based on
+	maxPingsOut = 16 // Number of unanswered pings remembered
+)
+
+// Statistics of a connection. The round trip times are from pings answered
+// by the other end-point, and are all zero until the first pong arrives.
+type Stats struct {
+	Samples int           // Number of round trips the statistics are based on
+	RTTMin  time.Duration // Shortest round trip time
+	RTTAvg  time.Duration // Mean round trip time
+	RTTP95  time.Duration // 95th percentile of the round trip times
+	Jitter  time.Duration // Smoothed variation between consecutive round trips
+	Expired int64         // Outgoing messages dropped since they expired
+
+	// The latest ping answered, see SendPing, and its round trip time. A
+	// pong answering it also acknowledges the pings sent before, which
+	// aren't timed.
+	LastPing uint64
+	LastRTT  time.Duration
+}
+
+// Rolling window of round trip times, and the pings waiting for a pong
+type rttStats struct {
+	mu       sync.Mutex
+	pings    map[uint64]time.Time // Send time of unanswered pings, by payload
+	nextPing uint64
+	rtts     []time.Duration // Ring buffer of the latest round trip times
+	next     int             // Position in rtts of the next sample
+	last     time.Duration   // Latest round trip time
+	lastPing uint64          // Latest ping answered
+	jitter   time.Duration
+}
+
+// Register a ping sent at t, and return its ID and payload
+func (s *rttStats) ping(t time.Time) (id uint64, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pings == nil {
+		s.pings = make(map[uint64]time.Time)
+	}
+	s.nextPing++
+	id = s.nextPing
+	s.pings[id] = t
+	delete(s.pings, id-maxPingsOut)
+	payload = make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, id)
+	return
+}
+
+// Register a pong received at t. Pongs not answering a known ping are
+// ignored. The other end-point may answer only the most recent of several
+// pings, so the earlier ones are forgotten rather than timed by a later
+// pong.
+func (s *rttStats) pong(payload []byte, t time.Time) {
+	if len(payload) != 8 {
+		return
+	}
+	id := binary.BigEndian.Uint64(payload)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent, ok := s.pings[id]
+	if !ok {
+		return
+	}
+	for earlier := range s.pings {
+		if earlier <= id {
+			delete(s.pings, earlier)
+		}
+	}
+	s.lastPing = id
+	s.add(t.Sub(sent))
+}
+
+// Add a round trip time sample. Must be called with s.mu held.
+func (s *rttStats) add(rtt time.Duration) {
+	if len(s.rtts) < rttWindow {
+		s.rtts = append(s.rtts, rtt)
+	} else {
+		s.rtts[s.next] = rtt
+	}
+	s.next = (s.next + 1) % rttWindow
+	if len(s.rtts) > 1 {
+		// Smoothed like the interarrival jitter of RFC 3550
+		d := rtt - s.last
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.last = rtt
+}
+
+func (s *rttStats) stats() (st Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Samples = len(s.rtts)
+	st.LastPing = s.lastPing
+	st.LastRTT = s.last
+	if st.Samples == 0 {
+		return
+	}
+	sorted := append([]time.Duration(nil), s.rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+	st.RTTMin = sorted[0]
+	st.RTTAvg = sum / time.Duration(len(sorted))
+	st.RTTP95 = sorted[(len(sorted)*95+99)/100-1]
+	st.Jitter = s.jitter
+	return
+}
+
+// Send a ping. The round trip time until its pong is part of Stats.
+func (c *Conn) Ping() (err error) {
+	_, err = c.SendPing()
+	return
+}
+
+// Send a ping like Ping, and return its ID, which increases with every
+// ping. Stats tells the ID of the latest ping answered.
+func (c *Conn) SendPing() (id uint64, err error) {
+	if c.State() != OPEN {
+		return 0, errConnClosed
+	}
+	id, payload := c.rtt.ping(time.Now())
+	fh, _ := newFrameHeader(true, opCodePing, int64(len(payload)), c.mask())
+	err = c.queue(newFrame(fh, bytes.NewReader(payload)))
+	return
+}
+
+// Statistics of the connection, with round trip times from the pings sent
+// with Ping
+func (c *Conn) Stats() (st Stats) {
+	st = c.rtt.stats()
+	st.Expired = atomic.LoadInt64(&c.expired)
+	return
+}
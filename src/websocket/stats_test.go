@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPingStats(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		if err = c.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Samples < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st := c.Stats()
+	if st.Samples != 3 {
+		t.Fatalf("Expected 3 samples, got %v", st.Samples)
+	}
+	if st.RTTMin <= 0 || st.RTTMin > st.RTTAvg || st.RTTAvg > st.RTTP95 {
+		t.Errorf("Inconsistent statistics %+v", st)
+	}
+}
+
+func TestRTTStatsWindow(t *testing.T) {
+	var s rttStats
+	for i := 1; i <= rttWindow+100; i++ {
+		s.add(time.Duration(i) * time.Millisecond)
+	}
+	st := s.stats()
+	if st.Samples != rttWindow {
+		t.Errorf("Expected %v samples, got %v", rttWindow, st.Samples)
+	}
+	if st.RTTMin != 101*time.Millisecond {
+		t.Errorf("Old samples not rolled out, min %v", st.RTTMin)
+	}
+	if st.RTTP95 != 161*time.Millisecond {
+		t.Errorf("Unexpected 95th percentile %v", st.RTTP95)
+	}
+	if st.Jitter <= 0 || st.Jitter > time.Millisecond {
+		t.Errorf("Unexpected jitter %v", st.Jitter)
+	}
+}
+
+func TestUnknownPong(t *testing.T) {
+	var s rttStats
+	s.ping(time.Now())
+	s.pong([]byte{0, 0, 0, 0, 0, 0, 0, 9}, time.Now())
+	s.pong([]byte{1}, time.Now())
+	if st := s.stats(); st.Samples != 0 {
+		t.Errorf("Unknown pongs counted: %+v", st)
+	}
+}
+
+func TestPongAcknowledgesEarlierPings(t *testing.T) {
+	var s rttStats
+	start := time.Now()
+	_, first := s.ping(start)
+	id, second := s.ping(start.Add(10 * time.Millisecond))
+	s.pong(second, start.Add(15*time.Millisecond))
+	// Late answer to the first ping, already acknowledged
+	s.pong(first, start.Add(20*time.Millisecond))
+	st := s.stats()
+	if st.Samples != 1 || st.LastPing != id || st.LastRTT != 5*time.Millisecond {
+		t.Errorf("Expected only ping %v timed at 5ms, got %+v", id, st)
+	}
+}
+
+func TestMessageExpires(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	sent := make(chan error, 1)
+	c.Out <- &Message{
+		Type:    TextMessage,
+		Expires: time.Now().Add(-time.Second),
+		Sent:    func(err error) { sent <- err },
+		Reader:  bytes.NewBufferString("Stale"),
+	}
+	c.Out <- &Message{Type: TextMessage, Expires: time.Now().Add(time.Hour), Reader: bytes.NewBufferString("Fresh")}
+	expectFrames(t, client, []byte{0x81, 0x05, 'F', 'r', 'e', 's', 'h'})
+	if err := <-sent; err != errMessageExpired {
+		t.Errorf("Expected errMessageExpired, got %v", err)
+	}
+	if expired := c.Stats().Expired; expired != 1 {
+		t.Errorf("Expected 1 expired message, got %v", expired)
+	}
+}
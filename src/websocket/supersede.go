@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"io"
+)
+
+// Send m like Send, as the latest message of key. The messages with the
+// same Key still queued are dropped instead of sent, so that only the
+// latest, such as a snapshot of some state, reaches a slow client. If m
+// can't be queued, the messages it would supersede are sent as usual.
+func (c *Conn) Supersede(key string, m *Message) (err error) {
+	m.Key = key
+	c.mu.Lock()
+	if c.latest == nil {
+		c.latest = make(map[string]*Message)
+	}
+	c.latest[key] = m
+	c.mu.Unlock()
+	if err = c.Send(m); err != nil {
+		c.mu.Lock()
+		if c.latest[key] == m {
+			delete(c.latest, key)
+		}
+		c.mu.Unlock()
+	}
+	return
+}
+
+// True if an outgoing message taken from the queue has been superseded by
+// a newer one. The key is forgotten once its latest message is taken.
+func (c *Conn) superseded(r io.Reader) bool {
+	m := outgoingMessage(r)
+	if m == nil || m.Key == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	latest, ok := c.latest[m.Key]
+	if !ok {
+		return false
+	}
+	if latest != m {
+		return true
+	}
+	delete(c.latest, m.Key)
+	return false
+}
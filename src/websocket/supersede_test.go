@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSupersede(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	// Holds up the send loop while the others are queued
+	r, w := io.Pipe()
+	c.Out <- &Message{Type: TextMessage, Reader: r}
+	sent := make(chan error, 3)
+	for _, s := range []string{"a", "b", "c"} {
+		m := &Message{Type: TextMessage, Reader: strings.NewReader(s), Sent: func(err error) { sent <- err }}
+		if err := c.Supersede("state", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Write([]byte("first"))
+	w.Close()
+	var payloads []string
+	for len(payloads) == 0 || payloads[len(payloads)-1] != "c" {
+		payloads = append(payloads, readShortFrame(t, client))
+	}
+	for _, p := range payloads {
+		if p == "a" || p == "b" {
+			t.Errorf("Superseded message %q was sent", p)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-sent; err != errMessageSuperseded {
+			t.Errorf("Expected %v, got %v", errMessageSuperseded, err)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("Expected the latest message to be sent, got %v", err)
+	}
+	if len(c.latest) != 0 {
+		t.Errorf("Expected no keys left, got %v", c.latest)
+	}
+}
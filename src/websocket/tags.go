@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"sort"
+)
+
+// Selects connections, such as the recipients of Hub.SendTo
+type Selector func(c *Conn) bool
+
+// Add tags to the connection, such as "tenant:42", to select it by
+func (c *Conn) Tag(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags == nil {
+		c.tags = make(map[string]bool)
+	}
+	for _, tag := range tags {
+		c.tags[tag] = true
+	}
+}
+
+// Remove tags from the connection
+func (c *Conn) Untag(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		delete(c.tags, tag)
+	}
+}
+
+// True if the connection has the tag
+func (c *Conn) HasTag(tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tags[tag]
+}
+
+// The tags of the connection, sorted
+func (c *Conn) Tags() (tags []string) {
+	c.mu.Lock()
+	for tag := range c.tags {
+		tags = append(tags, tag)
+	}
+	c.mu.Unlock()
+	sort.Strings(tags)
+	return
+}
+
+// Select connections with all of the tags
+func AllTags(tags ...string) Selector {
+	return func(c *Conn) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, tag := range tags {
+			if !c.tags[tag] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Select connections with any of the tags
+func AnyTag(tags ...string) Selector {
+	return func(c *Conn) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, tag := range tags {
+			if c.tags[tag] {
+				return true
+			}
+		}
+		return false
+	}
+}
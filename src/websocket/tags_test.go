@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	c, client := newPipeConn()
+	defer client.Close()
+	c.Tag("tenant:42", "beta")
+	c.Tag("shard:1")
+	c.Untag("beta")
+	if !c.HasTag("tenant:42") || c.HasTag("beta") {
+		t.Error("Unexpected tags")
+	}
+	if tags := c.Tags(); !reflect.DeepEqual(tags, []string{"shard:1", "tenant:42"}) {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if !AllTags("tenant:42", "shard:1")(c) || AllTags("tenant:42", "beta")(c) {
+		t.Error("AllTags mismatch")
+	}
+	if !AnyTag("beta", "shard:1")(c) || AnyTag("beta", "shard:2")(c) {
+		t.Error("AnyTag mismatch")
+	}
+}
+
+func TestHubSendTo(t *testing.T) {
+	h := NewHub(2)
+	defer h.Close()
+	tags := [][]string{{"tenant:1", "beta"}, {"tenant:1"}, {"tenant:2", "beta"}}
+	var clients []net.Conn
+	for _, tt := range tags {
+		c, client := newPipeConn()
+		defer client.Close()
+		c.Tag(tt...)
+		h.Subscribe(c)
+		clients = append(clients, client)
+	}
+	if result := h.SendTo(AllTags("tenant:1", "beta"), TextMessage, []byte("A")); result.Sent != 1 {
+		t.Errorf("Expected 1 recipient, got %v", result.Sent)
+	}
+	if result := h.SendTo(AnyTag("tenant:2", "beta"), TextMessage, []byte("B")); result.Sent != 2 {
+		t.Errorf("Expected 2 recipients, got %v", result.Sent)
+	}
+	expectFrames(t, clients[0], []byte{0x81, 0x01, 'A', 0x81, 0x01, 'B'})
+	expectFrames(t, clients[1], nil)
+	expectFrames(t, clients[2], []byte{0x81, 0x01, 'B'})
+}
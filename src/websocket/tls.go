@@ -0,0 +1,28 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// The TLS state of the connection, such as the cipher suite, the
+// negotiated ALPN protocol and the certificates of the other end-point.
+// Nil if the connection isn't over TLS.
+func (c *Conn) TLSConnectionState() *tls.ConnectionState {
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		return &state
+	}
+	// The hijacked connection may be wrapped, the request still knows
+	return c.tlsState
+}
+
+// The verified certificate chain of the other end-point, leaf first. Nil if
+// the connection isn't over TLS, or if the other end-point sent no
+// certificate or it wasn't verified.
+func (c *Conn) PeerCertificates() []*x509.Certificate {
+	if state := c.TLSConnectionState(); state != nil && len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0]
+	}
+	return nil
+}
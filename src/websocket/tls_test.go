@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// A self-signed certificate for 127.0.0.1, usable by both end-points
+func newTestCertificate(t *testing.T, name string) (cert tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCert := newTestCertificate(t, "server")
+	clientCert := newTestCertificate(t, "client")
+	serverPool, clientPool := x509.NewCertPool(), x509.NewCertPool()
+	serverPool.AddCert(serverCert.Leaf)
+	clientPool.AddCert(clientCert.Leaf)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan *Conn, 1)
+	s := &Server{
+		Handle: func(c *Conn) { conns <- c },
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientPool,
+		},
+	}
+	go s.ServeTLS(l, "", "")
+	defer s.Close()
+
+	url := "wss://" + l.Addr().String() + "/"
+	d := &Dialer{TLSConfig: &tls.Config{RootCAs: serverPool}}
+	if _, _, err = d.Dial(url, ""); err == nil {
+		t.Error("Connected without a client certificate")
+	}
+	d.TLSConfig.Certificates = []tls.Certificate{clientCert}
+	c, _, err := d.Dial(url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if chain := c.PeerCertificates(); len(chain) == 0 || chain[0].Subject.CommonName != "server" {
+		t.Errorf("Unexpected server certificate chain: %v", chain)
+	}
+	sc := <-conns
+	defer sc.Close()
+	if chain := sc.PeerCertificates(); len(chain) == 0 || chain[0].Subject.CommonName != "client" {
+		t.Errorf("Unexpected client certificate chain: %v", chain)
+	}
+}
+
+func TestTLSConnectionState(t *testing.T) {
+	cert := newTestCertificate(t, "server")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan *Conn, 1)
+	s := &Server{
+		Handle:    func(c *Conn) { conns <- c },
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go s.ServeTLS(l, "", "")
+	defer s.Close()
+	d := &Dialer{TLSConfig: &tls.Config{RootCAs: pool, NextProtos: []string{"http/1.1"}}}
+	c, _, err := d.Dial("wss://"+l.Addr().String()+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-conns
+	defer sc.Close()
+	for _, c := range []*Conn{c, sc} {
+		state := c.TLSConnectionState()
+		if state == nil || !state.HandshakeComplete {
+			t.Fatalf("Unexpected TLS state: %v", state)
+		}
+		if state.NegotiatedProtocol != "http/1.1" {
+			t.Errorf("Expected ALPN http/1.1, got %q", state.NegotiatedProtocol)
+		}
+	}
+	plain, client := newPipeConn()
+	defer client.Close()
+	if plain.TLSConnectionState() != nil {
+		t.Error("TLS state of a plain connection")
+	}
+}
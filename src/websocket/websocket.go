@@ -3,8 +3,11 @@ package websocket
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -12,66 +15,191 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+	"websocket/wsframe"
 )
 
 const (
 	guid           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 	secWSKeyLength = 16
 	secWSVersion   = 13
-	minProtoMajor  = 1
-	minProtoMinor  = 1
+
+	// How long the closing handshake may take before the TCP connection is
+	// closed anyway
+	closeTimeout  = 5 * time.Second
+	minProtoMajor = 1
+	minProtoMinor = 1
 )
 
-// Bitmasks for protocol
+// Opcodes
 const (
-	fin                = byte(0x80)
-	rsvMask            = byte(0x70)
-	opCodeMask         = byte(0x0F)
-	opCodeContinuation = byte(0x00)
-	opCodeText         = byte(0x01)
-	opCodeBinary       = byte(0x02)
-
-	// Control frames are identified by opcodes where the most significant bit of
-	// the opcode is 1.
-	opCodeControlFrame    = byte(0x08) // MSB of opCode
-	opCodeConnectionClose = byte(0x08)
-	opCodePing            = byte(0x09)
-	opCodePong            = byte(0x0A)
-	mask                  = byte(0x80)
-	payloadLength7        = byte(0x7F)
+	opCodeContinuation    = wsframe.OpContinuation
+	opCodeText            = wsframe.OpText
+	opCodeBinary          = wsframe.OpBinary
+	opCodeConnectionClose = wsframe.OpClose
+	opCodePing            = wsframe.OpPing
+	opCodePong            = wsframe.OpPong
 )
 
 const (
-	statusNormalClosure   = uint16(1000)
-	statusGoingAway       = uint16(1001)
-	statusProtocolError   = uint16(1002)
-	statusUnsupportedData = uint16(1003)
+	statusNormalClosure   = CloseNormalClosure
+	statusGoingAway       = CloseGoingAway
+	statusProtocolError   = CloseProtocolError
+	statusUnsupportedData = CloseUnsupportedData
+	statusInvalidPayload  = CloseInvalidFramePayloadData
+	statusPolicyViolation = ClosePolicyViolation
+	statusMessageTooBig   = CloseMessageTooBig
+	statusInternalError   = CloseInternalServerErr
+	statusNoStatusRcvd    = CloseNoStatusReceived
+	statusAbnormalClosure = CloseAbnormalClosure
 )
 
 var (
-	errMalformedClientHandshake = errors.New("Malformed handshake request from client")
-	errMalformedSecWSKey        = errors.New("Malformed Sec-WebSocket-Key")
+	errMalformedClientHandshake = classify(ErrHandshake, "Malformed handshake request from client")
+	errMalformedSecWSKey        = classify(ErrHandshake, "Malformed Sec-WebSocket-Key")
+	errUnsupportedVersion       = classify(ErrHandshake, "Unsupported Sec-WebSocket-Version")
+	errConnClosed               = classify(ErrClosed, "Websocket connection is closed")
+	errMessageDropped           = classify(ErrQueueFull, "Message dropped, the send queue is full")
+	errSlowClient               = classify(ErrClosed, "Connection closed, too slow to receive")
+	errMessageExpired           = classify(ErrTimeout, "Message expired before it was sent")
+	errInvalidControlFrame      = classify(ErrProtocol, "Invalid control frame")
+	errMessageSuperseded        = classify(ErrQueueFull, "Message superseded by a newer one")
+	errMessageAborted           = classify(ErrClosed, "Outgoing message aborted")
+	errMessageAbandoned         = classify(ErrTimeout, "Message discarded, it wasn't read in time")
 )
 
-var opCodeDescriptions = map[byte]string{
-	opCodeContinuation:    "continuation frame",
-	opCodeText:            "text frame",
-	opCodeBinary:          "binary frame",
-	opCodeConnectionClose: "connection close",
-	opCodePing:            "ping",
-	opCodePong:            "pong",
-}
+// Returned to Handler.OnUpgradeError when the http.ResponseWriter, or any
+// writer it unwraps to, doesn't support taking over the connection.
+var ErrNotHijackable = classify(ErrHandshake, "Connection can not be hijacked from the http.ResponseWriter")
+
+// The Sec-WebSocket-Version values accepted by the server, most preferred
+// first. Clients sending any other version are told about these.
+var supportedVersions = []int{secWSVersion}
 
 var Log = log.New(ioutil.Discard, "", log.LstdFlags)
 
 // A websocket handler, implements http.Handler
 type Handler struct {
 	Conns chan *Conn
+
+	// Called if a valid handshake can't be completed because the connection
+	// can't be taken over from the HTTP server. If nil, the error is logged.
+	OnUpgradeError func(r *http.Request, err error)
+
+	// Called when a handshake is refused, such as a malformed one or one
+	// from an origin not allowed, with the reason and the HTTP status of the
+	// response. If nil, the error is logged.
+	OnHandshakeError func(r *http.Request, err error, status int)
+
+	// If not nil, connections are handled by these callbacks instead of
+	// being sent on Conns.
+	Events *Events
+
+	// Channel capacities of new connections, DefaultBuffers if nil
+	Buffers *Buffers
+
+	// If not nil, a memory budget shared by the connections of the handler
+	Budget *Budget
+
+	// Interceptors of the messages of every connection, see
+	// Conn.InterceptOutgoing and Conn.InterceptIncoming
+	OutgoingInterceptors []OutgoingInterceptor
+	IncomingInterceptors []IncomingInterceptor
+
+	// Where new upgrades are redirected once draining, see Drain
+	DrainLocation string
+
+	// If not empty, only browsers on these origins may connect. A pattern
+	// is a scheme and host, with an optional port and an optional wildcard
+	// for subdomains, such as "https://*.example.com".
+	AllowedOrigins []string
+
+	// Refuse handshakes without an Origin header, which are otherwise
+	// allowed since only browsers send it
+	StrictOrigin bool
+
+	// Proxies trusted to report the client address in the Forwarded or
+	// X-Forwarded-For header, see Conn.ClientIP
+	TrustedProxies []netip.Prefix
+
+	// If not empty, only clients within these networks may connect, see
+	// Conn.ClientIP
+	AllowedNetworks []netip.Prefix
+
+	// Clients within these networks are refused, even if allowed above
+	DeniedNetworks []netip.Prefix
+
+	// If not nil, called with the address of every client allowed above,
+	// such as to look it up in a ban list. Returning an error refuses it.
+	CheckIP func(ip netip.Addr) error
+
+	// If not nil, the rate of handshake attempts of each client is limited
+	RateLimit *RateLimit
+
+	// If not nil, called with the header of every handshake response, both
+	// the 101 and the refusals, just before it is written. Headers can be
+	// changed or removed, such as a Server header set by a wrapping handler
+	// which would identify the implementation.
+	ResponseHeader func(header http.Header, status int)
+
+	// If positive, the size limit of incoming messages, see
+	// Conn.SetReadLimit
+	ReadLimit int64
+
+	// If positive, single frame messages up to this size are delivered
+	// directly, see Conn.SetDirectDelivery
+	DirectDelivery int64
+
+	// How incoming messages are delivered, see Conn.SetDelivery
+	Delivery int
+
+	// If positive, the number of incoming messages in flight before reading
+	// stops, see Conn.SetInFlightLimit
+	InFlightLimit int
+
+	// If positive, the frames of outgoing messages are allocated from this
+	// many slots per connection, see Conn.SetFrameArena
+	FrameArena int
+
+	// If not nil, pings are sent to every connection on this schedule
+	Pings *PingSchedule
+
+	// How strictly clients are held to the protocol, Strict by default
+	Compliance Compliance
+
+	// Accept unmasked frames from clients which aren't browsers, that is
+	// without an Origin header, such as trusted servers which skip masking
+	// for performance. Browsers must always mask their frames.
+	AllowUnmaskedFrames bool
+
+	// Accept the legacy handshake of the draft hixie-76, also known as
+	// hybi-00, which old embedded firmware and smart TVs still speak. Such
+	// connections carry text messages only, binary messages, pings and
+	// pongs sent on them are dropped. See Conn.Version.
+	Hixie76 bool
+
+	draining int32        // Set by Drain
+	stats    handlerStats // See Stats
+}
+
+// Capacities of the channels of a connection. Zero gives an unbuffered
+// channel, for fully synchronous delivery.
+type Buffers struct {
+	In   int // Incoming messages not yet received from In
+	Out  int // Outgoing messages sent on Out but not yet being sent
+	Send int // Frames waiting to be written to the network
 }
 
+// The channel capacities used unless others are configured
+var DefaultBuffers = Buffers{In: 0x10, Out: 0x10, Send: 0x10}
+
 func NewHandler() (h *Handler) {
 	h = &Handler{
 		Conns: make(chan *Conn, 0x10),
@@ -80,33 +208,187 @@ func NewHandler() (h *Handler) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var status int
-	secWSAccept, err := wsClientHandshake(r)
-	if err != nil {
-		Log.Println(err)
-		w.Header().Set("Sec-WebSocket-Version", strconv.Itoa(secWSVersion))
-		status = http.StatusBadRequest
+	c := h.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	c.start()
+	if h.Events != nil {
+		c.Handle(h.Events)
 	} else {
-		// TODO: Map or list instead?
-		w.Header().Set("Upgrade", "websocket")
-		w.Header().Set("Connection", "Upgrade")
-		w.Header().Set("Sec-WebSocket-Accept", secWSAccept)
-		status = http.StatusSwitchingProtocols
-	}
-	w.WriteHeader(status)
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		log.Fatal("No HTTP hijacking")
-	}
-	conn, rw, err := hj.Hijack()
+		h.Conns <- c
+	}
+}
+
+// Perform the server side of the handshake and take over the connection.
+// Returns a connection which is not started yet, or nil if the upgrade
+// failed, in which case a response has been written.
+func (h *Handler) upgrade(w http.ResponseWriter, r *http.Request) (c *Conn) {
+	w = h.hookResponse(w)
+	if !h.filterIP(w, r) || !h.rateLimit(w, r) {
+		return
+	}
+	if h.Hixie76 && isHixie76(r) {
+		return h.upgradeHixie76(w, r)
+	}
+	secWSAccept, err := wsClientHandshake(r, h.Compliance)
 	if err != nil {
-		log.Fatal(err)
+		// Failed handshakes get an ordinary HTTP response
+		status := http.StatusBadRequest
+		if err == errUnsupportedVersion {
+			w.Header().Set("Sec-WebSocket-Version", supportedVersionsHeader())
+			status = http.StatusUpgradeRequired
+		}
+		h.refuse(w, r, err, status)
+		return
+	}
+	if !h.admit(w, r) {
+		return
 	}
+	conn, rw, err := hijack(w)
+	if err != nil {
+		h.upgradeError(w, r, err)
+		return
+	}
+	// The response is written by hand, since the connection is hijacked.
+	// Headers set by wrapping handlers, such as cookies, are kept.
+	header := w.Header()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", secWSAccept)
+	if h.ResponseHeader != nil {
+		h.ResponseHeader(header, http.StatusSwitchingProtocols)
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(rw)
 	rw.WriteString("\r\n")
-	rw.Flush()
-	c := newConn(conn)
-	h.Conns <- c
-	c.start()
+	if err = rw.Flush(); err != nil {
+		recordFailure(r, err)
+		Log.Println(err)
+		conn.Close()
+		return
+	}
+	c = newConn(conn, rw, true, h.Buffers)
+	c.setNegotiated(header, secWSVersion)
+	h.setup(c, r)
+	return
+}
+
+// Refuse a valid handshake if its origin isn't allowed, the handler is
+// draining or over its budget. Returns true if it may be upgraded.
+func (h *Handler) admit(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.checkOrigin(r); err != nil {
+		h.refuse(w, r, err, http.StatusForbidden)
+		return false
+	}
+	if h.Draining() {
+		h.drained(w, r)
+		return false
+	}
+	if h.Budget != nil && h.Budget.rejecting() {
+		h.refuse(w, r, errOverBudget, http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// Take over the connection of w from the HTTP server
+func hijack(w http.ResponseWriter) (conn net.Conn, rw *bufio.ReadWriter, err error) {
+	// The response controller finds the http.Hijacker also through wrapping
+	// writers which implement Unwrap
+	conn, rw, err = http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		err = ErrNotHijackable
+	}
+	return
+}
+
+// Apply the options of h to a connection it upgraded r to
+func (h *Handler) setup(c *Conn, r *http.Request) {
+	if h.Budget != nil {
+		h.Budget.attach(c)
+	}
+	h.stats.attach(c)
+	c.compliance = h.Compliance
+	c.unmaskedAllowed = h.AllowUnmaskedFrames && r.Header.Get("Origin") == ""
+	c.tlsState = r.TLS
+	c.clientIP = h.clientIP(r)
+	c.InterceptOutgoing(h.OutgoingInterceptors...)
+	c.InterceptIncoming(h.IncomingInterceptors...)
+	c.SetReadLimit(h.ReadLimit)
+	c.SetDirectDelivery(h.DirectDelivery)
+	c.SetDelivery(h.Delivery)
+	c.SetInFlightLimit(h.InFlightLimit)
+	c.SetFrameArena(h.FrameArena)
+	if h.Pings != nil {
+		c.SchedulePings(*h.Pings)
+	}
+}
+
+// Refuse the handshake with an HTTP error response, and report err to the
+// handshake error hook
+func (h *Handler) refuse(w http.ResponseWriter, r *http.Request, err error, status int) {
+	h.handshakeError(r, err, status)
+	http.Error(w, err.Error(), status)
+}
+
+func (h *Handler) handshakeError(r *http.Request, err error, status int) {
+	atomic.AddInt64(&h.stats.rejected, 1)
+	recordFailure(r, err)
+	if h.OnHandshakeError != nil {
+		h.OnHandshakeError(r, err, status)
+	} else {
+		Log.Println(h.clientIP(r), err)
+	}
+}
+
+// Respond with an internal server error and report err to the error hook
+func (h *Handler) upgradeError(w http.ResponseWriter, r *http.Request, err error) {
+	recordFailure(r, err)
+	if h.OnUpgradeError != nil {
+		h.OnUpgradeError(r, err)
+	} else {
+		Log.Println(err)
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// Message types, for messages sent and received on a Conn
+const (
+	TextMessage   = int(opCodeText)
+	BinaryMessage = int(opCodeBinary)
+)
+
+// Delivery classes of outgoing messages, see Conn.Send
+const (
+	MustDeliver = iota // Waits while the send queue is full
+	Droppable          // Discarded if the send queue is full
+)
+
+// A message of a specific type. Readers received on Conn.In are always
+// *Message values. A *Message sent on Conn.Out is sent with its Type, any
+// other reader is sent as a text message.
+type Message struct {
+	Type     int
+	QoS      int // Delivery class, only used by Conn.Send
+	Priority int // Outgoing band, 0 being the most urgent, see Priorities
+
+	// If not zero, an outgoing message still queued at this time is dropped
+	// instead of sent late, and reported as failed with errMessageExpired
+	Expires time.Time
+
+	// If not empty, an outgoing message still queued when a newer message
+	// with the same Key is sent with Supersede is dropped, and reported as
+	// failed with errMessageSuperseded
+	Key string
+
+	// If not nil, called once for an outgoing message taken from Out, with
+	// nil when the message has been written to the network, or with the
+	// error if it never will be. Messages still sent on Out after the
+	// connection has ended are never taken.
+	Sent func(err error)
+
+	io.Reader
 }
 
 // Connection states for websocket connections
@@ -121,12 +403,17 @@ const (
 type errConnection struct {
 	code   uint16
 	reason string
+	err    error // The cause, if any
 }
 
 func (e *errConnection) Error() string {
 	return fmt.Sprintf("Error %v: %v", e.code, e.reason)
 }
 
+func (e *errConnection) Unwrap() error {
+	return e.err
+}
+
 func newErrConnection(code uint16, reason string) (e *errConnection) {
 	e = &errConnection{
 		code:   code,
@@ -139,107 +426,613 @@ var (
 	errNormalClosure = newErrConnection(statusNormalClosure, "")
 )
 
+// The error of a connection which was lost without a closing handshake, or
+// which the other end-point closed with a status other than normal closure.
+// Code is 1006 (abnormal closure) if it was lost, and Text tells what
+// happened to the underlying connection. Otherwise Code and Text are the
+// status and reason of the close frame.
+type CloseError struct {
+	Code uint16
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Code != statusAbnormalClosure {
+		return fmt.Sprintf("Connection closed by the other end-point (%v): %v", e.Code, e.Text)
+	}
+	return fmt.Sprintf("Connection closed abnormally (%v): %v", e.Code, e.Text)
+}
+
 type Conn struct {
-	conn                     net.Conn
-	clientClose              bool // Has the client sent a close frame
-	expectingContFrame       bool // Expecting a continuation frame, if fin wasn't set
-	rw                       *bufio.ReadWriter
-	in                       chan<- io.Reader
-	In                       <-chan io.Reader
-	out                      <-chan io.Reader
-	Out                      chan<- io.Reader
-	send                     chan *frame
-	currWriter               *io.PipeWriter // Current message writer (for fragmented messages)
-	State                    int            // The connection state
-	closeSent, closeRecieved bool           // Log that a close frame has been sent and recieved
-	Cleanly                  bool           // Was the connection closed cleanly?
-	server                   bool           // True if connection is server, false if client
-}
-
-func newConn(conn net.Conn) (c *Conn) {
-	in := make(chan io.Reader, 0x10)
-	out := make(chan io.Reader, 0x10)
-	send := make(chan *frame, 0x10) // Message buffer
+	conn               net.Conn
+	clientClose        bool               // Has the client sent a close frame
+	expectingContFrame bool               // Expecting a continuation frame, if fin wasn't set
+	reassembled        *Message           // Incoming message being reassembled for the interceptors
+	fragmenting        *fragmentedMessage // Incoming message being delivered as fragments
+	stream             *messageStream     // Of the latest incoming message streamed
+	recycled           chan *streamHandle // Incoming messages to reuse, see Message.Recycle
+	messageLength      int64              // Announced length of the incoming message so far
+	maskTolerated      bool               // Logged that wrongly masked frames are tolerated
+	unmaskedAllowed    bool               // Unmasked frames from a trusted client are accepted
+	compliance         Compliance         // How strictly the other end-point is held to the protocol
+	rw                 *bufio.ReadWriter
+	headers            wsframe.HeaderReader // Reads the headers of incoming frames
+	inFrame            frame                // The incoming frame being processed, see readFrame
+	in                 chan<- io.Reader
+	In                 <-chan io.Reader
+	inDone             chan bool // Closed when incoming messages are discarded
+	consumed           chan bool // Signaled when an incoming message in flight is consumed
+	closeIn            sync.Once // Closes c.inDone
+	outDrain           sync.Once // Starts drainOut
+	out                <-chan io.Reader
+	Out                chan<- io.Reader
+	send               chan *frame
+	sendDone           chan bool            // Closed when the send loop stops
+	done               chan bool            // Closed when the connection is closed
+	wg                 sync.WaitGroup       // The goroutines of the connection
+	server             bool                 // True if connection is server, false if client
+	dropped            int64                // Number of messages dropped by Send
+	expired            int64                // Number of outgoing messages expired
+	bufferedMessages   int64                // Outgoing messages taken from Out, not yet flushed
+	bufferedBytes      int64                // Bytes not yet flushed, see Buffered
+	lastActive         int64                // Unix time in nanoseconds of the last frame, if of a handler
+	framesIn           int64                // Frames received, see PingSchedule.Timeout
+	id                 int64                // Order of the upgrade by the handler, see DebugHandler
+	started            time.Time            // When upgraded by the handler
+	rtt                rttStats             // Round trip times of pings
+	subprotocol        string               // Negotiated in the handshake
+	extensions         []Extension          // Negotiated in the handshake
+	version            int                  // Negotiated in the handshake
+	tlsState           *tls.ConnectionState // Of the handshake request, if over TLS
+	clientIP           netip.Addr           // See ClientIP
+	random             io.Reader            // Source of masking keys if not crypto/rand, see Dialer.Rand
+
+	// The state of the connection, guarded by mu
+	mu                       sync.Mutex
+	currWriter               *streamHandle              // Current message writer (for fragmented messages)
+	state                    int                        // The connection state
+	closeSent, closeRecieved bool                       // Log that a close frame has been sent and recieved
+	halfClosed               bool                       // Close frame sent by CloseWrite, incoming messages still delivered
+	cleanly                  bool                       // Was the connection closed cleanly?
+	remoteClose              *errConnection             // Status in the close frame from the other end-point
+	unsent                   map[*Message]bool          // Messages with a Sent callback, not yet sent
+	flushPolicy              FlushPolicy                // When written frames are flushed
+	priorities               Priorities                 // Bands of outgoing messages
+	abandonTimeout           time.Duration              // See SetAbandonTimeout
+	readLimit                int64                      // See SetReadLimit
+	directDelivery           int64                      // See SetDirectDelivery
+	delivery                 int                        // See SetDelivery
+	inFlightLimit, inFlight  int                        // See SetInFlightLimit
+	arena                    *frameArena                // See SetFrameArena
+	budget                   *Budget                    // Shared memory budget, or nil
+	stats                    *handlerStats              // Counters of the handler, or nil
+	tags                     map[string]bool            // See Tag
+	outgoing                 []OutgoingInterceptor      // See InterceptOutgoing
+	incomingInterceptors     []IncomingInterceptor      // See InterceptIncoming
+	onFragment               FragmentHandler            // See OnFragment
+	scheduled                map[*scheduledMessage]bool // Messages waiting for SendAfter
+	partial                  io.Reader                  // Outgoing message partly sent, see AbortMessage
+	latest                   map[string]*Message        // Latest queued message per key, see Supersede
+	aborted                  io.Reader                  // Outgoing message aborted by AbortMessage
+	pingStop                 chan bool                  // Closed to stop the pings of SchedulePings
+	pingHandler, pongHandler func([]byte) error         // See SetPingHandler and SetPongHandler
+	closeHandler             func(uint16, string) error // See SetCloseHandler
+	err                      error                      // Error which ended the connection, set before In is closed
+
+	// If positive, a must-deliver message waiting longer than this for room
+	// in the send queue closes the connection, see Send.
+	EvictTimeout time.Duration
+}
+
+// Create a connection on top of an established TCP connection, after the
+// handshake is done, with the channel capacities in b, or DefaultBuffers if
+// nil. Rw may contain data already buffered from conn.
+func newConn(conn net.Conn, rw *bufio.ReadWriter, server bool, b *Buffers) (c *Conn) {
+	if b == nil {
+		b = &DefaultBuffers
+	}
+	in := make(chan io.Reader, b.In)
+	out := make(chan io.Reader, b.Out)
+	send := make(chan *frame, b.Send) // Frame buffer
 	c = &Conn{
-		conn:   conn,
-		rw:     bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
-		in:     in,
-		In:     in,
-		out:    out,
-		Out:    out,
-		send:   send,
-		State:  OPEN,
-		server: true,
+		conn:     conn,
+		rw:       rw,
+		in:       in,
+		In:       in,
+		inDone:   make(chan bool),
+		consumed: make(chan bool, 1),
+		recycled: make(chan *streamHandle, b.In+1),
+		out:      out,
+		Out:      out,
+		send:     send,
+		sendDone: make(chan bool),
+		done:     make(chan bool),
+		state:    OPEN,
+		server:   server,
 	}
 	return
 }
 
 func (c *Conn) start() {
 	Log.Println("Conn started")
+	c.wg.Add(3)
 	go c.sendLoop()
 	go func() {
+		defer c.wg.Done()
 		err := c.router()
 		if err != nil {
+			if _, ok := err.(*errConnection); !ok {
+				// The connection was lost without a close frame
+				err = &CloseError{Code: statusAbnormalClosure, Text: err.Error()}
+			}
 			Log.Print(err)
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
 			c.closing()
 			c.destroy(false)
 		}
+		close(c.in)
 	}()
 	go c.sendMessageLoop()
 }
 
+// The error which ended the connection, available once In is closed. It is
+// a *CloseError if the connection was lost without a closing handshake, or
+// if the other end-point started the closing handshake with a status other
+// than 1000 (normal closure) or 1005 (no status), another error if the
+// other end-point broke the protocol, and nil otherwise.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Block until the connection is closed and all of its goroutines have
+// exited, and return the error which ended it, see Err.
+func (c *Conn) Wait() error {
+	c.wg.Wait()
+	return c.Err()
+}
+
+// Discard incoming messages which the application stops reading, so that
+// an ignored message can't stall the connection. If d is positive, a
+// message whose payload isn't read for d is discarded, and reading it fails
+// with errMessageAbandoned. Zero, the default, waits for the reader forever.
+func (c *Conn) SetAbandonTimeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.abandonTimeout = d
+}
+
+// The timeout set with SetAbandonTimeout
+func (c *Conn) AbandonTimeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.abandonTimeout
+}
+
+// The connection state, OPEN, CLOSING or CLOSED
+func (c *Conn) State() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// True if the connection was closed with a complete closing handshake
+func (c *Conn) Cleanly() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cleanly
+}
+
+// Maximum payload length of the frames outgoing messages are split into
+const maxFramePayload = 128
+
 // Retrieves messages from c.Out, fragments them and sends them away.
+// Messages queued after the closing handshake has started are discarded.
 func (c *Conn) sendMessageLoop() {
-	// Read all
-	var (
-		n   int64
-		max int64 = 128
-		fh  *frameHeader
-		f   *frame
-		fin bool
-		op  byte
-	)
-	for r, ok := <-c.out; ok && c.State == OPEN; r, ok = <-c.out {
-		var err error
-		br := bufio.NewReader(r)
-		op = opCodeText // First frame, always text
-		for err == nil {
-			buf := bytes.NewBuffer(make([]byte, 0, max))
-			n, err = io.CopyN(buf, br, max)
-			fin = err == io.EOF // Last frame
-			fh, _ = newFrameHeader(fin, op, n, nil)
+	defer c.wg.Done()
+	defer c.failUnsent()
+	defer c.cancelScheduled()
+	var q outQueue
+	defer func() {
+		for r := q.pop(0); r != nil; r = q.pop(0) {
+			c.failOutgoing(r, errConnClosed)
+		}
+	}()
+	for {
+		p := c.Priorities()
+		if q.n == 0 {
+			select {
+			case r := <-c.out:
+				c.bufferMessage(r)
+				q.push(r, p.Bands)
+			case <-c.done:
+				return
+			}
+		}
+		// Take what else is waiting, so that the most urgent goes first
+		for more := p.Bands > 1; more && q.n <= cap(c.out); {
+			select {
+			case r := <-c.out:
+				c.bufferMessage(r)
+				q.push(r, p.Bands)
+			default:
+				more = false
+			}
+		}
+		r := q.pop(p.MaxSkips)
+		// From now on counted as frames
+		atomic.AddInt64(&c.bufferedBytes, -knownLength(r))
+		if c.superseded(r) {
+			c.unbufferMessage()
+			c.failOutgoing(r, errMessageSuperseded)
+			continue
+		}
+		if m := outgoingMessage(r); m != nil && !m.Expires.IsZero() && time.Now().After(m.Expires) {
+			atomic.AddInt64(&c.expired, 1)
+			c.unbufferMessage()
+			c.failOutgoing(r, errMessageExpired)
+			continue
+		}
+		r, err := c.interceptOutgoing(r)
+		if err != nil {
+			c.unbufferMessage()
+			c.failOutgoing(r, err)
+			continue
+		}
+		req, _ := r.(*writeRequest)
+		if !c.track(r) {
+			c.unbufferMessage()
+			if req != nil && req.done != nil {
+				req.done <- errConnClosed
+			}
+			continue
+		}
+		n, err := c.sendMessage(r)
+		if err != nil {
+			c.unbufferMessage()
+			c.reportSent(r, err)
+		}
+		if err != nil && err != errConnClosed && err != errMessageAborted {
+			// The message can't be finished, the connection has to be failed
+			Log.Println(err)
+			c.sendClose(newErrConnection(statusInternalError, "Outgoing message failed"))
+		}
+		if req != nil && req.done != nil {
+			req.n = n
+			req.done <- err
+		}
+	}
+}
+
+// Fragment a message into frames as it is read, and queue them for sending.
+// Returns the payload length and the first error from reading r, after
+// which the message is unfinished.
+func (c *Conn) sendMessage(r io.Reader) (n int64, err error) {
+	defer func() {
+		c.mu.Lock()
+		if err != nil && c.aborted == r {
+			err = errMessageAborted
+		}
+		c.partial = nil
+		c.mu.Unlock()
+	}()
+	op := opCodeText // First frame, text unless a binary message
+	if messageType(r) == BinaryMessage {
+		op = opCodeBinary
+	}
+	if m := outgoingMessage(r); m != nil {
+		if rf, ok := m.Reader.(*relayedFrames); ok {
+			return c.sendRelayed(r, rf, op)
+		}
+	}
+	if req, ok := r.(*writeRequest); ok && req.size > 0 {
+		// Read by the send loop while the frame is written
+		fh, _ := newFrameHeader(true, op, req.size, c.mask())
+		f := newFrame(fh, req.Reader)
+		f.sent = r
+		if err = c.queue(f); err == nil {
+			n = req.size
+		}
+		return
+	}
+	if req, ok := r.(*writeRequest); ok && req.payload != nil {
+		fh, _ := newFrameHeader(true, op, int64(len(req.payload)), c.mask())
+		f := newFrame(fh, bytes.NewReader(req.payload))
+		f.sent = r
+		if err = c.queue(f); err == nil {
+			n = int64(len(req.payload))
+		}
+		return
+	}
+	for {
+		f, length, err := c.arenaFrame(r, op)
+		if f == nil && err == nil {
+			buf := bytes.NewBuffer(make([]byte, 0, maxFramePayload))
+			length, err = io.CopyN(buf, r, maxFramePayload)
+			fh, _ := newFrameHeader(err == io.EOF, op, length, c.mask())
 			f = newFrame(fh, buf)
-			c.send <- f
-			op = opCodeContinuation
 		}
+		n += length
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		fin := err == io.EOF // Last frame
+		if fin {
+			f.sent = r
+		}
+		if err = c.queue(f); err != nil {
+			f.release()
+			return n, err
+		}
+		if fin {
+			return n, nil
+		}
+		c.mu.Lock()
+		c.partial = r
+		c.mu.Unlock()
+		op = opCodeContinuation
 	}
 }
 
+// The type of an outgoing message, text unless it says otherwise
+func messageType(r io.Reader) int {
+	switch m := r.(type) {
+	case *Message:
+		return m.Type
+	case *writeRequest:
+		return m.Type
+	}
+	return TextMessage
+}
+
+// An outgoing message whose sender waits for the result, see WriteFrom
+type writeRequest struct {
+	*Message
+	payload []byte     // If not nil, the whole message sent as a single frame
+	size    int64      // If positive, Reader is streamed as one frame of this length
+	n       int64      // Payload length, set before done is sent
+	done    chan error // Receives the result once the message is queued, if not nil
+}
+
+// Send everything read from r as one message of msgType, fragmented as it
+// is read. Blocks until the whole message is queued for sending, and
+// returns the payload length and the first error. If reading from r fails,
+// the message can't be completed, and the connection is closed with status
+// 1011.
+func (c *Conn) WriteFrom(msgType int, r io.Reader) (n int64, err error) {
+	return c.write(&writeRequest{Message: &Message{Type: msgType, Reader: r}})
+}
+
+// Send a message of msgType and exactly size bytes read from r, as one
+// frame. The payload is streamed from r while the frame is written, without
+// buffering, so messages of any size use bounded memory. Blocks until the
+// whole frame has been written. If r ends before size bytes, the frame
+// can't be completed and the connection fails.
+func (c *Conn) WriteSized(msgType int, r io.Reader, size int64) (err error) {
+	if size <= 0 {
+		_, err = c.WriteFrom(msgType, r)
+		return
+	}
+	written := make(chan error, 1)
+	m := &Message{Type: msgType, Reader: r, Sent: func(err error) { written <- err }}
+	if _, err = c.write(&writeRequest{Message: m, size: size}); err != nil {
+		return
+	}
+	return <-written
+}
+
+// Send a text message in a single frame, blocking until it is queued
+func (c *Conn) SendText(s string) (err error) {
+	_, err = c.write(&writeRequest{Message: &Message{Type: TextMessage}, payload: []byte(s)})
+	return
+}
+
+// Send a binary message in a single frame, blocking until it is queued
+func (c *Conn) SendBinary(b []byte) (err error) {
+	if b == nil {
+		b = []byte{}
+	}
+	_, err = c.write(&writeRequest{Message: &Message{Type: BinaryMessage}, payload: b})
+	return
+}
+
+// Queue a write request and wait for the result
+func (c *Conn) write(req *writeRequest) (n int64, err error) {
+	if c.State() != OPEN {
+		return 0, errConnClosed
+	}
+	req.done = make(chan error, 1)
+	if err = c.enqueue(req); err != nil {
+		return
+	}
+	select {
+	case err = <-req.done:
+		n = req.n
+	case <-c.done:
+		err = errConnClosed
+	}
+	return
+}
+
+// Queue an outgoing message like sending it on Out, but give up with
+// errConnClosed once the connection is closed
+func (c *Conn) enqueue(r io.Reader) (err error) {
+	select {
+	case c.Out <- r:
+		c.failLateUnsent()
+	case <-c.done:
+		err = errConnClosed
+	}
+	return
+}
+
+// Queue a frame for sending. Returns errConnClosed once the close frame
+// has been queued, or if the send loop has stopped.
+func (c *Conn) queue(f *frame) error {
+	return c.queueBefore(f, nil)
+}
+
+// Queue a frame like queue, but give up with os.ErrDeadlineExceeded if
+// expired fires first
+func (c *Conn) queueBefore(f *frame, expired <-chan time.Time) (err error) {
+	c.mu.Lock()
+	closeSent := c.closeSent
+	c.mu.Unlock()
+	if closeSent {
+		return errConnClosed
+	}
+	if c.budget != nil {
+		c.budget.add(c, f.memory())
+	}
+	atomic.AddInt64(&c.bufferedBytes, f.buffered())
+	select {
+	case c.send <- f:
+		return
+	case <-c.sendDone:
+		err = errConnClosed
+	case <-expired:
+		err = os.ErrDeadlineExceeded
+	}
+	if c.budget != nil {
+		c.budget.add(c, -f.memory())
+	}
+	atomic.AddInt64(&c.bufferedBytes, -f.buffered())
+	return
+}
+
 // Blocking send loop
 // Send loop processes frames, meaning that fragmented
-// messages can be sent
+// messages can be sent. Frames are flushed according to the flush policy.
+// Stops after the close frame.
 func (c *Conn) sendLoop() {
-	var err error
-	for f, ok := <-c.send; ok; f, ok = <-c.send {
-		_, err = c.rw.Write(f.header.Bytes())
+	defer c.wg.Done()
+	defer c.failUnsent()
+	defer close(c.sendDone)
+	var (
+		unflushed []io.Reader // Written messages, reported as sent when flushed
+		onFlush   []func()    // Of the written frames, called when flushed
+		written   int64       // Payload bytes written since the last flush
+		timer     *time.Timer // Flushes after the maximum delay, if started
+		expired   <-chan time.Time
+	)
+	flush := func() (err error) {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if err = c.rw.Flush(); err != nil {
+			return
+		}
+		atomic.AddInt64(&c.bufferedMessages, -int64(len(unflushed)))
+		atomic.AddInt64(&c.bufferedBytes, -written)
+		for _, r := range unflushed {
+			c.reportSent(r, nil)
+		}
+		for _, fn := range onFlush {
+			fn()
+		}
+		unflushed, onFlush, written = nil, nil, 0
+		return
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		var f *frame
+		select {
+		case f = <-c.send:
+		case <-expired:
+			if err := flush(); err != nil {
+				Log.Println(err)
+				c.destroy(false)
+				return
+			}
+			continue
+		case <-c.done:
+			return
+		}
+		var err error
+		if f.flushed != nil {
+			err = flush()
+			f.flushed <- err
+		} else if err = c.writeFrame(f); err == nil {
+			if c.budget != nil {
+				c.budget.add(c, -f.memory())
+			}
+			written += f.Len()
+			c.active()
+			if c.stats != nil && !f.header.IsControl() {
+				c.stats.frame(f, true)
+			}
+			if f.sent != nil {
+				unflushed = append(unflushed, f.sent)
+			}
+			if f.onFlush != nil {
+				onFlush = append(onFlush, f.onFlush)
+			}
+			policy := c.FlushPolicy()
+			if f.Op() == opCodeConnectionClose || policy.due(c.rw.Writer.Buffered(), len(c.send)) {
+				err = flush()
+			} else if timer == nil && policy.MaxDelay > 0 {
+				timer = time.NewTimer(policy.MaxDelay)
+				expired = timer.C
+			}
+		} else if f.sent != nil {
+			c.reportSent(f.sent, err)
+		}
+		closing := f.flushed == nil && f.Op() == opCodeConnectionClose
+		f.release()
 		if err != nil {
-			break
+			Log.Println(err)
+			c.destroy(false)
+			return
 		}
-		if f.header.payloadLength > 0 {
-			_, err = io.CopyN(c.rw, f.payload, f.header.payloadLength) // TODO: Payload length?
-			if err != nil {
-				break
+		if closing {
+			// Don't wait forever for the other end-point to close, unless
+			// half closed
+			c.mu.Lock()
+			if !c.halfClosed {
+				c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
 			}
-			c.rw.Flush()
+			c.mu.Unlock()
+			c.destroy(true)
+			return
 		}
 	}
-	c.destroy(true)
 }
 
-// Randomize a new masking key if client, or no masing if server
-// TODO
+// Write a frame to the buffered writer, without flushing
+func (c *Conn) writeFrame(f *frame) (err error) {
+	if _, err = c.rw.Write(f.header.Bytes()); err != nil {
+		return
+	}
+	_, err = f.readPayloadTo(c.rw) // Masks the payload if needed
+	return
+}
+
+// Randomize a new masking key if client, or no masking if server
 func (c *Conn) mask() (maskingKey []byte) {
-	return nil
+	if c.server {
+		return nil
+	}
+	random := io.Reader(rand.Reader)
+	if c.random != nil {
+		random = c.random
+	}
+	maskingKey = make([]byte, 4)
+	if _, err := io.ReadFull(random, maskingKey); err != nil {
+		// No frame may be sent without a proper key, so none is. The
+		// connection is closed before the frame with this one is queued.
+		c.fail(wrapError(ErrClosed, err))
+	}
+	return
 }
 
 // Read and respond to a ping frame
@@ -249,102 +1042,242 @@ func (c *Conn) processPing(f *frame) (err error) {
 	if err != nil {
 		return
 	}
+	c.mu.Lock()
+	h := c.pingHandler
+	c.mu.Unlock()
+	if handled, err := c.handleControl(h, payloadCopy.Bytes()); handled {
+		return err
+	}
 	pongFrameHeader, _ := newFrameHeader(true, opCodePong, f.Len(), c.mask())
 	pongFrame := newFrame(pongFrameHeader, &payloadCopy)
-	c.send <- pongFrame
+	c.queue(pongFrame) // Not sent if the connection is closing
 	return
 }
 
 // Read and respond to a pong frame
 func (c *Conn) processPong(f *frame) (err error) {
-	_, err = f.readPayloadTo(ioutil.Discard)
+	var payload bytes.Buffer
+	if _, err = f.readPayloadTo(&payload); err != nil {
+		return
+	}
+	c.rtt.pong(payload.Bytes(), time.Now())
+	c.mu.Lock()
+	h := c.pongHandler
+	c.mu.Unlock()
+	_, err = c.handleControl(h, payload.Bytes())
 	return
 }
 
-// Process a text frame
+// Process the first frame of a text or binary message
 func (c *Conn) processText(f *frame) (err error) {
 	// TODO: Incoming data MUST always be validated by both clients and servers.
-	if c.expectingContFrame {
+	if c.expectingContFrame || c.fragmenting != nil {
 		err = newErrConnection(statusProtocolError, "Received unexpected data frame (expecting continuation frame)")
 		return
 	}
-	var r *io.PipeReader
-	r, w := io.Pipe()
-	c.in <- r
+	if handle := c.fragmentHandler(); handle != nil {
+		c.fragmenting = &fragmentedMessage{int(f.Op()), handle}
+		return c.fragment(f)
+	}
+	if c.incoming() != nil || c.Delivery() == DeliverBuffered {
+		return c.reassemble(f)
+	}
+	if c.deliversDirectly(f) {
+		return c.deliverDirectly(f)
+	}
+	if f.header.Fin && c.Delivery() == DeliverHandoff {
+		return c.handOff(f)
+	}
+	w := c.newMessageStream()
+	select {
+	case c.in <- c.inFlightMessage(w.message(int(f.Op()))):
+	case <-c.inDone:
+		w.CloseWithError(io.ErrUnexpectedEOF) // Closing, nobody reads the message
+	}
+	// Closing closes the current writer, which releases the router if the
+	// message isn't read
+	c.mu.Lock()
+	c.currWriter = w
+	c.mu.Unlock()
 	_, err = f.readPayloadTo(w)
 	if err == io.ErrUnexpectedEOF {
 		w.CloseWithError(io.ErrUnexpectedEOF)
 		return
-	} else {
-		if f.header.fin {
-			w.Close() // Close the pipe writer with an EOF
-		} else {
-			c.currWriter = w
-		}
+	} else if f.header.Fin {
+		w.Close() // The message ends with an EOF
+		c.mu.Lock()
+		c.currWriter = nil
+		c.mu.Unlock()
 	}
 	return
 }
 
 // Read continuation frame into current write stream
 func (c *Conn) processContinuation(f *frame) (err error) {
-	if c.currWriter == nil {
+	if c.reassembled != nil {
+		return c.reassemble(f)
+	}
+	if c.fragmenting != nil {
+		return c.fragment(f)
+	}
+	c.mu.Lock()
+	w := c.currWriter
+	c.mu.Unlock()
+	if w == nil {
 		err = newErrConnection(statusProtocolError, "Recieved unexpected continuation frame")
 		return
 	}
-	w := c.currWriter
 	_, err = f.readPayloadTo(w)
 	if err == io.ErrUnexpectedEOF {
 		w.CloseWithError(io.ErrUnexpectedEOF)
-		err = newErrConnection(statusProtocolError, "The other end-point closed the TCP connection")
 		return
 	} else {
-		if f.header.fin {
-			w.Close() // Close the pipe writer with an EOF
+		if f.header.Fin {
+			w.Close() // The message ends with an EOF
+			c.mu.Lock()
 			c.currWriter = nil
-			w = nil
+			c.mu.Unlock()
 		}
 	}
 	return
 }
 
-// When called, closeReceived = true, c.State = OPEN | CLOSING
+// When called, the state is OPEN or CLOSING
 func (c *Conn) processConnectionClose(f *frame) (err error) {
-	c.State = CLOSING
-	_, err = f.readPayloadTo(ioutil.Discard) // TODO
-	if c.closeSent {
+	var payload bytes.Buffer
+	_, err = f.readPayloadTo(&payload)
+	c.mu.Lock()
+	if err == nil {
+		c.remoteClose = parseClosePayload(payload.Bytes())
+		if code := c.remoteClose.code; code != statusNoStatusRcvd && !sendableCloseCode(code) {
+			c.remoteClose = newErrConnection(statusProtocolError, "Invalid close code")
+		}
+		switch code := c.remoteClose.code; {
+		case c.closeSent, c.err != nil:
+		case code == statusNormalClosure, code == statusNoStatusRcvd:
+		default:
+			// The other end-point closed it for a reason of its own
+			c.err = &CloseError{Code: code, Text: c.remoteClose.reason}
+		}
+	}
+	if c.state == OPEN {
+		c.state = CLOSING
+	}
+	c.closeRecieved = true
+	closeSent := c.closeSent
+	h, remote := c.closeHandler, c.remoteClose
+	c.mu.Unlock()
+	if h != nil && err == nil {
+		if e := h(remote.code, remote.reason); e != nil {
+			Log.Println(e)
+		}
+	}
+	if closeSent {
 		// TODO: Can err affect internal logging?
 		c.destroy(true) // All done, both sent and recieved
 	} else {
 		if err != nil {
 			c.sendClose(newErrConnection(statusProtocolError, "Connection closed before close frame was sent"))
 		} else {
-			c.sendClose(errNormalClosure) // TODO: Mirror
+			// Mirror the status code
+			c.sendClose(newErrConnection(c.remoteClose.reply().code, ""))
 		}
 	}
 	return
 }
 
-// Close user communication channels
+// Parse the status code and reason from the payload of a close frame.
+// An empty payload gives statusNoStatusRcvd.
+func parseClosePayload(payload []byte) (e *errConnection) {
+	switch {
+	case len(payload) == 0:
+		e = newErrConnection(statusNoStatusRcvd, "")
+	case len(payload) == 1:
+		e = newErrConnection(statusProtocolError, "Malformed close frame")
+	default:
+		e = newErrConnection(binary.BigEndian.Uint16(payload), string(payload[2:]))
+	}
+	return
+}
+
+// The status to send in a close frame, in response to or on behalf of e.
+// Statuses which must not be sent are replaced with a normal closure.
+func (e *errConnection) reply() *errConnection {
+	switch e.code {
+	case statusNoStatusRcvd:
+		return errNormalClosure
+	case statusAbnormalClosure:
+		return newErrConnection(statusGoingAway, "")
+	}
+	return e
+}
+
+// Stop delivering incoming messages, In is closed once the router stops.
+// Can be called multiple times, e.g. by Close and after a read error.
 func (c *Conn) closing() {
-	c.State = CLOSING
-	close(c.in)
-	if c.currWriter != nil {
-		c.currWriter.CloseWithError(io.ErrUnexpectedEOF)
-		c.currWriter = nil
+	c.mu.Lock()
+	if c.state == OPEN {
+		c.state = CLOSING
+	}
+	w := c.currWriter
+	c.currWriter = nil
+	c.mu.Unlock()
+	c.closeIn.Do(func() { close(c.inDone) })
+	if w != nil {
+		w.CloseWithError(io.ErrUnexpectedEOF)
 	}
 }
 
 // Initiate closing handshake and close underlying TCP connection.
 // Discard all new incoming messages and terminate current outgoing messages.
-func (c *Conn) sendClose(e *errConnection) {
-	if c.closeSent {
+// Returns false, and does nothing, if it was already started or the
+// connection is closed.
+func (c *Conn) sendClose(e *errConnection) (started bool) {
+	return c.startClose(e, false)
+}
+
+// Send a close frame like sendClose. If half, incoming messages are still
+// delivered until the close frame of the other end-point arrives, see
+// CloseWrite. Otherwise a connection half closed stops delivering them.
+func (c *Conn) startClose(e *errConnection, half bool) (started bool) {
+	c.mu.Lock()
+	started = !c.closeSent && c.state != CLOSED
+	c.closeSent = true
+	wasHalf := c.halfClosed
+	c.halfClosed = half && started
+	if c.halfClosed && c.state == OPEN {
+		c.state = CLOSING
+	}
+	c.mu.Unlock()
+	if !started {
+		if wasHalf && !half {
+			// Stop waiting for the other end-point
+			c.closing()
+			c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		}
 		return
 	}
-	c.closing()
-	closeFrame, _ := newCloseFrame(e)
-	c.send <- closeFrame
-	close(c.send)
-	c.closeSent = true
+	if !half {
+		c.closing()
+	}
+	closeFrame, _ := newCloseFrame(e, c.mask())
+	atomic.AddInt64(&c.bufferedBytes, closeFrame.Len())
+	select {
+	case c.send <- closeFrame:
+	case <-c.sendDone:
+	}
+	return
+}
+
+// Close the connection right away because of err, without a closing
+// handshake
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+	c.destroy(false)
 }
 
 // Close TCP connection and set clean flag
@@ -352,13 +1285,33 @@ func (c *Conn) sendClose(e *errConnection) {
 // clean is false, in which case it destroys the connection anyway
 // Can thus be called multiple times
 func (c *Conn) destroy(clean bool) {
-	if (c.closeRecieved && c.closeSent) || !clean {
-		c.State = CLOSED
-		c.Cleanly = clean
+	c.mu.Lock()
+	done := c.state != CLOSED && ((c.closeRecieved && c.closeSent) || !clean)
+	if done {
+		c.state = CLOSED
+		c.cleanly = clean
+	}
+	c.mu.Unlock()
+	if done {
+		c.closing()
+		close(c.done)
+		if c.budget != nil {
+			c.budget.detach(c)
+		}
+		if c.stats != nil {
+			c.stats.detach(c)
+		}
 		if c.server || !clean {
 			c.conn.Close()
 		} else {
-			c.conn.SetDeadline(time.Now().Add(time.Second * 5))
+			// The client should wait for the server to close the TCP connection
+			c.conn.SetDeadline(time.Now().Add(closeTimeout))
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				io.Copy(ioutil.Discard, c.conn)
+				c.conn.Close()
+			}()
 		}
 		Log.Println("Conn stopped")
 	}
@@ -367,14 +1320,32 @@ func (c *Conn) destroy(clean bool) {
 // Blocking router method for incoming messages
 func (c *Conn) router() (err error) {
 	var f *frame
-	for !c.closeRecieved {
-		f, err = nextFrame(c.rw)
+	for f == nil || f.Op() != opCodeConnectionClose {
+		if c.budget != nil {
+			c.budget.waitToRead(c)
+		}
+		c.waitInFlight()
+		f, err = c.readFrame()
+		c.active()
+		atomic.AddInt64(&c.framesIn, 1)
 		// In the end of this loop, the payload must have been read
+		if err == nil {
+			err = c.checkMasking(f)
+		}
 		if err != nil {
+			var malformed *wsframe.HeaderError
+			if errors.As(err, &malformed) {
+				e := newErrConnection(statusProtocolError, malformed.Error())
+				e.err = malformed
+				return c.failWith(e)
+			}
 			return
 		}
 
-		if c.closeSent && f.Op() != opCodeConnectionClose {
+		c.mu.Lock()
+		closeSent := c.closeSent && !c.halfClosed
+		c.mu.Unlock()
+		if closeSent && f.Op() != opCodeConnectionClose {
 			// Waiting for other end sending close frame
 			// Ignore all frames except closing frames
 			if _, err = f.readPayloadTo(ioutil.Discard); err != nil {
@@ -384,16 +1355,24 @@ func (c *Conn) router() (err error) {
 			}
 		}
 
+		if !f.header.IsControl() {
+			if err = c.checkReadLimit(f); err != nil {
+				return
+			}
+			if c.stats != nil {
+				c.stats.frame(f, false)
+			}
+		}
+
 		switch f.Op() {
 		case opCodePing:
 			err = c.processPing(f)
 		case opCodePong:
 			err = c.processPong(f)
 		case opCodeConnectionClose:
-			c.closeRecieved = true
 			err = c.processConnectionClose(f)
 		case opCodeBinary:
-			fallthrough // Currently binary and text are recieved in the same way
+			fallthrough // Binary and text are recieved in the same way
 		case opCodeText:
 			err = c.processText(f)
 		case opCodeContinuation:
@@ -407,12 +1386,90 @@ func (c *Conn) router() (err error) {
 	return
 }
 
-// Close the websocket connection in a normal way
-func (c *Conn) Close() {
-	c.sendClose(errNormalClosure)
+// Queue a message for sending according to its delivery class. A droppable
+// message is discarded with errMessageDropped if the send queue is full. A
+// must-deliver message waits for room in the queue, for at most EvictTimeout
+// if set, after which the too slow connection is closed with errSlowClient.
+func (c *Conn) Send(m *Message) (err error) {
+	if c.State() != OPEN {
+		return errConnClosed
+	}
+	if m.QoS == Droppable {
+		select {
+		case c.Out <- m:
+			c.failLateUnsent()
+		default:
+			atomic.AddInt64(&c.dropped, 1)
+			err = errMessageDropped
+		}
+		return
+	}
+	if c.EvictTimeout <= 0 {
+		return c.enqueue(m)
+	}
+	timer := time.NewTimer(c.EvictTimeout)
+	defer timer.Stop()
+	select {
+	case c.Out <- m:
+		c.failLateUnsent()
+	case <-c.done:
+		err = errConnClosed
+	case <-timer.C:
+		c.sendClose(newErrConnection(statusPolicyViolation, "Too slow to receive"))
+		err = errSlowClient
+	}
+	return
 }
 
-func wsClientHandshake(r *http.Request) (secWSAccept string, err error) {
+// Number of droppable messages discarded by Send
+func (c *Conn) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Send a control frame, a ping, pong or connection close. It may be sent
+// between the fragments of a message being sent. The payload can be at
+// most 125 bytes. A close frame payload must be empty or start with a
+// status code which may be sent, followed by a UTF-8 reason, and starts the
+// closing handshake. Returns
+// os.ErrDeadlineExceeded if the frame can't be queued before the
+// deadline, zero meaning no deadline.
+func (c *Conn) WriteControl(opCode byte, payload []byte, deadline time.Time) (err error) {
+	if (opCode != opCodePing && opCode != opCodePong && opCode != opCodeConnectionClose) ||
+		len(payload) > 125 || (opCode == opCodeConnectionClose && len(payload) == 1) {
+		return errInvalidControlFrame
+	}
+	if opCode == opCodeConnectionClose && len(payload) >= 2 &&
+		(!sendableCloseCode(binary.BigEndian.Uint16(payload)) || !utf8.Valid(payload[2:])) {
+		return errInvalidControlFrame
+	}
+	if c.State() != OPEN {
+		return errConnClosed
+	}
+	if opCode == opCodeConnectionClose {
+		c.sendClose(parseClosePayload(payload))
+		return
+	}
+	fh, _ := newFrameHeader(true, opCode, int64(len(payload)), c.mask())
+	timer := deadlineTimer(deadline)
+	defer timer.Stop()
+	return c.queueBefore(newFrame(fh, bytes.NewReader(append([]byte(nil), payload...))), timer.C)
+}
+
+// Close the websocket connection in a normal way. Safe to call from any
+// goroutine, any number of times. If the connection is already closing,
+// nothing is done and the error which ended it is returned, or
+// errConnClosed if there was none.
+func (c *Conn) Close() (err error) {
+	if c.sendClose(errNormalClosure) {
+		return nil
+	}
+	if err = c.Err(); err == nil {
+		err = errConnClosed
+	}
+	return
+}
+
+func wsClientHandshake(r *http.Request, compliance Compliance) (secWSAccept string, err error) {
 
 	// Check HTTP version
 	if !r.ProtoAtLeast(minProtoMajor, minProtoMinor) {
@@ -421,34 +1478,88 @@ func wsClientHandshake(r *http.Request) (secWSAccept string, err error) {
 	}
 
 	// Check HTTP header identifier for WebSocket
-	if !(strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
-		strings.EqualFold(r.Header.Get("Connection"), "Upgrade")) {
+	if !(headerContainsToken(r.Header, "Upgrade", "websocket") &&
+		connectionUpgrade(r.Header, compliance)) {
 		err = errMalformedClientHandshake
 		return
 	}
 
 	// Check WebSocket version
-	if clientSecWSVersion, errFormat :=
-		// TODO: Header.Get() just returns the first value, could be multiple
-		strconv.Atoi(r.Header.Get("Sec-WebSocket-Version")); !(errFormat == nil && clientSecWSVersion == secWSVersion) {
-		err = errMalformedClientHandshake
+	if _, ok := negotiateVersion(headerTokens(r.Header, "Sec-WebSocket-Version")); !ok {
+		err = errUnsupportedVersion
 		return
 	}
 
-	// Check Sec-WebSocket-Key
+	// Check Sec-WebSocket-Key, which must not appear more than once
+	if len(r.Header["Sec-Websocket-Key"]) != 1 {
+		err = errMalformedSecWSKey
+		return
+	}
 	secWSKey := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
 	return validateSecWebSocketKey(secWSKey)
 }
 
+// All comma separated values of a header, aggregated from every header line
+// with the name, in order. Empty values are skipped.
+func headerTokens(header http.Header, name string) (tokens []string) {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return
+}
+
+// True if any of the tokens in the header is token, ignoring case.
+// For instance "Connection: keep-alive, Upgrade" contains the token "Upgrade".
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick the most preferred supported version among the versions offered by
+// the client. Ok is false if there is none.
+func negotiateVersion(offered []string) (version int, ok bool) {
+	for _, version = range supportedVersions {
+		for _, o := range offered {
+			if v, err := strconv.Atoi(o); err == nil && v == version {
+				ok = true
+				return
+			}
+		}
+	}
+	return
+}
+
+// The Sec-WebSocket-Version header value listing all supported versions
+func supportedVersionsHeader() string {
+	versions := make([]string, len(supportedVersions))
+	for i, v := range supportedVersions {
+		versions[i] = strconv.Itoa(v)
+	}
+	return strings.Join(versions, ", ")
+}
+
 // Validate and return the Sec-WebSocket-Accept calculated by the
 // Sec-WebSocket-Key value.
 func validateSecWebSocketKey(key string) (secWSAccept string, err error) {
 	if decodedKey, _ := base64.StdEncoding.DecodeString(key); err != nil || len(decodedKey) != secWSKeyLength {
 		err = errMalformedSecWSKey
 	} else {
-		h := sha1.New()
-		h.Write([]byte(key + guid)) // sha1(key + guid)
-		secWSAccept = base64.StdEncoding.EncodeToString(h.Sum(nil))
+		secWSAccept = secWebSocketAccept(key)
 	}
 	return
 }
+
+// Calculate the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func secWebSocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + guid)) // sha1(key + guid)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
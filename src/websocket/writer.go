@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"bytes"
+)
+
+// An io.Writer sending text messages on a connection, for code which writes
+// to an io.Writer, such as loggers and encoders.
+type TextWriter struct {
+	c *Conn
+
+	// If true, writes are collected into one message until Flush is called.
+	// Otherwise every Write is sent as one message.
+	Buffered bool
+	buf      []byte
+}
+
+// A writer where every Write is sent as one text message
+func (c *Conn) TextWriter() *TextWriter {
+	return &TextWriter{c: c}
+}
+
+func (w *TextWriter) Write(p []byte) (n int, err error) {
+	if w.c.State() != OPEN {
+		err = errConnClosed
+		return
+	}
+	if w.Buffered {
+		w.buf = append(w.buf, p...)
+	} else {
+		if err = w.c.enqueue(bytes.NewBuffer(append([]byte(nil), p...))); err != nil {
+			return
+		}
+	}
+	n = len(p)
+	return
+}
+
+// Send the writes collected since the last Flush as one message. Does
+// nothing if nothing has been written.
+func (w *TextWriter) Flush() (err error) {
+	if len(w.buf) == 0 {
+		return
+	}
+	if w.c.State() != OPEN {
+		err = errConnClosed
+		return
+	}
+	if err = w.c.enqueue(bytes.NewBuffer(w.buf)); err != nil {
+		return
+	}
+	w.buf = nil
+	return
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+)
+
+func TestTextWriter(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	logger := log.New(c.TextWriter(), "", 0)
+	logger.Print("first")
+	logger.Print("second")
+	for _, expected := range []string{"first\n", "second\n"} {
+		m := (<-c.In).(*Message)
+		if msg, _ := ioutil.ReadAll(m); m.Type != TextMessage || string(msg) != expected {
+			t.Errorf("Expected text message %q, got type %v, %q", expected, m.Type, msg)
+		}
+	}
+
+	w := c.TextWriter()
+	w.Buffered = true
+	fmt.Fprint(w, "one ")
+	fmt.Fprint(w, "message")
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := ioutil.ReadAll(<-c.In); string(msg) != "one message" {
+		t.Errorf("Unexpected buffered message %q", msg)
+	}
+}
+
+// Returns some data, then fails
+type failingReader struct {
+	data []byte
+}
+
+var errReaderFailed = errors.New("Reader failed")
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if len(r.data) == 0 {
+		return 0, errReaderFailed
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	return
+}
+
+func TestWriteFrom(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	payload := bytes.Repeat([]byte{7}, 10*maxFramePayload+1)
+	n, err := c.WriteFrom(BinaryMessage, bytes.NewReader(payload))
+	if err != nil || n != int64(len(payload)) {
+		t.Fatalf("WriteFrom returned %v, %v", n, err)
+	}
+	m := (<-c.In).(*Message)
+	if echo, _ := ioutil.ReadAll(m); m.Type != BinaryMessage || !bytes.Equal(echo, payload) {
+		t.Errorf("Echo mismatch: type %v, %v bytes", m.Type, len(echo))
+	}
+}
+
+func TestWriteFromFailingReader(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.WriteFrom(TextMessage, &failingReader{data: make([]byte, 3*maxFramePayload)})
+	if err != errReaderFailed {
+		t.Errorf("Expected errReaderFailed, got %v", err)
+	}
+	if _, err = c.WriteFrom(TextMessage, new(bytes.Buffer)); err != errConnClosed {
+		t.Errorf("Expected errConnClosed after failed message, got %v", err)
+	}
+}
+
+func TestSendTextAndBinary(t *testing.T) {
+	server := setupEchoServer(t, func(h http.Handler) http.Handler { return h })
+	defer server.Close()
+	c, _, err := Dial(wsURL(server), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := string(bytes.Repeat([]byte("x"), 3*maxFramePayload))
+	if err = c.SendText(long); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SendBinary([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	m := (<-c.In).(*Message)
+	if msg, _ := ioutil.ReadAll(m); m.Type != TextMessage || string(msg) != long {
+		t.Errorf("Unexpected text echo: type %v, %v bytes", m.Type, len(msg))
+	}
+	m = (<-c.In).(*Message)
+	if msg, _ := ioutil.ReadAll(m); m.Type != BinaryMessage || !bytes.Equal(msg, []byte{1, 2, 3}) {
+		t.Errorf("Unexpected binary echo: type %v, %v", m.Type, msg)
+	}
+	c.Close()
+	if err = c.SendText("closed"); err != errConnClosed {
+		t.Errorf("Expected errConnClosed, got %v", err)
+	}
+}
+
+func TestSendTextSingleFrame(t *testing.T) {
+	h, client := setupServerAndHandshake(t)
+	c := <-h.Conns
+	defer c.Close()
+	if err := c.SendText(string(make([]byte, 300))); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(client, header); err != nil {
+		t.Fatal(err)
+	}
+	// Final text frame with a 16 bit payload length of 300
+	if !bytes.Equal(header, []byte{0x81, 126, 0x01, 0x2C}) {
+		t.Errorf("Not sent as a single frame: %X", header)
+	}
+}
@@ -0,0 +1,390 @@
+// Package wsframe encodes and decodes websocket frames (RFC 6455, section
+// 5.2), without a connection around them. It is the codec used by package
+// websocket, and can be used on its own by proxies, sniffers and custom
+// servers.
+package wsframe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Opcodes
+const (
+	OpContinuation = byte(0x00)
+	OpText         = byte(0x01)
+	OpBinary       = byte(0x02)
+
+	// Control frames are identified by opcodes where the most significant bit of
+	// the opcode is 1.
+	OpControl = byte(0x08) // MSB of opCode
+	OpClose   = byte(0x08)
+	OpPing    = byte(0x09)
+	OpPong    = byte(0x0A)
+)
+
+// Bitmasks for protocol
+const (
+	fin            = byte(0x80)
+	rsvMask        = byte(0x70)
+	opCodeMask     = byte(0x0F)
+	mask           = byte(0x80)
+	payloadLength7 = byte(0x7F)
+)
+
+// Maximum payload length of control frames
+const MaxControlPayload = 125
+
+// Any malformed frame header. The errors returned are *HeaderError, which
+// match it with errors.Is.
+var ErrMalformedHeader = errors.New("Malformed frame header")
+
+// A malformed frame header, and what was wrong with it
+type HeaderError struct {
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return "Malformed frame header: " + e.Reason
+}
+
+func (e *HeaderError) Is(target error) bool {
+	return target == ErrMalformedHeader
+}
+
+// What may be wrong with a frame header
+var (
+	ErrReservedBits      = &HeaderError{"RSV bits set without extension"}
+	ErrUnknownOpCode     = &HeaderError{"Unknown opcode"}
+	ErrFragmentedControl = &HeaderError{"Fragmented control frame"}
+	ErrControlTooLong    = &HeaderError{"Control frame payload over 125 bytes"}
+	ErrNegativeLength    = &HeaderError{"Negative payload length"}
+	ErrNonMinimalLength  = &HeaderError{"Payload length not minimally encoded"}
+	ErrLengthMSB         = &HeaderError{"Most significant bit of the payload length set"}
+	ErrMaskingKeyLength  = &HeaderError{"Masking key not 4 bytes"}
+)
+
+var opCodeDescriptions = map[byte]string{
+	OpContinuation: "continuation frame",
+	OpText:         "text frame",
+	OpBinary:       "binary frame",
+	OpClose:        "connection close",
+	OpPing:         "ping",
+	OpPong:         "pong",
+}
+
+type Header struct {
+	Fin           bool
+	OpCode        byte
+	Masked        bool
+	PayloadLength int64
+	MaskingKey    []byte
+}
+
+// Create and validate new frame header.
+// If maskingKey is NOT nil, h.Masked will be true.
+// Validates and returns a *HeaderError if any rules are broken.
+func NewHeader(fin bool, opCode byte, payloadLength int64, maskingKey []byte) (h *Header, err error) {
+	if err = validate(fin, opCode, payloadLength, maskingKey); err != nil {
+		return
+	}
+	h = &Header{
+		Fin:           fin,
+		OpCode:        opCode,
+		Masked:        maskingKey != nil,
+		PayloadLength: payloadLength,
+		MaskingKey:    maskingKey,
+	}
+	return
+}
+
+// Check the rules of NewHeader
+func validate(fin bool, opCode byte, payloadLength int64, maskingKey []byte) error {
+	if _, ok := opCodeDescriptions[opCode]; !ok {
+		// If an unknown opcode is received, the receiving endpoint MUST _Fail the
+		// WebSocket Connection_.
+		return ErrUnknownOpCode
+	}
+	// All control frames MUST have a payload length of 125 bytes or less and
+	// MUST NOT be fragmented.
+	controlFrame := opCode&OpControl != 0
+	if controlFrame && !fin {
+		return ErrFragmentedControl
+	}
+	if controlFrame && payloadLength > MaxControlPayload {
+		return ErrControlTooLong
+	}
+	if payloadLength < 0 {
+		return ErrNegativeLength
+	}
+	if maskingKey != nil && len(maskingKey) != 4 {
+		return ErrMaskingKeyLength
+	}
+	return nil
+}
+
+// Reads and parses the websocket frame header.
+// The error is EOF only if no bytes were read. If an EOF happens after reading
+// some but not all the bytes, ReadHeader returns ErrUnexpectedEOF.
+// If the frame header is malformed, the error is a *HeaderError.
+func ReadHeader(r io.Reader) (h *Header, err error) {
+	return new(HeaderReader).Read(r)
+}
+
+// Reads frame headers like ReadHeader, into the same Header and buffers
+// every time, so that reading a header doesn't allocate. The Header
+// returned, with its masking key, is only valid until the next read.
+type HeaderReader struct {
+	buf    [8]byte
+	key    [4]byte
+	header Header
+}
+
+// Read the next frame header from r, see ReadHeader
+func (hr *HeaderReader) Read(r io.Reader) (h *Header, err error) {
+	// The first two bytes, containing most of the header data
+	op := hr.buf[:2]
+	if _, err = io.ReadFull(r, op); err != nil {
+		return
+	}
+	if rsvMask&op[0] != 0 {
+		// No RSV bits are allowed without extension
+		err = ErrReservedBits
+		return
+	}
+	var (
+		final         = op[0]&fin != 0
+		opCode        = op[0] & opCodeMask
+		payloadLength = int64(op[1] & payloadLength7)
+		masked        = op[1]&mask != 0
+		maskingKey    []byte
+	)
+
+	// Read the extended payload length
+	if payloadLength == 126 {
+		if _, err = io.ReadFull(r, hr.buf[:2]); err != nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		len16 := binary.BigEndian.Uint16(hr.buf[:2])
+		if len16 < 126 {
+			// Minimum number of bytes not used
+			err = ErrNonMinimalLength
+			return
+		}
+		payloadLength = int64(len16)
+	} else if payloadLength == 127 {
+		if _, err = io.ReadFull(r, hr.buf[:8]); err != nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		len64 := binary.BigEndian.Uint64(hr.buf[:8])
+		if len64 > math.MaxInt64 {
+			// The most significant bit must be 0
+			err = ErrLengthMSB
+			return
+		}
+		if len64 <= math.MaxUint16 {
+			// Minimum number of bytes not used
+			err = ErrNonMinimalLength
+			return
+		}
+		payloadLength = int64(len64)
+	}
+
+	// If payload is masked, read masking key
+	if masked {
+		maskingKey = hr.key[:]
+		if _, err = io.ReadFull(r, maskingKey); err != nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+	}
+	if err = validate(final, opCode, payloadLength, maskingKey); err != nil {
+		return
+	}
+	hr.header = Header{
+		Fin:           final,
+		OpCode:        opCode,
+		Masked:        masked,
+		PayloadLength: payloadLength,
+		MaskingKey:    maskingKey,
+	}
+	return &hr.header, nil
+}
+
+// True if the header is of a control frame, (ping, pong or connection close)
+func (h *Header) IsControl() bool {
+	return h.OpCode&OpControl != 0
+}
+
+func (h *Header) String() (s string) {
+	operation, ok := opCodeDescriptions[h.OpCode]
+	if !ok {
+		operation = fmt.Sprintf("invalid operation [%X]", h.OpCode)
+	}
+	return fmt.Sprintf("Fin: %t, Op: %s, Mask: %t, PayloadLen: %v, MaskingKey: %X",
+		h.Fin, operation, h.Masked, h.PayloadLength, h.MaskingKey)
+}
+
+// Converts frame header to binary data ready to be sent.
+// Warning, this method DOES ignore certain aspects of the header such as rsv
+// bits. Does not validate op code.
+func (h *Header) Bytes() []byte {
+	// Use buffer to prevent errors
+	buffer := bytes.NewBuffer(make([]byte, 0, 14))
+	if h.Fin {
+		buffer.WriteByte(fin | h.OpCode)
+	} else {
+		buffer.WriteByte(h.OpCode)
+	}
+	var (
+		baseLen byte // First length byte
+		lenLen  int  // Length of the extended payload length (bytes)
+	)
+	extLen := make([]byte, 8)
+	if h.PayloadLength > math.MaxUint16 {
+		baseLen = 127
+		lenLen = 8
+		binary.BigEndian.PutUint64(extLen, uint64(h.PayloadLength))
+	} else if h.PayloadLength > 125 {
+		baseLen = 126
+		lenLen = 2
+		binary.BigEndian.PutUint16(extLen, uint16(h.PayloadLength))
+	} else {
+		baseLen = byte(h.PayloadLength)
+		lenLen = 0
+	}
+	extLen = extLen[:lenLen]
+	if h.Masked {
+		baseLen |= mask
+	}
+	buffer.WriteByte(baseLen)
+	buffer.Write(extLen)
+	if h.Masked {
+		buffer.Write(h.MaskingKey)
+	}
+	return buffer.Bytes()
+}
+
+// Read the next frame from r. The payload reads the unmasked payload from
+// r, and must be read to the end before the next frame.
+func Decode(r io.Reader) (h *Header, payload io.Reader, err error) {
+	if h, err = ReadHeader(r); err != nil {
+		return
+	}
+	payload = PayloadReader(h, r)
+	return
+}
+
+// Write a frame with header h and the payload read from payload, which must
+// be h.PayloadLength bytes. The payload is masked if h is. Err will be
+// io.ErrUnexpectedEOF if the payload ended prematurely.
+func Encode(w io.Writer, h *Header, payload io.Reader) (err error) {
+	if _, err = w.Write(h.Bytes()); err != nil {
+		return
+	}
+	_, err = CopyPayload(w, h, payload)
+	return
+}
+
+// A reader of the payload of the frame with header h from r. It never reads
+// past the payload, and masks if h.Masked is set, which also unmasks
+// incoming payloads.
+func PayloadReader(h *Header, r io.Reader) io.Reader {
+	p := new(Payload)
+	p.Reset(h, r)
+	return p
+}
+
+// A reader of the payload of a frame, like PayloadReader, which can be
+// reused from frame to frame without allocating
+type Payload struct {
+	r          io.Reader
+	left       int64  // Payload bytes not yet read
+	maskingKey []byte // Nil if not masked
+	pos        int    // Position in the masking key
+}
+
+// Start reading the payload of the frame with header h from r
+func (p *Payload) Reset(h *Header, r io.Reader) {
+	*p = Payload{r: r, left: h.PayloadLength}
+	if h.Masked {
+		p.maskingKey = h.MaskingKey
+	}
+}
+
+func (p *Payload) Read(b []byte) (n int, err error) {
+	if p.left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.left {
+		b = b[:p.left]
+	}
+	n, err = p.r.Read(b)
+	p.left -= int64(n)
+	if p.maskingKey != nil {
+		p.pos = Mask(p.maskingKey, p.pos, b[:n])
+	}
+	return
+}
+
+// Copy the payload of the frame with header h from r to w, masking if
+// h.Masked is set. Err will be io.ErrUnexpectedEOF if the payload ended
+// prematurely.
+func CopyPayload(w io.Writer, h *Header, r io.Reader) (n int64, err error) {
+	if h.PayloadLength == 0 { // No payload
+		return
+	}
+	n, err = io.Copy(w, PayloadReader(h, r))
+	if err == nil && n < h.PayloadLength {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// Apply the masking key to b in place, starting at position pos of the key.
+// Returns the position to continue from with the next bytes of the payload.
+// Masking is its own inverse, so this also unmasks.
+func Mask(maskingKey []byte, pos int, b []byte) int {
+	i := 0
+	for ; i < len(b) && pos != 0; i++ {
+		b[i] ^= maskingKey[pos]
+		pos = (pos + 1) % 4
+	}
+	// Mask 8 bytes at a time, starting at the beginning of the key
+	if len(b)-i >= 8 {
+		key := uint64(binary.LittleEndian.Uint32(maskingKey))
+		key |= key << 32
+		for ; len(b)-i >= 8; i += 8 {
+			binary.LittleEndian.PutUint64(b[i:], binary.LittleEndian.Uint64(b[i:])^key)
+		}
+	}
+	for ; i < len(b); i++ {
+		b[i] ^= maskingKey[pos]
+		pos = (pos + 1) % 4
+	}
+	return pos
+}
+
+// Applies a masking key to everything read through it
+type maskReader struct {
+	r          io.Reader
+	maskingKey []byte
+	pos        int // Position in the masking key
+}
+
+// A reader which masks, or unmasks, everything read from r
+func NewMaskReader(r io.Reader, maskingKey []byte) io.Reader {
+	return &maskReader{r: r, maskingKey: maskingKey}
+}
+
+func (m *maskReader) Read(p []byte) (n int, err error) {
+	n, err = m.r.Read(p)
+	m.pos = Mask(m.maskingKey, m.pos, p[:n])
+	return
+}
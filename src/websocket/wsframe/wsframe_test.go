@@ -0,0 +1,162 @@
+package wsframe
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	payload := bytes.Repeat([]byte("Hello"), 100)
+	h, err := NewHeader(true, OpBinary, int64(len(payload)), []byte{0x37, 0xfa, 0x21, 0x3d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err = Encode(buf, h, bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("Hello")) {
+		t.Error("Payload wasn't masked")
+	}
+	decoded, r, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Fin || decoded.OpCode != OpBinary || !decoded.Masked || decoded.PayloadLength != h.PayloadLength {
+		t.Errorf("Decoded header mismatch: %v", decoded)
+	}
+	got, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Decoded payload mismatch: %q", got)
+	}
+}
+
+func TestEncodeShortPayload(t *testing.T) {
+	h, _ := NewHeader(true, OpText, 5, nil)
+	if err := Encode(new(bytes.Buffer), h, bytes.NewBufferString("Hi")); err == nil {
+		t.Error("Expected an error for a short payload")
+	}
+}
+
+func TestMask(t *testing.T) {
+	key := []byte{1, 2, 3, 4}
+	b := []byte("Hello")
+	pos := Mask(key, 0, b[:3])
+	Mask(key, pos, b[3:])
+	if !bytes.Equal(b, []byte{'H' ^ 1, 'e' ^ 2, 'l' ^ 3, 'l' ^ 4, 'o' ^ 1}) {
+		t.Errorf("Wrong masking: %X", b)
+	}
+}
+
+func TestNonMinimalLength(t *testing.T) {
+	// A 5 byte payload using the 16 bit extended payload length
+	if _, err := ReadHeader(bytes.NewReader([]byte{0x81, 0x7E, 0x00, 0x05})); err != ErrNonMinimalLength {
+		t.Errorf("Expected %v, got %v", ErrNonMinimalLength, err)
+	}
+}
+
+// Every boundary of the three length encodings
+func TestPayloadLengthEncoding(t *testing.T) {
+	tests := []struct {
+		header []byte
+		length int64 // -1 if malformed
+	}{
+		{[]byte{0x82, 0}, 0},
+		{[]byte{0x82, 125}, 125},
+		{[]byte{0x82, 126, 0, 0}, -1},
+		{[]byte{0x82, 126, 0, 125}, -1},
+		{[]byte{0x82, 126, 0, 126}, 126},
+		{[]byte{0x82, 126, 0xff, 0xff}, 0xffff},
+		{[]byte{0x82, 127, 0, 0, 0, 0, 0, 0, 0, 0}, -1},
+		{[]byte{0x82, 127, 0, 0, 0, 0, 0, 0, 0, 125}, -1},
+		{[]byte{0x82, 127, 0, 0, 0, 0, 0, 0, 0xff, 0xff}, -1},
+		{[]byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0}, 0x10000},
+		{[]byte{0x82, 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 1<<63 - 1},
+		{[]byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0}, -1},
+		{[]byte{0x82, 127, 0x80, 0, 0, 0, 0, 1, 0, 0}, -1},
+		{[]byte{0x82, 127, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, -1},
+	}
+	for _, test := range tests {
+		h, err := ReadHeader(bytes.NewReader(test.header))
+		if test.length < 0 {
+			if !errors.Is(err, ErrMalformedHeader) {
+				t.Errorf("%X: expected %v, got %v", test.header, ErrMalformedHeader, err)
+			}
+		} else if err != nil || h.PayloadLength != test.length {
+			t.Errorf("%X: expected length %v, got %v", test.header, test.length, err)
+		}
+	}
+}
+
+// Lengths around the 32 bit boundary survive encoding and decoding
+func TestLargePayloadLength(t *testing.T) {
+	for _, length := range []int64{1<<31 - 1, 1 << 31, 1<<32 - 1, 1 << 32, 1<<32 + 1, 1<<63 - 1} {
+		h, err := NewHeader(true, OpBinary, length, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ReadHeader(bytes.NewReader(h.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.PayloadLength != length {
+			t.Errorf("Expected length %v, got %v", length, decoded.PayloadLength)
+		}
+	}
+	// The most significant bit of the 64 bit length must be 0
+	if _, err := ReadHeader(bytes.NewReader([]byte{0x82, 0x7F, 0x80, 0, 0, 0, 0, 0, 0, 0})); err != ErrLengthMSB {
+		t.Errorf("Expected %v, got %v", ErrLengthMSB, err)
+	}
+}
+
+// Masking many bytes at a time gives the same result as one at a time
+func TestMaskUnaligned(t *testing.T) {
+	key := []byte{0x37, 0xfa, 0x21, 0x3d}
+	for pos := 0; pos < 4; pos++ {
+		for length := 0; length < 40; length++ {
+			b := bytes.Repeat([]byte{0xAA}, length)
+			expected := append([]byte(nil), b...)
+			p := pos
+			for i := range expected {
+				expected[i] ^= key[p]
+				p = (p + 1) % 4
+			}
+			if end := Mask(key, pos, b); end != p || !bytes.Equal(b, expected) {
+				t.Errorf("Wrong masking at position %v of %v bytes", pos, length)
+			}
+		}
+	}
+}
+
+// Each rule broken gives its own error
+func TestHeaderErrors(t *testing.T) {
+	tests := []struct {
+		header []byte
+		err    error
+	}{
+		{[]byte{0xc1, 0}, ErrReservedBits},
+		{[]byte{0x83, 0}, ErrUnknownOpCode},
+		{[]byte{0x09, 0}, ErrFragmentedControl},
+		{[]byte{0x89, 126, 0, 126}, ErrControlTooLong},
+		{[]byte{0x82, 126, 0, 5}, ErrNonMinimalLength},
+		{[]byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0}, ErrLengthMSB},
+	}
+	for _, test := range tests {
+		_, err := ReadHeader(bytes.NewReader(test.header))
+		if err != test.err {
+			t.Errorf("%X: expected %v, got %v", test.header, test.err, err)
+		}
+		var e *HeaderError
+		if !errors.Is(err, ErrMalformedHeader) || !errors.As(err, &e) {
+			t.Errorf("%X: %v isn't a malformed header error", test.header, err)
+		}
+	}
+	if _, err := NewHeader(true, OpText, 1, []byte{1, 2}); err != ErrMaskingKeyLength {
+		t.Errorf("Expected %v, got %v", ErrMaskingKeyLength, err)
+	}
+	if _, err := NewHeader(true, OpText, -1, nil); err != ErrNegativeLength {
+		t.Errorf("Expected %v, got %v", ErrNegativeLength, err)
+	}
+}